@@ -0,0 +1,453 @@
+package sdstore
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Backend is an interface that storage backends have to implement.
+//
+// Names are slash or OS separated paths as composed from the store's Path
+// and Name, and the collection and record names.
+type Backend interface {
+	// ReadFile returns the content of the named file.
+	ReadFile(name string) ([]byte, error)
+
+	// WriteFile replaces the content of the named file. Readers must never
+	// observe a partially written file.
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+
+	// Remove removes the named file or empty directory.
+	Remove(name string) error
+
+	// RemoveAll removes name and everything it contains.
+	RemoveAll(name string) error
+
+	// Rename renames a file or directory.
+	Rename(oldname string, newname string) error
+
+	// Stat returns a FileInfo describing the named file or directory.
+	Stat(name string) (fs.FileInfo, error)
+
+	// ReadDir returns the entries of the named directory sorted by name.
+	ReadDir(name string) ([]fs.DirEntry, error)
+
+	// MkdirAll creates the named directory and any missing parents.
+	MkdirAll(name string, perm fs.FileMode) error
+
+	// Lock acquires an exclusive lock identified by name and returns a function
+	// releasing it.
+	Lock(name string) (unlock func() error, err error)
+}
+
+// linker is implemented by backends that can create hard links, which allows
+// backups to take a snapshot without copying the data.
+type linker interface {
+	Link(oldname string, newname string) error
+}
+
+// WithBackend is an option to set the store's storage backend.
+func WithBackend(b Backend) StoreOption {
+	return func(s *SDStore) {
+		s.Backend = b
+	}
+}
+
+// withBackend is an option to set the Collection's storage backend.
+func withBackend(b Backend) CollectionOption {
+	return func(c *Collection) {
+		c.Backend = b
+	}
+}
+
+// tempCounter makes names returned by tempName unique within the process.
+var tempCounter uint64
+
+// tempName returns a unique name with the provided prefix for a temporary
+// file or directory.
+func tempName(prefix string) string {
+	n := atomic.AddUint64(&tempCounter, 1)
+	return prefix + strconv.FormatInt(time.Now().UnixNano(), 36) + strconv.FormatUint(n, 36)
+}
+
+// walkDir walks the file tree rooted at root in lexical order, calling fn for
+// each file or directory. It behaves like filepath.WalkDir for the provided Backend.
+func walkDir(b Backend, root string, fn fs.WalkDirFunc) error {
+	info, err := b.Stat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walkDirEntry(b, root, fs.FileInfoToDirEntry(info), fn)
+	}
+
+	if errors.Is(err, filepath.SkipDir) {
+		return nil
+	}
+	return err
+}
+
+// walkDirEntry recursively descends path, calling fn.
+func walkDirEntry(b Backend, path string, d fs.DirEntry, fn fs.WalkDirFunc) error {
+	if err := fn(path, d, nil); err != nil || !d.IsDir() {
+		if errors.Is(err, filepath.SkipDir) && d.IsDir() {
+			err = nil
+		}
+		return err
+	}
+
+	entries, err := b.ReadDir(path)
+	if err != nil {
+		// Second call, to report the ReadDir error.
+		if err := fn(path, d, err); err != nil {
+			if errors.Is(err, filepath.SkipDir) {
+				err = nil
+			}
+			return err
+		}
+	}
+
+	for _, e := range entries {
+		if err := walkDirEntry(b, filepath.Join(path, e.Name()), e, fn); err != nil {
+			if errors.Is(err, filepath.SkipDir) {
+				break
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DirBackend is a Backend storing files in directories on the local filesystem.
+// It is the default Backend.
+type DirBackend struct{}
+
+// ReadFile implements the Backend interface for DirBackend.
+func (DirBackend) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+// WriteFile implements the Backend interface for DirBackend.
+//
+// The data is written to a temporary file first, which is renamed to name once
+// it's complete.
+func (DirBackend) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	return writeFileAtomic(name, data, perm)
+}
+
+// Remove implements the Backend interface for DirBackend.
+func (DirBackend) Remove(name string) error {
+	return os.Remove(name)
+}
+
+// RemoveAll implements the Backend interface for DirBackend.
+func (DirBackend) RemoveAll(name string) error {
+	return os.RemoveAll(name)
+}
+
+// Rename implements the Backend interface for DirBackend.
+func (DirBackend) Rename(oldname string, newname string) error {
+	return os.Rename(oldname, newname)
+}
+
+// Stat implements the Backend interface for DirBackend.
+func (DirBackend) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+// ReadDir implements the Backend interface for DirBackend.
+func (DirBackend) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+// MkdirAll implements the Backend interface for DirBackend.
+func (DirBackend) MkdirAll(name string, perm fs.FileMode) error {
+	return os.MkdirAll(name, perm)
+}
+
+// Link creates newname as a hard link to oldname.
+func (DirBackend) Link(oldname string, newname string) error {
+	return os.Link(oldname, newname)
+}
+
+// Lock implements the Backend interface for DirBackend.
+//
+// The lock is held on the file name, which is created if it doesn't exist,
+// and excludes other processes where the operating system supports it.
+func (DirBackend) Lock(name string) (func() error, error) {
+	return lockFile(name)
+}
+
+// MemBackend is a Backend keeping all files in memory. It's intended for tests
+// and ephemeral caches.
+type MemBackend struct {
+	mu    sync.RWMutex
+	files map[string]*memFile
+	dirs  map[string]time.Time
+	locks map[string]*sync.Mutex
+}
+
+// memFile is a file in a MemBackend.
+type memFile struct {
+	data    []byte
+	perm    fs.FileMode
+	modTime time.Time
+}
+
+// NewMemBackend returns an empty MemBackend.
+func NewMemBackend() *MemBackend {
+	return &MemBackend{
+		files: make(map[string]*memFile),
+		dirs:  make(map[string]time.Time),
+		locks: make(map[string]*sync.Mutex),
+	}
+}
+
+// memPath returns the canonical form of name.
+func memPath(name string) string {
+	return filepath.Clean(name)
+}
+
+// isRoot returns true if name is a root directory, which always exists.
+func isRoot(name string) bool {
+	return name == "." || name == string(filepath.Separator)
+}
+
+// ReadFile implements the Backend interface for MemBackend.
+func (m *MemBackend) ReadFile(name string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	f, ok := m.files[memPath(name)]
+	if !ok {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return append([]byte(nil), f.data...), nil
+}
+
+// WriteFile implements the Backend interface for MemBackend.
+func (m *MemBackend) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p := memPath(name)
+	if _, ok := m.dirs[p]; ok {
+		return &fs.PathError{Op: "write", Path: name, Err: errors.New("is a directory")}
+	}
+	if dir := filepath.Dir(p); !m.isDir(dir) {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrNotExist}
+	}
+
+	m.files[p] = &memFile{
+		data:    append([]byte(nil), data...),
+		perm:    perm,
+		modTime: time.Now(),
+	}
+	return nil
+}
+
+// Remove implements the Backend interface for MemBackend.
+func (m *MemBackend) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p := memPath(name)
+	if _, ok := m.files[p]; ok {
+		delete(m.files, p)
+		return nil
+	}
+
+	if _, ok := m.dirs[p]; ok {
+		if len(m.children(p)) != 0 {
+			return &fs.PathError{Op: "remove", Path: name, Err: errors.New("directory not empty")}
+		}
+		delete(m.dirs, p)
+		return nil
+	}
+
+	return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+}
+
+// RemoveAll implements the Backend interface for MemBackend.
+func (m *MemBackend) RemoveAll(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p := memPath(name)
+	prefix := p + string(filepath.Separator)
+	for k := range m.files {
+		if k == p || strings.HasPrefix(k, prefix) {
+			delete(m.files, k)
+		}
+	}
+	for k := range m.dirs {
+		if k == p || strings.HasPrefix(k, prefix) {
+			delete(m.dirs, k)
+		}
+	}
+
+	return nil
+}
+
+// Rename implements the Backend interface for MemBackend.
+func (m *MemBackend) Rename(oldname string, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	from, to := memPath(oldname), memPath(newname)
+	if !m.isDir(filepath.Dir(to)) {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: fs.ErrNotExist}
+	}
+
+	if f, ok := m.files[from]; ok {
+		delete(m.files, from)
+		m.files[to] = f
+		return nil
+	}
+
+	if _, ok := m.dirs[from]; !ok {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: fs.ErrNotExist}
+	}
+	if _, ok := m.dirs[to]; ok && len(m.children(to)) != 0 {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: fs.ErrExist}
+	}
+
+	// Move the directory and everything it contains.
+	prefix := from + string(filepath.Separator)
+	for k, f := range m.files {
+		if strings.HasPrefix(k, prefix) {
+			delete(m.files, k)
+			m.files[to+k[len(from):]] = f
+		}
+	}
+	for k, t := range m.dirs {
+		if k == from || strings.HasPrefix(k, prefix) {
+			delete(m.dirs, k)
+			m.dirs[to+k[len(from):]] = t
+		}
+	}
+
+	return nil
+}
+
+// Stat implements the Backend interface for MemBackend.
+func (m *MemBackend) Stat(name string) (fs.FileInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	p := memPath(name)
+	if f, ok := m.files[p]; ok {
+		return memFileInfo{name: filepath.Base(p), size: int64(len(f.data)), mode: f.perm, modTime: f.modTime}, nil
+	}
+	if m.isDir(p) {
+		return memFileInfo{name: filepath.Base(p), mode: fs.ModeDir | defaultDirPerm, modTime: m.dirs[p]}, nil
+	}
+
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+// ReadDir implements the Backend interface for MemBackend.
+func (m *MemBackend) ReadDir(name string) ([]fs.DirEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	p := memPath(name)
+	if !m.isDir(p) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	var entries []fs.DirEntry
+	for _, child := range m.children(p) {
+		if f, ok := m.files[child]; ok {
+			entries = append(entries, fs.FileInfoToDirEntry(memFileInfo{name: filepath.Base(child), size: int64(len(f.data)), mode: f.perm, modTime: f.modTime}))
+			continue
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(memFileInfo{name: filepath.Base(child), mode: fs.ModeDir | defaultDirPerm, modTime: m.dirs[child]}))
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// MkdirAll implements the Backend interface for MemBackend.
+func (m *MemBackend) MkdirAll(name string, _ fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for p := memPath(name); !isRoot(p); p = filepath.Dir(p) {
+		if _, ok := m.files[p]; ok {
+			return &fs.PathError{Op: "mkdir", Path: name, Err: errors.New("not a directory")}
+		}
+		if _, ok := m.dirs[p]; !ok {
+			m.dirs[p] = time.Now()
+		}
+	}
+
+	return nil
+}
+
+// Lock implements the Backend interface for MemBackend.
+func (m *MemBackend) Lock(name string) (func() error, error) {
+	m.mu.Lock()
+	l, ok := m.locks[memPath(name)]
+	if !ok {
+		l = &sync.Mutex{}
+		m.locks[memPath(name)] = l
+	}
+	m.mu.Unlock()
+
+	l.Lock()
+	return func() error {
+		l.Unlock()
+		return nil
+	}, nil
+}
+
+// isDir returns true if p is an existing directory.
+func (m *MemBackend) isDir(p string) bool {
+	if isRoot(p) {
+		return true
+	}
+	_, ok := m.dirs[p]
+	return ok
+}
+
+// children returns the paths of the direct children of the directory p.
+func (m *MemBackend) children(p string) []string {
+	var children []string
+	for k := range m.files {
+		if filepath.Dir(k) == p {
+			children = append(children, k)
+		}
+	}
+	for k := range m.dirs {
+		if filepath.Dir(k) == p && k != p {
+			children = append(children, k)
+		}
+	}
+	return children
+}
+
+// memFileInfo implements fs.FileInfo for files and directories in a MemBackend.
+type memFileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (i memFileInfo) Name() string       { return i.name }
+func (i memFileInfo) Size() int64        { return i.size }
+func (i memFileInfo) Mode() fs.FileMode  { return i.mode }
+func (i memFileInfo) ModTime() time.Time { return i.modTime }
+func (i memFileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i memFileInfo) Sys() any           { return nil }
@@ -0,0 +1,384 @@
+package sdstore
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backupManifestName is the name of the manifest entry in a backup archive.
+const backupManifestName = "sdstore-manifest.json"
+
+// BackupFile describes a file in a backup.
+type BackupFile struct {
+	Size     int64  `json:"size"`
+	Checksum uint32 `json:"checksum"`
+}
+
+// BackupManifest describes the contents of a backup. It lists every file of
+// the store at the time of the backup, including the files that have been left
+// out of an incremental backup because they didn't change.
+//
+// The manifest of a backup can be stored and passed to WithIncremental to
+// create a backup containing only the changes since.
+type BackupManifest struct {
+	Store       string                `json:"store"`
+	Created     time.Time             `json:"created"`
+	Incremental bool                  `json:"incremental"`
+	Files       map[string]BackupFile `json:"files"`
+}
+
+// BackupOption is an option for a Backup.
+type BackupOption func(*backupOptions)
+
+// backupOptions holds the settings of a Backup.
+type backupOptions struct {
+	since *BackupManifest
+}
+
+// WithIncremental is an option to only include files that have been added or
+// changed since the backup described by since.
+func WithIncremental(since *BackupManifest) BackupOption {
+	return func(o *backupOptions) {
+		o.since = since
+	}
+}
+
+// Backup writes a consistent snapshot of all collections of the store to w as
+// a tar archive and returns its manifest.
+//
+// Writes are blocked only while the snapshot is taken, which hard links the
+// files of the store into a temporary directory. Records and indexes are
+// replaced rather than modified in place, so the linked files don't change
+// while they are archived.
+func (s *SDStore) Backup(ctx context.Context, w io.Writer, opts ...BackupOption) (*BackupManifest, error) {
+	var bo backupOptions
+	for _, opt := range opts {
+		opt(&bo)
+	}
+
+	snapshot := filepath.Join(s.Path, s.Name, tempName(".backup-"))
+	if err := s.Backend.MkdirAll(snapshot, defaultDirPerm); err != nil {
+		return nil, fmt.Errorf("creating snapshot directory: %w", err)
+	}
+	defer s.Backend.RemoveAll(snapshot)
+
+	if err := s.snapshot(ctx, snapshot); err != nil {
+		return nil, fmt.Errorf("creating snapshot: %w", err)
+	}
+
+	manifest := BackupManifest{
+		Store:       s.Name,
+		Created:     time.Now().UTC(),
+		Incremental: bo.since != nil,
+		Files:       make(map[string]BackupFile),
+	}
+
+	var include []string
+	if err := walkDir(s.Backend, snapshot, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		b, err := s.Backend.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(snapshot, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		f := BackupFile{Size: int64(len(b)), Checksum: crc32.Checksum(b, crc32c)}
+		manifest.Files[rel] = f
+
+		if bo.since != nil {
+			if prev, ok := bo.since.Files[rel]; ok && prev == f {
+				return nil
+			}
+		}
+		include = append(include, rel)
+
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("reading snapshot: %w", err)
+	}
+	sort.Strings(include)
+
+	// Write the manifest first, so Restore knows what to expect.
+	tw := tar.NewWriter(w)
+	mb, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("encoding manifest: %w", err)
+	}
+	if err := writeTarFile(tw, backupManifestName, mb, manifest.Created); err != nil {
+		return nil, err
+	}
+
+	for _, rel := range include {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		b, err := s.Backend.ReadFile(filepath.Join(snapshot, filepath.FromSlash(rel)))
+		if err != nil {
+			return nil, fmt.Errorf("reading snapshot: %w", err)
+		}
+		if err := writeTarFile(tw, rel, b, manifest.Created); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("writing archive: %w", err)
+	}
+
+	return &manifest, nil
+}
+
+// snapshot links the records and indexes of all collections and the store
+// manifest into dir while writes to the store are blocked. Files are copied if
+// the backend can't link them.
+//
+// Writers of this store are blocked by the gate and writers in other processes
+// by the lock of every collection.
+func (s *SDStore) snapshot(ctx context.Context, dir string) error {
+	s.gate.Lock()
+	defer s.gate.Unlock()
+
+	names, err := s.collectionNames()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		unlock, err := s.Backend.Lock(collectionLock(filepath.Join(s.Path, s.Name), name))
+		if err != nil {
+			return fmt.Errorf("locking collection %q: %w", name, err)
+		}
+		defer unlock()
+	}
+
+	for _, name := range names {
+		src := filepath.Join(s.Path, s.Name, name)
+		dst := filepath.Join(dir, name)
+		if err := s.Backend.MkdirAll(dst, defaultDirPerm); err != nil {
+			return err
+		}
+
+		if err := walkDir(s.Backend, src, func(from string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			rel, err := filepath.Rel(src, from)
+			if err != nil {
+				return err
+			}
+			to := filepath.Join(dst, rel)
+
+			if d.IsDir() {
+				return s.Backend.MkdirAll(to, defaultDirPerm)
+			}
+			if !isStoreFile(d.Name()) {
+				return nil
+			}
+			return s.linkFile(from, to)
+		}); err != nil {
+			return err
+		}
+	}
+
+	err = s.linkFile(s.manifestPath(), filepath.Join(dir, manifestFile))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// linkFile links from to to, or copies it if the backend can't link files.
+func (s *SDStore) linkFile(from string, to string) error {
+	if l, ok := s.Backend.(linker); ok {
+		if err := l.Link(from, to); err == nil {
+			return nil
+		}
+	}
+
+	b, err := s.Backend.ReadFile(from)
+	if err != nil {
+		return err
+	}
+	return s.Backend.WriteFile(to, b, defaultFilePerm)
+}
+
+// RestoreOption is an option for Restore.
+type RestoreOption func(*restoreOptions)
+
+// restoreOptions holds the settings of a Restore.
+type restoreOptions struct {
+	backend Backend
+}
+
+// WithRestoreBackend is an option to restore a backup into the storage backend
+// b instead of the file system.
+func WithRestoreBackend(b Backend) RestoreOption {
+	return func(o *restoreOptions) {
+		o.backend = b
+	}
+}
+
+// Restore extracts a backup created by Backup from r into the store directory dir
+// and returns its manifest.
+//
+// Backups are restored in order: first the full backup followed by each incremental
+// backup. Restoring an incremental backup removes the records and indexes that
+// are not listed in its manifest.
+func Restore(r io.Reader, dir string, opts ...RestoreOption) (*BackupManifest, error) {
+	ro := restoreOptions{backend: DirBackend{}}
+	for _, opt := range opts {
+		opt(&ro)
+	}
+	b := ro.backend
+
+	tr := tar.NewReader(r)
+
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("reading archive: %w", err)
+	}
+	if hdr.Name != backupManifestName {
+		return nil, fmt.Errorf("reading archive: missing manifest")
+	}
+
+	var manifest BackupManifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("decoding manifest: %w", err)
+	}
+
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading archive: %w", err)
+		}
+
+		name, ok := localName(hdr.Name)
+		if !ok || !isStoreFile(name) {
+			return nil, fmt.Errorf("reading archive: invalid entry %q", hdr.Name)
+		}
+
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", hdr.Name, err)
+		}
+
+		path := filepath.Join(dir, name)
+		if err := b.MkdirAll(filepath.Dir(path), defaultDirPerm); err != nil {
+			return nil, err
+		}
+		if err := b.WriteFile(path, data, defaultFilePerm); err != nil {
+			return nil, fmt.Errorf("restoring %s: %w", hdr.Name, err)
+		}
+	}
+
+	if !manifest.Incremental {
+		return &manifest, nil
+	}
+
+	// Remove files that were deleted since the previous backup.
+	if err := walkDir(b, dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == LostAndFound {
+			return filepath.SkipDir
+		}
+		if d.IsDir() || !isStoreFile(d.Name()) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if _, ok := manifest.Files[filepath.ToSlash(rel)]; ok {
+			return nil
+		}
+
+		return b.Remove(path)
+	}); err != nil {
+		return nil, fmt.Errorf("removing deleted files: %w", err)
+	}
+
+	return &manifest, nil
+}
+
+// writeTarFile writes a regular file to tw.
+func writeTarFile(tw *tar.Writer, name string, b []byte, modTime time.Time) error {
+	hdr := tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     int64(defaultFilePerm),
+		Size:     int64(len(b)),
+		ModTime:  modTime,
+	}
+	if err := tw.WriteHeader(&hdr); err != nil {
+		return fmt.Errorf("writing archive: %w", err)
+	}
+	if _, err := tw.Write(b); err != nil {
+		return fmt.Errorf("writing archive: %w", err)
+	}
+	return nil
+}
+
+// metaFiles are the files describing a collection, which are stored in the
+// collection directory next to its records.
+var metaFiles = []string{layoutFile, sequenceFile, namesFile}
+
+// isStoreFile returns true if name is a record, index, meta or manifest file,
+// or the tombstone of one in the upper layer of an OverlayBackend.
+func isStoreFile(name string) bool {
+	name = strings.TrimSuffix(name, tombstoneSuffix)
+	if strings.HasSuffix(name, ".sds") || strings.HasSuffix(name, ".sdx") || filepath.Base(name) == manifestFile {
+		return true
+	}
+	for _, meta := range metaFiles {
+		if filepath.Base(name) == meta {
+			return true
+		}
+	}
+	return false
+}
+
+// localName returns the cleaned, OS specific form of the slash separated name
+// and false if it would escape the directory it's relative to.
+func localName(name string) (string, bool) {
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return clean, true
+}
@@ -0,0 +1,151 @@
+package sdstore_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/toqns/sdstore"
+)
+
+func TestBackupRestore(t *testing.T) {
+	ctx := context.Background()
+	store, err := sdstore.New("backup", t.TempDir(), sdstore.WithJSONEncoding())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+	}
+	t.Logf("%s\tShould be able to create a new store.", success)
+
+	c, err := store.Collection("test", Record{}, sdstore.WithIndexedFields("Email"))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+	}
+	t.Logf("%s\tShould be able to create a new collection.", success)
+
+	recs := []Record{
+		{ID: "1", Name: "One", Email: "one@example.com"},
+		{ID: "2", Name: "Two", Email: "two@example.com"},
+	}
+	for _, rec := range recs {
+		if err := c.Create(rec.ID, rec); err != nil {
+			t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+		}
+	}
+
+	var full bytes.Buffer
+	manifest, err := store.Backup(ctx, &full)
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a full backup: %v.", failed, err)
+	}
+	t.Logf("%s\tShould be able to create a full backup.", success)
+
+	// Two records, the index, the marker of encoded record names and the store
+	// manifest.
+	if got, exp := len(manifest.Files), 5; got != exp {
+		t.Fatalf("%s\tShould list %d files in the manifest, got: %d.", failed, exp, got)
+	}
+	t.Logf("%s\tShould list all files in the manifest.", success)
+
+	// Change the store and create an incremental backup.
+	recs[0].Name = "Uno"
+	if err := c.Update(recs[0].ID, recs[0]); err != nil {
+		t.Fatalf("%s\tShould be able to update a record: %v.", failed, err)
+	}
+	if err := c.Delete(recs[1].ID); err != nil {
+		t.Fatalf("%s\tShould be able to delete a record: %v.", failed, err)
+	}
+	rec3 := Record{ID: "3", Name: "Three", Email: "three@example.com"}
+	if err := c.Create(rec3.ID, rec3); err != nil {
+		t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+	}
+
+	var incr bytes.Buffer
+	incrManifest, err := store.Backup(ctx, &incr, sdstore.WithIncremental(manifest))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create an incremental backup: %v.", failed, err)
+	}
+	t.Logf("%s\tShould be able to create an incremental backup.", success)
+
+	if incr.Len() >= full.Len() {
+		t.Fatalf("%s\tShould create a smaller incremental backup: %d >= %d.", failed, incr.Len(), full.Len())
+	}
+
+	// The changed and the new record, the index and the backup manifest, which
+	// records the deleted record by leaving it out.
+	var names []string
+	tr := tar.NewReader(bytes.NewReader(incr.Bytes()))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("%s\tShould be able to read the incremental backup: %v.", failed, err)
+		}
+		names = append(names, hdr.Name)
+	}
+	exp := []string{"sdstore-manifest.json", "test/1.sds", "test/3.sds", "test/test.sdx"}
+	if diff := cmp.Diff(names, exp); diff != "" {
+		t.Fatalf("%s\tShould only include the changes in the incremental backup: %v.", failed, diff)
+	}
+	if _, ok := incrManifest.Files["test/2.sds"]; ok {
+		t.Fatalf("%s\tShould leave the deleted record out of the incremental backup manifest.", failed)
+	}
+	t.Logf("%s\tShould only include the changes in the incremental backup.", success)
+
+	mem := sdstore.NewMemBackend()
+	for _, tt := range []struct {
+		name    string
+		path    string
+		backend sdstore.Backend
+	}{
+		{name: "directory", path: t.TempDir(), backend: sdstore.DirBackend{}},
+		{name: "backend", path: "/mem", backend: mem},
+	} {
+		for _, b := range []*bytes.Buffer{&full, &incr} {
+			r := bytes.NewReader(b.Bytes())
+			if _, err := sdstore.Restore(r, filepath.Join(tt.path, "restored"), sdstore.WithRestoreBackend(tt.backend)); err != nil {
+				t.Fatalf("%s\tShould be able to restore a backup into a %s: %v.", failed, tt.name, err)
+			}
+		}
+		t.Logf("%s\tShould be able to restore the backups into a %s.", success, tt.name)
+
+		restored, err := sdstore.New("restored", tt.path, sdstore.WithJSONEncoding(), sdstore.WithBackend(tt.backend))
+		if err != nil {
+			t.Fatalf("%s\tShould be able to open the restored store: %v.", failed, err)
+		}
+		checkRestored(t, restored, []Record{recs[0], rec3}, recs[1].ID)
+	}
+}
+
+// checkRestored verifies that store contains the records exp and not the
+// deleted record.
+func checkRestored(t *testing.T, restored *sdstore.SDStore, exp []Record, deleted string) {
+	t.Helper()
+
+	rc, err := restored.Collection("test", Record{}, sdstore.WithIndexedFields("Email"))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to open the restored collection: %v.", failed, err)
+	}
+
+	for _, exp := range exp {
+		var got Record
+		if err := rc.GetIndexed("Email", exp.Email, &got); err != nil {
+			t.Fatalf("%s\tShould be able to get restored record %q: %v.", failed, exp.ID, err)
+		}
+		if diff := cmp.Diff(got, exp); diff != "" {
+			t.Fatalf("%s\tShould get expected result: %v.", failed, diff)
+		}
+	}
+	t.Logf("%s\tShould be able to get the restored records.", success)
+
+	if err := rc.Get(deleted, &Record{}); !errors.Is(err, sdstore.ErrNotFound) {
+		t.Fatalf("%s\tShould not restore the deleted record: %v.", failed, err)
+	}
+	t.Logf("%s\tShould not restore the deleted record.", success)
+}
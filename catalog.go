@@ -0,0 +1,492 @@
+package sdstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// manifestFile is the name of the file in the store directory that records
+// the collections of the store.
+const manifestFile = "manifest.json"
+
+// manifestVersion is the version of the manifest format.
+const manifestVersion = 1
+
+var (
+	// ErrCollectionExists is an error returned when a user attempts to rename a
+	// collection to the name of an existing collection.
+	ErrCollectionExists = errors.New("collection already exists")
+
+	// ErrEncodingMismatch is an error returned when a store or collection is opened
+	// with a different encoding than the one recorded in the store manifest.
+	ErrEncodingMismatch = errors.New("encoding doesn't match the recorded encoding")
+
+	// ErrRecordTypeMismatch is an error returned when a collection is opened with
+	// a different record type than the one recorded in the store manifest.
+	ErrRecordTypeMismatch = errors.New("record type doesn't match the recorded record type")
+)
+
+// storeManifest is the on-disk form of the store manifest.
+type storeManifest struct {
+	Version     int                            `json:"version"`
+	Encoding    string                         `json:"encoding,omitempty"`
+	Collections map[string]*collectionManifest `json:"collections"`
+}
+
+// collectionManifest describes a collection in the store manifest.
+type collectionManifest struct {
+	Created       time.Time `json:"created"`
+	Encoding      string    `json:"encoding,omitempty"`
+	RecordType    string    `json:"recordType,omitempty"`
+	SchemaVersion int       `json:"schemaVersion,omitempty"`
+	IndexedFields []string  `json:"indexedFields,omitempty"`
+
+	// References are the references of the collection, so the delete rules
+	// are applied when it isn't open.
+	References []referenceManifest `json:"references,omitempty"`
+}
+
+// referenceManifest describes a reference of a collection in the store manifest.
+type referenceManifest struct {
+	Field    string   `json:"field"`
+	Target   string   `json:"target"`
+	OnDelete OnDelete `json:"onDelete"`
+}
+
+// WithRecordedEncoding is an option to use the encodings recorded in the manifest
+// of an existing store, instead of failing with ErrEncodingMismatch when they differ
+// from the configured encoding. New stores and stores without a recorded encoding
+// use the configured encoding.
+func WithRecordedEncoding() StoreOption {
+	return func(s *SDStore) {
+		s.recordedEncoding = true
+	}
+}
+
+// initManifest records the encoding of new stores in the store manifest, or
+// selects the recorded encoding of existing stores if requested.
+func (s *SDStore) initManifest() error {
+	m, err := s.loadManifest()
+	if err != nil {
+		return err
+	}
+
+	if m.Encoding != "" {
+		if rc, ok := lookupCodec(m.Encoding); ok && s.recordedEncoding {
+			withNamedEncoding(m.Encoding, rc.encoder, rc.decoder)(s)
+		}
+		return nil
+	}
+
+	if s.encoding == "" || s.readOnly {
+		return nil
+	}
+
+	// The encoding of a store with collections created before it had a manifest
+	// isn't known, so it isn't recorded. Its collections record their encoding
+	// when they are opened.
+	names, err := s.collectionNames()
+	if err != nil {
+		return err
+	}
+	if len(names) > 0 {
+		return nil
+	}
+
+	return s.updateManifest(func(m *storeManifest) error {
+		m.Encoding = s.encoding
+		return nil
+	})
+}
+
+// applyRecordedEncoding checks the encoding of c against the encoding recorded
+// in the store manifest.
+//
+// Collections that override the encoding of the store use their recorded
+// encoding, as do all collections if requested with WithRecordedEncoding.
+// Otherwise a different encoding fails with ErrEncodingMismatch.
+//
+// Encodings set with WithEncoding have no name and aren't checked, codecs
+// registered with RegisterCodec are recorded by name.
+func (s *SDStore) applyRecordedEncoding(c *Collection) error {
+	m, err := s.loadManifest()
+	if err != nil {
+		return err
+	}
+
+	cm, ok := m.Collections[c.Name]
+	if !ok || cm.Encoding == "" || cm.Encoding == c.encoding {
+		return nil
+	}
+
+	mismatch := fmt.Errorf("%w: collection %q is %s encoded, opened as %s", ErrEncodingMismatch, c.Name, cm.Encoding, c.encoding)
+	if c.encodingSet {
+		if c.encoding == "" {
+			return nil
+		}
+		return mismatch
+	}
+
+	override := cm.Encoding != m.Encoding
+	if !override && !s.recordedEncoding {
+		if c.encoding == "" {
+			return nil
+		}
+		return mismatch
+	}
+
+	rc, ok := lookupCodec(cm.Encoding)
+	if !ok {
+		return fmt.Errorf("%w: collection %q is %s encoded", ErrUnknownCodec, c.Name, cm.Encoding)
+	}
+	withEncoding(rc.encoder, rc.decoder)(c)
+	withEncodingName(cm.Encoding)(c)
+
+	return nil
+}
+
+// WithRecordTypeChange is an option to open a collection with another record
+// type than the one recorded in the store manifest, such as after the record
+// type has been renamed, instead of failing with ErrRecordTypeMismatch. The
+// new record type is recorded. Collections opened with migrations to a higher
+// schema version may change their record type without this option.
+func WithRecordTypeChange() CollectionOption {
+	return func(c *Collection) {
+		c.recordTypeChange = true
+	}
+}
+
+// checkRecordType checks the record type of c against the record type recorded
+// in the store manifest.
+func (s *SDStore) checkRecordType(c *Collection) error {
+	if c.record == nil || c.recordTypeChange {
+		return nil
+	}
+
+	m, err := s.loadManifest()
+	if err != nil {
+		return err
+	}
+
+	// A migration to a higher schema version may change the record type.
+	cm, ok := m.Collections[c.Name]
+	if !ok || cm.RecordType == "" || cm.RecordType == c.record.String() || c.SchemaVersion > cm.SchemaVersion {
+		return nil
+	}
+	return fmt.Errorf("%w: collection %q holds %s, opened with %s", ErrRecordTypeMismatch, c.Name, cm.RecordType, c.record)
+}
+
+// CollectionInfo describes a collection of a store.
+type CollectionInfo struct {
+	Name string
+
+	// Records is the number of records in the collection.
+	Records int
+
+	// Size is the size on disk of the records and indexes of the collection in bytes.
+	Size int64
+
+	// IndexedFields are the fields indexed by the collection.
+	IndexedFields []string
+
+	// Encoding is the name of the encoding of the collection,
+	// empty if the encoding was set with WithEncoding.
+	Encoding string
+
+	// RecordType is the name of the record type the collection was last opened with.
+	RecordType string
+
+	// SchemaVersion is the schema version the collection was last opened with.
+	SchemaVersion int
+
+	// Created is the time the collection was first opened, zero for collections
+	// created before the store had a manifest.
+	Created time.Time
+}
+
+// manifestPath returns the name of the store manifest.
+func (s *SDStore) manifestPath() string {
+	return filepath.Join(s.Path, s.Name, manifestFile)
+}
+
+// loadManifest returns the store manifest, which is empty if the store
+// doesn't have a manifest yet.
+func (s *SDStore) loadManifest() (*storeManifest, error) {
+	m := storeManifest{
+		Version:     manifestVersion,
+		Collections: make(map[string]*collectionManifest),
+	}
+
+	b, err := s.Backend.ReadFile(s.manifestPath())
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return &m, nil
+		}
+		return nil, fmt.Errorf("loading manifest: %w", err)
+	}
+
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("loading manifest: %w", err)
+	}
+	if m.Version > manifestVersion {
+		return nil, fmt.Errorf("loading manifest: unsupported version %d", m.Version)
+	}
+	if m.Collections == nil {
+		m.Collections = make(map[string]*collectionManifest)
+	}
+
+	return &m, nil
+}
+
+// saveManifest stores the store manifest.
+func (s *SDStore) saveManifest(m *storeManifest) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding manifest: %w", err)
+	}
+
+	if err := s.Backend.WriteFile(s.manifestPath(), b, defaultFilePerm); err != nil {
+		return fmt.Errorf("saving manifest: %w", err)
+	}
+	return nil
+}
+
+// updateManifest applies f to the store manifest and stores the result.
+func (s *SDStore) updateManifest(f func(m *storeManifest) error) error {
+	s.manifestMu.Lock()
+	defer s.manifestMu.Unlock()
+
+	m, err := s.loadManifest()
+	if err != nil {
+		return err
+	}
+	if err := f(m); err != nil {
+		return err
+	}
+	return s.saveManifest(m)
+}
+
+// register records the opened collection c in the store manifest.
+func (s *SDStore) register(c *Collection) error {
+	if s.readOnly {
+		return nil
+	}
+
+	s.gate.RLock()
+	defer s.gate.RUnlock()
+
+	return s.updateManifest(func(m *storeManifest) error {
+		cm, ok := m.Collections[c.Name]
+		if !ok {
+			cm = &collectionManifest{Created: time.Now().UTC()}
+			m.Collections[c.Name] = cm
+		}
+		if c.encoding != "" || c.encodingSet {
+			cm.Encoding = c.encoding
+		}
+		if c.record != nil {
+			cm.RecordType = c.record.String()
+		}
+		cm.SchemaVersion = c.SchemaVersion
+		cm.IndexedFields = c.Indexing.Fields
+		cm.References = nil
+		for _, ref := range c.references {
+			cm.References = append(cm.References, referenceManifest{Field: ref.field, Target: ref.target.Name, OnDelete: ref.onDelete})
+		}
+		return nil
+	})
+}
+
+// Collections returns the names of the collections of the store in lexical order.
+//
+// Collections lists the collections recorded in the store manifest as well as
+// collection directories created before the store had a manifest.
+func (s *SDStore) Collections() ([]string, error) {
+	m, err := s.loadManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := s.collectionNames()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	for _, name := range names {
+		seen[name] = true
+	}
+	for name := range m.Collections {
+		if !seen[name] && s.collectionExists(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// collectionExists returns true if the directory of collection name exists.
+func (s *SDStore) collectionExists(name string) bool {
+	info, err := s.Backend.Stat(filepath.Join(s.Path, s.Name, name))
+	return err == nil && info.IsDir()
+}
+
+// validCollectionName returns an error if name can't be used as a collection name.
+func validCollectionName(name string) error {
+	if name == "" || name == LostAndFound || strings.HasPrefix(name, ".") ||
+		strings.ContainsAny(name, `/\`) || filepath.Base(name) != name {
+		return fmt.Errorf("invalid collection name %q", name)
+	}
+	return nil
+}
+
+// Describe returns information about the collection with the provided name.
+func (s *SDStore) Describe(name string) (*CollectionInfo, error) {
+	if err := validCollectionName(name); err != nil {
+		return nil, err
+	}
+	if !s.collectionExists(name) {
+		return nil, ErrNotFound
+	}
+
+	m, err := s.loadManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	info := CollectionInfo{Name: name, Encoding: s.encoding}
+	if cm, ok := m.Collections[name]; ok {
+		info.Created = cm.Created
+		info.Encoding = cm.Encoding
+		info.RecordType = cm.RecordType
+		info.SchemaVersion = cm.SchemaVersion
+		info.IndexedFields = cm.IndexedFields
+	}
+
+	c, err := s.genericCollection(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.loadIndexes(); err == nil {
+		info.IndexedFields = c.Indexing.Fields
+	}
+
+	if err := walkDir(s.Backend, c.fullpath(), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !isStoreFile(path) {
+			return nil
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		info.Size += fi.Size()
+		if strings.HasSuffix(path, ".sds") {
+			info.Records++
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("describing %q: %w", name, err)
+	}
+
+	return &info, nil
+}
+
+// DropCollection removes the collection with the provided name and all of its
+// records. Writes to the store are blocked while the collection is removed.
+//
+// Collections that are open must not be used after they have been dropped.
+func (s *SDStore) DropCollection(name string) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	if err := validCollectionName(name); err != nil {
+		return err
+	}
+
+	s.gate.Lock()
+	defer s.gate.Unlock()
+
+	if !s.collectionExists(name) {
+		return ErrNotFound
+	}
+
+	if err := s.Backend.RemoveAll(filepath.Join(s.Path, s.Name, name)); err != nil {
+		return fmt.Errorf("dropping %q: %w", name, err)
+	}
+	if err := s.Backend.Remove(collectionLock(filepath.Join(s.Path, s.Name), name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("dropping %q: %w", name, err)
+	}
+	s.forget(name)
+
+	return s.updateManifest(func(m *storeManifest) error {
+		delete(m.Collections, name)
+		return nil
+	})
+}
+
+// RenameCollection renames the collection oldName to newName. Writes to the
+// store are blocked while the collection is renamed.
+//
+// Collections that are open must be opened again with the new name.
+func (s *SDStore) RenameCollection(oldName string, newName string) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	if err := validCollectionName(oldName); err != nil {
+		return err
+	}
+	if err := validCollectionName(newName); err != nil {
+		return err
+	}
+
+	s.gate.Lock()
+	defer s.gate.Unlock()
+
+	if !s.collectionExists(oldName) {
+		return ErrNotFound
+	}
+	if _, err := s.Backend.Stat(filepath.Join(s.Path, s.Name, newName)); err == nil {
+		return ErrCollectionExists
+	}
+
+	oldPath, newPath := filepath.Join(s.Path, s.Name, oldName), filepath.Join(s.Path, s.Name, newName)
+	if err := s.Backend.Rename(oldPath, newPath); err != nil {
+		return fmt.Errorf("renaming %q: %w", oldName, err)
+	}
+	s.forget(oldName)
+	if err := s.Backend.Remove(collectionLock(filepath.Join(s.Path, s.Name), oldName)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("renaming %q: %w", oldName, err)
+	}
+
+	// The index is named after the collection.
+	err := s.Backend.Rename(filepath.Join(newPath, oldName+".sdx"), filepath.Join(newPath, newName+".sdx"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("renaming index of %q: %w", oldName, err)
+	}
+
+	return s.updateManifest(func(m *storeManifest) error {
+		if cm, ok := m.Collections[oldName]; ok {
+			m.Collections[newName] = cm
+			delete(m.Collections, oldName)
+		}
+		for _, cm := range m.Collections {
+			for i := range cm.References {
+				if cm.References[i].Target == oldName {
+					cm.References[i].Target = newName
+				}
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,225 @@
+package sdstore_test
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/toqns/sdstore"
+)
+
+func TestCatalog(t *testing.T) {
+	path := t.TempDir()
+	store, err := sdstore.New("defaults", path, sdstore.WithJSONEncoding())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+	}
+
+	users, err := store.Collection("users", Record{}, sdstore.WithIndexedFields("Email"))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+	}
+	for _, rec := range []Record{
+		{ID: "1", Name: "One", Email: "one@example.com"},
+		{ID: "2", Name: "Two", Email: "two@example.com"},
+	} {
+		if err := users.Create(rec.ID, rec); err != nil {
+			t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+		}
+	}
+	if _, err := store.Collection("groups", Record{}); err != nil {
+		t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+	}
+
+	names, err := store.Collections()
+	if err != nil {
+		t.Fatalf("%s\tShould be able to list the collections: %v.", failed, err)
+	}
+	if diff := cmp.Diff(names, []string{"groups", "users"}); diff != "" {
+		t.Fatalf("%s\tShould list all collections:\n%s", failed, diff)
+	}
+	t.Logf("%s\tShould be able to list the collections.", success)
+
+	info, err := store.Describe("users")
+	if err != nil {
+		t.Fatalf("%s\tShould be able to describe a collection: %v.", failed, err)
+	}
+	if info.Records != 2 || info.Size == 0 || info.Encoding != "json" || info.Created.IsZero() {
+		t.Fatalf("%s\tShould describe the collection: got %+v.", failed, info)
+	}
+	if diff := cmp.Diff(info.IndexedFields, []string{"Email"}); diff != "" {
+		t.Fatalf("%s\tShould describe the indexed fields:\n%s", failed, diff)
+	}
+	t.Logf("%s\tShould be able to describe a collection.", success)
+
+	if err := store.RenameCollection("users", "groups"); !errors.Is(err, sdstore.ErrCollectionExists) {
+		t.Fatalf("%s\tShould not be able to rename to an existing collection: %v.", failed, err)
+	}
+	if err := store.RenameCollection("users", "people"); err != nil {
+		t.Fatalf("%s\tShould be able to rename a collection: %v.", failed, err)
+	}
+
+	people, err := store.Collection("people", Record{}, sdstore.WithIndexedFields("Email"))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to open a renamed collection: %v.", failed, err)
+	}
+	var got Record
+	if err := people.GetIndexed("Email", "two@example.com", &got); err != nil || got.ID != "2" {
+		t.Fatalf("%s\tShould keep the index of a renamed collection: %v.", failed, err)
+	}
+	t.Logf("%s\tShould be able to rename a collection.", success)
+
+	if err := store.DropCollection("groups"); err != nil {
+		t.Fatalf("%s\tShould be able to drop a collection: %v.", failed, err)
+	}
+	if err := store.DropCollection("groups"); !errors.Is(err, sdstore.ErrNotFound) {
+		t.Fatalf("%s\tShould not be able to drop a missing collection: %v.", failed, err)
+	}
+	if _, err := store.Describe("groups"); !errors.Is(err, sdstore.ErrNotFound) {
+		t.Fatalf("%s\tShould not be able to describe a dropped collection: %v.", failed, err)
+	}
+	t.Logf("%s\tShould be able to drop a collection.", success)
+
+	// Reopen the store to verify that the manifest is persisted.
+	store, err = sdstore.New("defaults", path, sdstore.WithJSONEncoding())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to reopen the store: %v.", failed, err)
+	}
+	names, err = store.Collections()
+	if err != nil {
+		t.Fatalf("%s\tShould be able to list the collections: %v.", failed, err)
+	}
+	if diff := cmp.Diff(names, []string{"people"}); diff != "" {
+		t.Fatalf("%s\tShould list the collections of a reopened store:\n%s", failed, diff)
+	}
+	t.Logf("%s\tShould list the collections of a reopened store.", success)
+
+	if err := store.RenameCollection("people", "../escape"); err == nil {
+		t.Fatalf("%s\tShould not be able to rename to an invalid name.", failed)
+	}
+	t.Logf("%s\tShould not be able to rename to an invalid name.", success)
+}
+
+func TestEncodingMismatch(t *testing.T) {
+	path := t.TempDir()
+	store, err := sdstore.New("defaults", path, sdstore.WithJSONEncoding())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+	}
+
+	c, err := store.Collection("users", Record{}, sdstore.WithIndexedFields("Email"))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+	}
+	if err := c.Create("1", Record{ID: "1", Email: "one@example.com"}); err != nil {
+		t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+	}
+
+	// Reopen with the default CBOR encoding.
+	store, err = sdstore.New("defaults", path)
+	if err != nil {
+		t.Fatalf("%s\tShould be able to reopen the store: %v.", failed, err)
+	}
+	if _, err := store.Collection("users", Record{}, sdstore.WithIndexedFields("Email")); !errors.Is(err, sdstore.ErrEncodingMismatch) {
+		t.Fatalf("%s\tShould not be able to open a collection with another encoding: %v.", failed, err)
+	}
+	t.Logf("%s\tShould not be able to open a collection with another encoding.", success)
+
+	store, err = sdstore.New("defaults", path, sdstore.WithRecordedEncoding())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to reopen the store: %v.", failed, err)
+	}
+	c, err = store.Collection("users", Record{}, sdstore.WithIndexedFields("Email"))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to open a collection with the recorded encoding: %v.", failed, err)
+	}
+	var got Record
+	if err := c.GetIndexed("Email", "one@example.com", &got); err != nil || got.ID != "1" {
+		t.Fatalf("%s\tShould be able to read records with the recorded encoding: %v.", failed, err)
+	}
+	t.Logf("%s\tShould be able to open a collection with the recorded encoding.", success)
+
+	info, err := store.Describe("users")
+	if err != nil {
+		t.Fatalf("%s\tShould be able to describe a collection: %v.", failed, err)
+	}
+	if info.Encoding != "json" || info.RecordType != "sdstore_test.Record" {
+		t.Fatalf("%s\tShould record the encoding and record type: got %q, %q.", failed, info.Encoding, info.RecordType)
+	}
+	t.Logf("%s\tShould record the encoding and record type.", success)
+}
+
+func TestUnrecordedEncoding(t *testing.T) {
+	path := t.TempDir()
+	store, err := sdstore.New("defaults", path, sdstore.WithJSONEncoding())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+	}
+	c, err := store.Collection("users", Record{}, sdstore.WithIndexedFields("Email"))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+	}
+	if err := c.Create("1", Record{ID: "1", Email: "one@example.com"}); err != nil {
+		t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+	}
+
+	// Remove the manifest, like in stores created before it was added.
+	manifest := filepath.Join(path, "defaults", "manifest.json")
+	if err := os.Remove(manifest); err != nil {
+		t.Fatalf("%s\tShould be able to remove the manifest: %v.", failed, err)
+	}
+
+	store, err = sdstore.New("defaults", path, sdstore.WithJSONEncoding(), sdstore.WithRecordedEncoding())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to reopen the store: %v.", failed, err)
+	}
+	c, err = store.Collection("users", Record{}, sdstore.WithIndexedFields("Email"))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to open a collection with the configured encoding: %v.", failed, err)
+	}
+	var got Record
+	if err := c.GetIndexed("Email", "one@example.com", &got); err != nil || got.ID != "1" {
+		t.Fatalf("%s\tShould be able to read records with the configured encoding: %v.", failed, err)
+	}
+	t.Logf("%s\tShould use the configured encoding without a recorded encoding.", success)
+
+	b, err := os.ReadFile(manifest)
+	if err != nil {
+		t.Fatalf("%s\tShould be able to read the manifest: %v.", failed, err)
+	}
+	var m struct{ Encoding string }
+	if err := json.Unmarshal(b, &m); err != nil || m.Encoding != "" {
+		t.Fatalf("%s\tShould not record a guessed store encoding: got %q, %v.", failed, m.Encoding, err)
+	}
+	t.Logf("%s\tShould not record a guessed store encoding.", success)
+}
+
+func TestRecordTypeMismatch(t *testing.T) {
+	store, err := sdstore.New("defaults", t.TempDir(), sdstore.WithJSONEncoding())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+	}
+	if _, err := store.Collection("users", Record{}); err != nil {
+		t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+	}
+
+	if _, err := store.Collection("users", Member{}); !errors.Is(err, sdstore.ErrRecordTypeMismatch) {
+		t.Fatalf("%s\tShould not be able to open a collection with another record type: %v.", failed, err)
+	}
+	t.Logf("%s\tShould not be able to open a collection with another record type.", success)
+
+	if _, err := store.Collection("users", Member{}, sdstore.WithRecordTypeChange()); err != nil {
+		t.Fatalf("%s\tShould be able to change the record type of a collection: %v.", failed, err)
+	}
+	info, err := store.Describe("users")
+	if err != nil {
+		t.Fatalf("%s\tShould be able to describe a collection: %v.", failed, err)
+	}
+	if info.RecordType != "sdstore_test.Member" {
+		t.Fatalf("%s\tShould record the new record type: got %q.", failed, info.RecordType)
+	}
+	t.Logf("%s\tShould be able to change the record type of a collection.", success)
+}
@@ -0,0 +1,147 @@
+// Command sdstore provides maintenance tooling for SDStore stores.
+//
+// Usage:
+//
+//	sdstore verify -path /var/lib/app -name mystore [-encoding cbor]
+//	sdstore repair -path /var/lib/app -name mystore [-encoding cbor] [-dry-run] [-keep-orphans]
+//
+// Without -encoding, the encoding recorded in the store manifest is used, or
+// JSON for stores and collections without a recorded encoding.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+
+	"github.com/toqns/sdstore"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "sdstore:", err)
+		os.Exit(1)
+	}
+}
+
+// errProblems is returned when a verification found problems.
+var errProblems = errors.New("problems found")
+
+func run(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: sdstore <verify|repair> [flags]")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	cmd, args := args[0], args[1:]
+	fs := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	path := fs.String("path", ".", "directory containing the store")
+	name := fs.String("name", "", "name of the store")
+	encoding := fs.String("encoding", "", "encoding of the store (json, cbor, msgpack, binc, gob), defaults to the recorded encoding")
+
+	switch cmd {
+	case "verify":
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+
+		store, err := openStore(*path, *name, *encoding)
+		if err != nil {
+			return err
+		}
+
+		report, err := store.Verify(ctx)
+		if err != nil {
+			return err
+		}
+
+		printReport(out, report)
+		if !report.OK() {
+			return errProblems
+		}
+		return nil
+
+	case "repair":
+		var opts sdstore.RepairOptions
+		fs.BoolVar(&opts.DryRun, "dry-run", false, "only report what would be repaired")
+		fs.BoolVar(&opts.KeepOrphans, "keep-orphans", false, "don't remove orphan temporary files")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+
+		store, err := openStore(*path, *name, *encoding)
+		if err != nil {
+			return err
+		}
+
+		report, err := store.Repair(ctx, opts)
+		if err != nil {
+			return err
+		}
+
+		printReport(out, report)
+		return nil
+	}
+
+	return fmt.Errorf("unknown command %q", cmd)
+}
+
+// openStore opens an existing store.
+func openStore(path string, name string, encoding string) (*sdstore.SDStore, error) {
+	if name == "" {
+		return nil, fmt.Errorf("-name is required")
+	}
+
+	if _, err := os.Stat(filepath.Join(path, name)); err != nil {
+		return nil, fmt.Errorf("opening store: %w", err)
+	}
+
+	// The encoding isn't recorded for a store without a manifest, which is
+	// read as JSON rather than guessed.
+	opts := []sdstore.StoreOption{sdstore.WithJSONEncoding(), sdstore.WithRecordedEncoding()}
+	if encoding != "" {
+		opts = []sdstore.StoreOption{sdstore.WithCodec(encoding)}
+	}
+
+	return sdstore.New(name, path, opts...)
+}
+
+// printReport writes a human readable report to w.
+func printReport(w io.Writer, report *sdstore.Report) {
+	for _, c := range report.Collections {
+		status := "ok"
+		if !c.OK() {
+			status = "PROBLEMS"
+		}
+		fmt.Fprintf(w, "%s: %d records, %s\n", c.Name, c.Records, status)
+
+		if c.CorruptIndex != nil {
+			fmt.Fprintf(w, "  corrupt index: %v\n", c.CorruptIndex)
+		}
+		for _, err := range c.Corrupt {
+			fmt.Fprintf(w, "  corrupt record: %v\n", err)
+		}
+		for _, k := range c.Dangling {
+			fmt.Fprintf(w, "  dangling index entry: %s\n", k)
+		}
+		for _, id := range c.Stale {
+			fmt.Fprintf(w, "  stale index entries of record: %s\n", id)
+		}
+		for _, id := range c.Unindexed {
+			fmt.Fprintf(w, "  unindexed record: %s\n", id)
+		}
+		for _, d := range c.Duplicates {
+			fmt.Fprintf(w, "  duplicate value %s=%s: %v\n", d.Field, d.Value, d.IDs)
+		}
+		for _, path := range c.Orphans {
+			fmt.Fprintf(w, "  orphan file: %s\n", path)
+		}
+	}
+}
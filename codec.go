@@ -2,29 +2,267 @@ package sdstore
 
 import (
 	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/ugorji/go/codec"
 )
 
-// CodecEncoder provides functionality for encoding/decoding Gob encoded data.
+// ErrUnknownCodec is an error returned when a store is opened with a codec
+// name for which no codec is registered.
+var ErrUnknownCodec = errors.New("unknown codec")
+
+// registeredCodec is an Encoder and Decoder registered under a name.
+type registeredCodec struct {
+	encoder Encoder
+	decoder Decoder
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]registeredCodec{
+		"json":    {EncodeFunc(json.Marshal), DecodeFunc(json.Unmarshal)},
+		"cbor":    {NewCborEncoder(), NewCborEncoder()},
+		"msgpack": {NewMsgpackEncoder(), NewMsgpackEncoder()},
+		"binc":    {NewBincEncoder(), NewBincEncoder()},
+		"gob":     {GobEncoder{}, GobEncoder{}},
+	}
+)
+
+// RegisterCodec registers the Encoder and Decoder for the provided codec name,
+// replacing any previously registered codec.
+//
+// The name of the codec of a store is recorded in the store manifest, so codecs
+// have to be registered under the same name whenever the store is opened.
+func RegisterCodec(name string, e Encoder, d Decoder) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+
+	codecs[name] = registeredCodec{encoder: e, decoder: d}
+}
+
+// Codecs returns the names of the registered codecs in lexical order.
+func Codecs() []string {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	names := make([]string, 0, len(codecs))
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookupCodec returns the registered codec for name.
+//
+// Names of CodecEncoders configured with CodecOptions, such as
+// "msgpack+writeext", resolve to the configured built-in codec.
+func lookupCodec(name string) (registeredCodec, bool) {
+	codecsMu.RLock()
+	rc, ok := codecs[name]
+	codecsMu.RUnlock()
+	if ok {
+		return rc, true
+	}
+
+	base, opts, found := strings.Cut(name, "+")
+	newEncoder, ok := codecEncoders[base]
+	if !found || !ok {
+		return registeredCodec{}, false
+	}
+
+	var options []CodecOption
+	for _, opt := range strings.Split(opts, "+") {
+		option, ok := codecOptions[opt]
+		if !ok {
+			return registeredCodec{}, false
+		}
+		options = append(options, option)
+	}
+
+	// Reject options that don't apply or aren't in the order of Name.
+	e := newEncoder(options...)
+	if e.Name() != name {
+		return registeredCodec{}, false
+	}
+	return registeredCodec{encoder: e, decoder: e}, true
+}
+
+// codecEncoders holds the constructors of the built-in CodecEncoders by codec name.
+var codecEncoders = map[string]func(...CodecOption) CodecEncoder{
+	"cbor":    NewCborEncoder,
+	"msgpack": NewMsgpackEncoder,
+	"binc":    NewBincEncoder,
+}
+
+// codecOptions holds the CodecOptions by the name they have in codec names.
+var codecOptions = map[string]CodecOption{
+	"canonical": WithCanonical(),
+	"writeext":  WithWriteExt(),
+}
+
+// WithCodec is an option to set the store's encoding to the codec registered
+// under name. New returns ErrUnknownCodec if no codec is registered for name.
+func WithCodec(name string) StoreOption {
+	return func(s *SDStore) {
+		rc, _ := lookupCodec(name)
+		withNamedEncoding(name, rc.encoder, rc.decoder)(s)
+	}
+}
+
+// NamedCodec is implemented by encoders and decoders that know the name of
+// their codec, such as CodecEncoder and GobEncoder. The name of an encoding
+// set with WithCollectionEncoding or ConvertEncoding is recorded in the store
+// manifest if its encoder and decoder have the name of a registered codec.
+type NamedCodec interface {
+	Name() string
+}
+
+// codecName returns the name of the codec of e and d, or an empty string if
+// they don't have the same name or no codec is registered under it.
+func codecName(e Encoder, d Decoder) string {
+	en, ok := e.(NamedCodec)
+	if !ok {
+		return ""
+	}
+	dn, ok := d.(NamedCodec)
+	if !ok || dn.Name() != en.Name() {
+		return ""
+	}
+
+	if _, ok := lookupCodec(en.Name()); !ok {
+		return ""
+	}
+	return en.Name()
+}
+
+// WithCollectionEncoding is an option to set the encoder and decoder of a
+// collection, overriding the encoding of the store.
+//
+// If e and d implement NamedCodec, such as configured CodecEncoders, their codec
+// name is recorded in the store manifest and the registered codec is used when
+// the collection is opened again without the option. The name of a CodecEncoder
+// includes its CodecOptions, so it is opened with the same options, and opening
+// it with other options fails with ErrEncodingMismatch. Other encodings have to
+// be provided whenever the collection is opened.
+func WithCollectionEncoding(e Encoder, d Decoder) CollectionOption {
+	return func(c *Collection) {
+		withEncoding(e, d)(c)
+		withEncodingName(codecName(e, d))(c)
+		c.encodingSet = true
+	}
+}
+
+// WithCollectionCodec is an option to set the encoding of a collection to the
+// codec registered under name, overriding the encoding of the store. The codec
+// name is recorded in the store manifest and used when the collection is opened again.
+func WithCollectionCodec(name string) CollectionOption {
+	return func(c *Collection) {
+		rc, _ := lookupCodec(name)
+		withEncoding(rc.encoder, rc.decoder)(c)
+		withEncodingName(name)(c)
+		c.encodingSet = true
+	}
+}
+
+// CodecOption is an option to configure the handle of a CodecEncoder.
+type CodecOption func(codec.Handle)
+
+// WithCanonical is an option to encode maps with sorted keys, so equal values
+// always produce the same bytes.
+func WithCanonical() CodecOption {
+	return func(h codec.Handle) {
+		switch h := h.(type) {
+		case *codec.CborHandle:
+			h.Canonical = true
+		case *codec.MsgpackHandle:
+			h.Canonical = true
+		case *codec.BincHandle:
+			h.Canonical = true
+		}
+	}
+}
+
+// WithWriteExt is an option to write Msgpack data using the extensions of the
+// newer spec (str8, bin and ext types). It has no effect on other encodings.
+func WithWriteExt() CodecOption {
+	return func(h codec.Handle) {
+		if h, ok := h.(*codec.MsgpackHandle); ok {
+			h.WriteExt = true
+		}
+	}
+}
+
+// CodecEncoder provides functionality for encoding/decoding data with the
+// codecs of github.com/ugorji/go/codec.
 type CodecEncoder struct {
 	handle codec.Handle
 }
 
+// newCodecEncoder returns a CodecEncoder for h configured with opts.
+func newCodecEncoder(h codec.Handle, opts []CodecOption) CodecEncoder {
+	for _, opt := range opts {
+		opt(h)
+	}
+	return CodecEncoder{handle: h}
+}
+
 // NewCborEncoder returns a CodecEncoder using CBOR encoding.
-func NewCborEncoder() CodecEncoder {
-	return CodecEncoder{handle: &codec.CborHandle{}}
+func NewCborEncoder(opts ...CodecOption) CodecEncoder {
+	return newCodecEncoder(&codec.CborHandle{}, opts)
 }
 
 // NewMsgpackEncoder returns a CodecEncoder using Msgpack encoding.
-func NewMsgpackEncoder() CodecEncoder {
-	return CodecEncoder{handle: &codec.MsgpackHandle{}}
+func NewMsgpackEncoder(opts ...CodecOption) CodecEncoder {
+	return newCodecEncoder(&codec.MsgpackHandle{}, opts)
 }
 
 // NewBincEncoder returns a CodecEncoder using Binc encoding.
-func NewBincEncoder() CodecEncoder {
-	return CodecEncoder{handle: &codec.BincHandle{}}
+func NewBincEncoder(opts ...CodecOption) CodecEncoder {
+	return newCodecEncoder(&codec.BincHandle{}, opts)
+}
+
+// Name implements the NamedCodec interface for CodecEncoder, returning the name
+// of its codec followed by the CodecOptions it's configured with, such as "cbor"
+// or "msgpack+canonical+writeext". Options set through Handle aren't part of
+// the name.
+func (c CodecEncoder) Name() string {
+	if c.handle == nil {
+		return ""
+	}
+
+	var canonical, writeExt bool
+	switch h := c.handle.(type) {
+	case *codec.CborHandle:
+		canonical = h.Canonical
+	case *codec.MsgpackHandle:
+		canonical, writeExt = h.Canonical, h.WriteExt
+	case *codec.BincHandle:
+		canonical = h.Canonical
+	}
+
+	name := c.handle.Name()
+	if canonical {
+		name += "+canonical"
+	}
+	if writeExt {
+		name += "+writeext"
+	}
+	return name
+}
+
+// Handle returns the codec handle of the CodecEncoder, which can be used to
+// configure options that aren't provided as a CodecOption. The handle must
+// not be modified once the encoder is in use.
+func (c CodecEncoder) Handle() codec.Handle {
+	return c.handle
 }
 
 // Encode implements the Encoder interface for CodecEncoder.
@@ -50,3 +288,35 @@ func (c CodecEncoder) Decode(b []byte, dest any) error {
 	r := bytes.NewBuffer(b)
 	return codec.NewDecoder(r, c.handle).Decode(dest)
 }
+
+// GobEncoder provides functionality for encoding/decoding Gob encoded data.
+//
+// Gob can't decode records without knowledge of their type, so collections
+// using Gob can't be verified with Verify.
+type GobEncoder struct{}
+
+// Name implements the NamedCodec interface for GobEncoder.
+func (GobEncoder) Name() string {
+	return "gob"
+}
+
+// Encode implements the Encoder interface for GobEncoder.
+func (GobEncoder) Encode(data any) ([]byte, error) {
+	buf := bytes.Buffer{}
+	if err := gob.NewEncoder(&buf).Encode(data); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Decode implements the Decoder interface for GobEncoder.
+func (GobEncoder) Decode(b []byte, dest any) error {
+	// Gob only decodes into interfaces holding registered types,
+	// decode into the value the interface points to instead.
+	if p, ok := dest.(*any); ok && *p != nil && reflect.ValueOf(*p).Kind() == reflect.Pointer {
+		dest = *p
+	}
+
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(dest)
+}
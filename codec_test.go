@@ -0,0 +1,199 @@
+package sdstore_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/toqns/sdstore"
+)
+
+func TestCodecRegistry(t *testing.T) {
+	path := t.TempDir()
+
+	// A custom codec writing indented JSON.
+	indent := sdstore.EncodeFunc(func(v any) ([]byte, error) { return json.MarshalIndent(v, "", "  ") })
+	sdstore.RegisterCodec("json-indent", indent, sdstore.DecodeFunc(json.Unmarshal))
+
+	store, err := sdstore.New("defaults", path, sdstore.WithCodec("json-indent"))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a store with a registered codec: %v.", failed, err)
+	}
+	c, err := store.Collection("test", Record{}, sdstore.WithIndexedFields("Email"))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+	}
+	if err := c.Create("1", Record{ID: "1", Email: "one@example.com"}); err != nil {
+		t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+	}
+	t.Logf("%s\tShould be able to create a store with a registered codec.", success)
+
+	info, err := store.Describe("test")
+	if err != nil || info.Encoding != "json-indent" {
+		t.Fatalf("%s\tShould record the codec name: %+v, %v.", failed, info, err)
+	}
+	t.Logf("%s\tShould record the codec name.", success)
+
+	store, err = sdstore.New("defaults", path, sdstore.WithRecordedEncoding())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to reopen the store: %v.", failed, err)
+	}
+	c, err = store.Collection("test", Record{}, sdstore.WithIndexedFields("Email"))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to open a collection with the recorded codec: %v.", failed, err)
+	}
+	var got Record
+	if err := c.GetIndexed("Email", "one@example.com", &got); err != nil || got.ID != "1" {
+		t.Fatalf("%s\tShould be able to read records with the recorded codec: %v.", failed, err)
+	}
+	t.Logf("%s\tShould be able to open a store with the recorded codec.", success)
+
+	if _, err := sdstore.New("unknown", t.TempDir(), sdstore.WithCodec("unknown")); !errors.Is(err, sdstore.ErrUnknownCodec) {
+		t.Fatalf("%s\tShould not be able to create a store with an unknown codec: %v.", failed, err)
+	}
+	t.Logf("%s\tShould not be able to create a store with an unknown codec.", success)
+}
+
+func TestCodecOptions(t *testing.T) {
+	m := map[string]int{"a": 1, "b": 2, "c": 3, "d": 4, "e": 5, "f": 6, "g": 7, "h": 8}
+
+	for name, e := range map[string]sdstore.CodecEncoder{
+		"cbor":    sdstore.NewCborEncoder(sdstore.WithCanonical()),
+		"msgpack": sdstore.NewMsgpackEncoder(sdstore.WithCanonical(), sdstore.WithWriteExt()),
+		"binc":    sdstore.NewBincEncoder(sdstore.WithCanonical()),
+	} {
+		first, err := e.Encode(m)
+		if err != nil {
+			t.Fatalf("%s\tShould be able to encode with %s: %v.", failed, name, err)
+		}
+		for i := 0; i < 10; i++ {
+			b, err := e.Encode(m)
+			if err != nil {
+				t.Fatalf("%s\tShould be able to encode with %s: %v.", failed, name, err)
+			}
+			if !bytes.Equal(b, first) {
+				t.Fatalf("%s\tShould encode canonically with %s.", failed, name)
+			}
+		}
+
+		var got map[string]int
+		if err := e.Decode(first, &got); err != nil || len(got) != len(m) {
+			t.Fatalf("%s\tShould be able to decode with %s: %v.", failed, name, err)
+		}
+	}
+	t.Logf("%s\tShould encode canonically.", success)
+}
+
+func TestCollectionEncoding(t *testing.T) {
+	path := t.TempDir()
+	store, err := sdstore.New("defaults", path, sdstore.WithJSONEncoding())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+	}
+
+	blobs, err := store.Collection("blobs", Record{}, sdstore.WithCollectionCodec("msgpack"))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a collection with its own encoding: %v.", failed, err)
+	}
+	config, err := store.Collection("config", Record{})
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+	}
+	rec := Record{ID: "1", Name: "One"}
+	if err := blobs.Create(rec.ID, rec); err != nil {
+		t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+	}
+	if err := config.Create(rec.ID, rec); err != nil {
+		t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+	}
+
+	b, err := os.ReadFile(filepath.Join(path, "defaults", "config", "1.sds"))
+	if err != nil || !json.Valid(b) {
+		t.Fatalf("%s\tShould store records with the store encoding: %v.", failed, err)
+	}
+	b, err = os.ReadFile(filepath.Join(path, "defaults", "blobs", "1.sds"))
+	if err != nil || json.Valid(b) {
+		t.Fatalf("%s\tShould store records with the collection encoding: %v.", failed, err)
+	}
+	t.Logf("%s\tShould store records with the collection encoding.", success)
+
+	// Reopen without the option to verify that the encoding is recorded.
+	store, err = sdstore.New("defaults", path, sdstore.WithJSONEncoding())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to reopen the store: %v.", failed, err)
+	}
+	blobs, err = store.Collection("blobs", Record{})
+	if err != nil {
+		t.Fatalf("%s\tShould be able to reopen the collection: %v.", failed, err)
+	}
+	var got Record
+	if err := blobs.Get("1", &got); err != nil || got != rec {
+		t.Fatalf("%s\tShould use the recorded collection encoding: %v.", failed, err)
+	}
+	t.Logf("%s\tShould use the recorded collection encoding.", success)
+
+	if _, err := store.Collection("blobs", Record{}, sdstore.WithCollectionCodec("cbor")); !errors.Is(err, sdstore.ErrEncodingMismatch) {
+		t.Fatalf("%s\tShould not be able to open a collection with another codec: %v.", failed, err)
+	}
+	t.Logf("%s\tShould not be able to open a collection with another codec.", success)
+
+	report, err := store.Verify(context.Background())
+	if err != nil || !report.OK() {
+		t.Fatalf("%s\tShould verify collections with their own encoding: %+v, %v.", failed, report, err)
+	}
+	t.Logf("%s\tShould verify collections with their own encoding.", success)
+}
+
+func TestConfiguredCollectionEncoding(t *testing.T) {
+	path := t.TempDir()
+	store, err := sdstore.New("defaults", path, sdstore.WithJSONEncoding())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+	}
+
+	enc := sdstore.NewMsgpackEncoder(sdstore.WithCanonical())
+	c, err := store.Collection("blobs", Record{}, sdstore.WithCollectionEncoding(enc, enc))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a collection with a configured encoding: %v.", failed, err)
+	}
+	rec := Record{ID: "1", Name: "One"}
+	if err := c.Create(rec.ID, rec); err != nil {
+		t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+	}
+
+	info, err := store.Describe("blobs")
+	if err != nil || info.Encoding != "msgpack+canonical" {
+		t.Fatalf("%s\tShould record the codec name of a configured encoding: got %q, %v.", failed, info.Encoding, err)
+	}
+	t.Logf("%s\tShould record the codec name of a configured encoding.", success)
+
+	// Reopen without the option to verify that the recorded codec is used.
+	store, err = sdstore.New("defaults", path, sdstore.WithJSONEncoding())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to reopen the store: %v.", failed, err)
+	}
+	c, err = store.Collection("blobs", Record{})
+	if err != nil {
+		t.Fatalf("%s\tShould be able to reopen the collection: %v.", failed, err)
+	}
+	var got Record
+	if err := c.Get("1", &got); err != nil || got != rec {
+		t.Fatalf("%s\tShould use the recorded codec: %v.", failed, err)
+	}
+	t.Logf("%s\tShould use the recorded codec.", success)
+
+	plain := sdstore.NewMsgpackEncoder()
+	if _, err := store.Collection("blobs", Record{}, sdstore.WithCollectionEncoding(plain, plain)); !errors.Is(err, sdstore.ErrEncodingMismatch) {
+		t.Fatalf("%s\tShould not be able to reopen the collection with other codec options: %v.", failed, err)
+	}
+	t.Logf("%s\tShould not be able to reopen the collection with other codec options.", success)
+
+	if _, err := store.Collection("invalid", Record{}, sdstore.WithCollectionCodec("cbor+writeext")); !errors.Is(err, sdstore.ErrUnknownCodec) {
+		t.Fatalf("%s\tShould not be able to use options that don't apply to a codec: %v.", failed, err)
+	}
+	t.Logf("%s\tShould not be able to use options that don't apply to a codec.", success)
+}
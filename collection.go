@@ -1,6 +1,7 @@
 package sdstore
 
 import (
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -52,24 +53,64 @@ const (
 // which are stored as plain files.
 type Collection struct {
 	mu          sync.RWMutex
+	gate        *sync.RWMutex
 	initialized bool
+	readOnly    bool
+	legacyNames bool
+	shardingSet bool
+	encoding    string
+	encodingSet bool
+
+	// unsealedFiles accepts files without a checksum header when checksums
+	// are enabled.
+	unsealedFiles bool
+
+	recordTypeChange bool
+
+	// indexSum is the checksum of the index file the Collection loaded or
+	// saved last.
+	indexSum    [sha256.Size]byte
+	store       *SDStore
 	record      reflect.Type
 	Path        string
 	Name        string
 	Encoder     Encoder
 	Decoder     Decoder
-	Indexing    struct {
-		Fields  []string
-		Indexes map[string]string
+	Indexing    indexing
+	Compression struct {
+		Algorithm Compression
+		MinSize   int
 	}
-	FilePerm fs.FileMode
-	DirPerm  fs.FileMode
+	Checksums bool
+	Metadata  bool
+	Sharding  int
+	Backend   Backend
+
+	IDGenerator IDGenerator
+	hooks       hooks
+	references  []reference
+	normalizers map[string][]string
+	normalize   map[string][]func(string) string
+	multiKey    []string
+	sparse      []string
+	partial     map[string]func(any) bool
+	fullText    struct {
+		fields  []string
+		stemmer func(string) string
+	}
+
+	SchemaVersion int
+	migrations    map[int]func(map[string]any) (map[string]any, error)
+	FilePerm      fs.FileMode
+	DirPerm       fs.FileMode
 }
 
 // CollectionOption is an option for the setup of a Collection.
 type CollectionOption func(*Collection)
 
 // WithIndexedFields is an option to set which struct fields are to be indexed.
+// Fields of nested structs are indexed by their dotted path, such as
+// "Address.PostalCode".
 func WithIndexedFields(fields ...string) CollectionOption {
 	return func(c *Collection) {
 		c.Indexing.Fields = fields
@@ -84,6 +125,30 @@ func withEncoding(e Encoder, d Decoder) CollectionOption {
 	}
 }
 
+// withEncodingName is an option to set the name of the encoding of a Collection,
+// which is recorded in the store manifest.
+func withEncodingName(name string) CollectionOption {
+	return func(c *Collection) {
+		c.encoding = name
+	}
+}
+
+// withStore is an option to set the store a Collection belongs to.
+func withStore(s *SDStore) CollectionOption {
+	return func(c *Collection) {
+		c.store = s
+	}
+}
+
+// withGate is an option to share a write gate between the collections of a store.
+// Writes hold the gate for reading, allowing a backup to block writes while it
+// takes a snapshot.
+func withGate(gate *sync.RWMutex) CollectionOption {
+	return func(c *Collection) {
+		c.gate = gate
+	}
+}
+
 func withDirPerms(perms fs.FileMode) CollectionOption {
 	return func(c *Collection) {
 		c.DirPerm = perms
@@ -106,15 +171,14 @@ func newCollection(name string, path string, record any, opts ...CollectionOptio
 	c := Collection{
 		Path: path,
 		Name: name,
-		Indexing: struct {
-			Fields  []string
-			Indexes map[string]string
-		}{
+		Indexing: indexing{
 			Indexes: make(map[string]string),
 		},
 		FilePerm: defaultFilePerm,
 		DirPerm:  defaultDirPerm,
 		record:   reflect.TypeOf(record),
+		gate:     &sync.RWMutex{},
+		Backend:  DirBackend{},
 	}
 
 	withEncoding(EncodeFunc(json.Marshal), DecodeFunc(json.Unmarshal))(&c)
@@ -146,46 +210,307 @@ func (c *Collection) fullpath() string {
 }
 
 // filepath returns a composed full file path for a record or index
-// if index is set true.
+// if index is set true. Record ids are encoded to safe file names and
+// records of sharded collections are placed in their shard directory.
+//
+// Read-only collections with legacy names fall back to the id as file name
+// for records that don't exist under their encoded name.
 func (c *Collection) filepath(name string, index bool) string {
-	ext := ".sds"
 	if index {
-		ext = ".sdx"
+		return filepath.Join(c.Path, c.Name, name+".sdx")
 	}
-	return filepath.Join(c.Path, c.Name, name+ext)
+
+	dir := filepath.Join(c.Path, c.Name, shardPath(name, c.Sharding))
+	path := filepath.Join(dir, encodeID(name)+".sds")
+	if c.legacyNames {
+		if _, err := c.Backend.Stat(path); errors.Is(err, fs.ErrNotExist) && filepath.Base(name) == name {
+			if _, err := c.Backend.Stat(filepath.Join(dir, name+".sds")); err == nil {
+				return filepath.Join(dir, name+".sds")
+			}
+		}
+	}
+	return path
 }
 
-// save stores the provided data to disk under the provided filename.
+// lockpath returns the name of the lock of the collection.
+func (c *Collection) lockpath() string {
+	return collectionLock(c.Path, c.Name)
+}
+
+// collectionLock returns the name of the lock of the collection name in dir.
+// The lock is kept next to the collection's directory, so it isn't moved when
+// the directory is replaced, such as by ConvertEncoding.
+func collectionLock(dir string, name string) string {
+	return filepath.Join(dir, "."+name+".lock")
+}
+
+// lock acquires the backend lock of the collection, which excludes writers
+// in other processes, and returns a function releasing it.
+//
+// The index is reloaded if another writer changed it, so its changes aren't
+// overwritten, along with the encoding if another writer converted the
+// collection. The layout is reloaded as another writer may have resharded
+// the collection. The Collection must be locked for writing.
+func (c *Collection) lock() (func() error, error) {
+	unlock, err := c.Backend.Lock(c.lockpath())
+	if err != nil {
+		return nil, fmt.Errorf("locking collection: %w", err)
+	}
+
+	if c.initialized {
+		if err := c.reloadLayout(); err != nil {
+			unlock()
+			return nil, err
+		}
+		if err := c.reloadIndexes(); err != nil {
+			unlock()
+			return nil, err
+		}
+	}
+
+	return unlock, nil
+}
+
+// sumIndex returns the checksum of the index file, zero if it can't be read.
+//
+// The contents are compared rather than the modification time or the identity
+// of the file, which may both be reused by a file written right after.
+func (c *Collection) sumIndex() [sha256.Size]byte {
+	b, err := c.Backend.ReadFile(c.filepath(c.Name, true))
+	if err != nil {
+		return [sha256.Size]byte{}
+	}
+	return sha256.Sum256(b)
+}
+
+// reloadIndexes loads the index file if it changed since the Collection
+// loaded or saved it.
+func (c *Collection) reloadIndexes() error {
+	if c.sumIndex() == c.indexSum {
+		return nil
+	}
+	if err := c.reloadEncoding(); err != nil {
+		return err
+	}
+
+	prev := c.Indexing
+	c.Indexing = indexing{}
+	if err := c.loadIndexes(); err != nil {
+		c.Indexing = prev
+		return fmt.Errorf("reloading index: %w", err)
+	}
+	if c.Indexing.Indexes == nil {
+		c.Indexing.Indexes = make(map[string]string)
+	}
+
+	// Indexes built with other settings, such as a merged index of an
+	// OverlayBackend, are recreated.
+	if !c.indexed(prev.Fields) {
+		c.Indexing.Fields = prev.Fields
+		if err := c.recreateIndexes(); err != nil {
+			return fmt.Errorf("reindexing: %w", err)
+		}
+		if err := c.saveIndexes(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// save stores the provided data through the backend under the provided filename.
 func (c *Collection) save(filename string, data []byte) error {
 	if !c.initialized {
 		return ErrNotInitialized
 	}
 
-	return os.WriteFile(filename, data, 0600)
-
+	filePerm, dirPerm := c.filePerms()
+	if c.Sharding > 0 {
+		if err := c.Backend.MkdirAll(filepath.Dir(filename), dirPerm); err != nil {
+			return err
+		}
+	}
+	return c.Backend.WriteFile(filename, data, filePerm)
 }
 
 // load returns the content of the provided filename as a slice of bytes.
 func (c *Collection) load(filename string) ([]byte, error) {
-	return os.ReadFile(filename)
+	return c.Backend.ReadFile(filename)
+}
+
+// encode encodes data with the Collection's encoder, compresses the result
+// if compression is enabled and adds a checksum if checksums are enabled.
+func (c *Collection) encode(data any) ([]byte, error) {
+	b, err := c.Encoder.Encode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.pack(b)
+}
+
+// encodeRecord encodes the record data like encode, including the schema
+// version of the Collection and the metadata m, if not nil.
+func (c *Collection) encodeRecord(data any, m *Meta) ([]byte, error) {
+	b, err := c.Encoder.Encode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.pack(addMeta(c.addVersion(b), m))
+}
+
+// pack compresses the encoded data b if compression is enabled and adds
+// a checksum if checksums are enabled.
+func (c *Collection) pack(b []byte) ([]byte, error) {
+	b, err := c.compress(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.seal(b), nil
+}
+
+// unpack verifies the checksum and decompresses b if needed and returns the
+// schema version and encoded data.
+func (c *Collection) unpack(b []byte) (int, []byte, error) {
+	_, v, b, err := c.unpackMeta(b)
+	return v, b, err
+}
+
+// unpackMeta unpacks b like unpack and returns the metadata of the record as
+// well, which is nil if the record has none.
+func (c *Collection) unpackMeta(b []byte) (*Meta, int, []byte, error) {
+	b, err := c.unseal(b)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	b, err = decompress(b)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	m, b, err := parseMeta(b)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	v, b, err := parseVersion(b)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	return m, v, b, nil
+}
+
+// decode verifies the checksum and decompresses b if needed and decodes the
+// result to dest with the Collection's decoder.
+func (c *Collection) decode(b []byte, dest any) error {
+	_, b, err := c.unpack(b)
+	if err != nil {
+		return err
+	}
+
+	return c.Decoder.Decode(b, dest)
+}
+
+// decodeRecord decodes the record b like decode, migrating it first if it
+// has an older schema version than the Collection.
+func (c *Collection) decodeRecord(b []byte, dest any) error {
+	v, b, err := c.unpack(b)
+	if err != nil {
+		return err
+	}
+
+	return c.decodePayload(v, b, dest)
+}
+
+// decodePayload decodes the unpacked record b of schema version v to dest,
+// migrating it first if needed.
+func (c *Collection) decodePayload(v int, b []byte, dest any) error {
+	var err error
+	if v < c.SchemaVersion {
+		if b, err = c.migrate(b, v); err != nil {
+			return err
+		}
+	}
+
+	return c.Decoder.Decode(b, dest)
+}
+
+// indexesRecords returns true if the Collection maintains indexes of the
+// contents of its records, besides the unique indexes.
+func (c *Collection) indexesRecords() bool {
+	return len(c.references) > 0 || len(c.fullText.fields) > 0 || len(c.multiKey) > 0
+}
+
+// indexRecord replaces the old record id by rec in the indexes of the contents
+// of records. old or rec is nil if the record is created or deleted.
+func (c *Collection) indexRecord(id string, old any, rec any) {
+	c.indexReferences(id, old, rec)
+	c.indexText(id, old, rec)
+	c.indexMultiKeys(id, old, rec)
+}
+
+// unindexRecord removes the record id from the indexes of the contents of
+// records without knowledge of its contents, for collections opened without
+// their record type.
+func (c *Collection) unindexRecord(id string) {
+	for k := range c.Indexing.MultiKey.Keys {
+		c.removeMultiKey(k, id)
+	}
+
+	idx := &c.Indexing.FullText
+	for term, ids := range idx.Terms {
+		delete(ids, id)
+		if len(ids) == 0 {
+			delete(idx.Terms, term)
+		}
+	}
+	delete(idx.Lengths, id)
 }
 
 // exists returns true if the provided path exists.
 func (c *Collection) exists(id string) bool {
-	_, err := os.Stat(c.filepath(id, false))
-	return !os.IsNotExist(err)
+	_, err := c.Backend.Stat(c.filepath(id, false))
+	return !errors.Is(err, fs.ErrNotExist)
 }
 
-// existsIndexed returns true if an index exists for the provided field/value combination.
-func (c *Collection) existsIndexed(field string, value any) bool {
-	_, ok := c.Indexing.Indexes[key(field, value)]
+// existsIndexed returns true if an index exists for the provided key.
+func (c *Collection) existsIndexed(k string) bool {
+	_, ok := c.Indexing.Indexes[k]
 	return ok
 }
 
+// indexKeys returns the keys of the unique indexes of rec by field. Fields
+// that don't exist or are nil, and fields left out of sparse and partial
+// indexes, aren't indexed. An error is returned if a value
+// of rec can't be indexed, including those of the multi-key fields.
+func (c *Collection) indexKeys(rec any) (map[string]string, error) {
+	keys := make(map[string]string, len(c.Indexing.Fields))
+	for _, fld := range c.Indexing.Fields {
+		v := getFieldValue(rec, fld)
+		if c.skipIndex(rec, fld, v) {
+			continue
+		}
+
+		k, err := c.indexKey(fld, v)
+		if err != nil {
+			return nil, err
+		}
+		keys[fld] = k
+	}
+	if _, err := c.multiKeys(rec); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
 // saveIndexes saves the Collection's indexes to an index file.
 func (c *Collection) saveIndexes() error {
 	// Encode indexes.
-	b, err := c.Encoder.Encode(c.Indexing)
+	b, err := c.encode(c.Indexing)
 	if err != nil {
 		return fmt.Errorf("encoding indexes: %w", err)
 	}
@@ -194,36 +519,48 @@ func (c *Collection) saveIndexes() error {
 	if err := c.save(c.filepath(c.Name, true), b); err != nil {
 		return fmt.Errorf("saving index: %w", err)
 	}
+	c.indexSum = c.sumIndex()
 
 	return nil
 }
 
 // loadIndexes loads the Collection's indexes from the index file.
 func (c *Collection) loadIndexes() error {
-	b, err := c.load(c.filepath(c.Name, true))
-	if err != nil {
-		return fmt.Errorf("loading index: %w", err)
+	sum := c.sumIndex()
+	if o, ok := c.Backend.(*OverlayBackend); ok {
+		if err := c.loadOverlayIndexes(o); err != nil {
+			return err
+		}
+		c.indexSum = sum
+		return nil
 	}
 
-	if err := c.Decoder.Decode(b, &c.Indexing); err != nil {
-		return fmt.Errorf("decoding indexes: %w", err)
+	if err := c.read(c.filepath(c.Name, true), &c.Indexing); err != nil {
+		return fmt.Errorf("loading index: %w", err)
 	}
+	c.indexSum = sum
 
 	return nil
 }
 
-// setIndex stores the id for the provided field/value combination in memory and to disk.
-func (c *Collection) setIndex(field string, value any, id string) error {
-	c.Indexing.Indexes[key(field, value)] = id
-	return c.saveIndexes()
-}
-
+// recreateIndexes rebuilds the indexes from the records on disk.
+//
+// A *CorruptRecordError is returned if a record can't be read.
 func (c *Collection) recreateIndexes() error {
 	newIndexes := make(map[string]string)
+	c.Indexing.Normalizers = c.normalizers
+	c.resetReferences()
+	c.resetFullText()
+	c.resetMultiKey()
+	c.resetSparse()
+
+	if err := walkDir(c.Backend, c.fullpath(), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
 
-	if err := filepath.Walk(c.fullpath(), func(path string, info fs.FileInfo, _ error) error {
 		// Skip directories.
-		if info.IsDir() {
+		if d.IsDir() {
 			return nil
 		}
 
@@ -233,40 +570,44 @@ func (c *Collection) recreateIndexes() error {
 		}
 
 		// Load and decode the record file.
-		b, err := c.load(path)
-		if err != nil {
+		rec := reflect.New(c.record).Interface()
+		if err := c.read(path, &rec); err != nil {
 			return err
 		}
 
-		rec := reflect.New(c.record).Interface()
-		if err := c.Decoder.Decode(b, &rec); err != nil {
-			// TODO: Consider returning an error.
-			return nil
+		id := c.idFromPath(path)
+		keys, err := c.indexKeys(rec)
+		if err != nil {
+			return fmt.Errorf("record %q: %w", id, err)
 		}
-
-		for _, fld := range c.Indexing.Fields {
-			val := getFieldValue(rec, fld)
-			if val == nil {
-				return nil
+		// The first record in lexical order wins for duplicate values.
+		for _, k := range keys {
+			if _, ok := newIndexes[k]; !ok {
+				newIndexes[k] = id
 			}
-
-			id := reflect.ValueOf(rec).Field(0).String()
-			if id == "" {
-				// TODO: Consider returning an error.
-				return nil
-			}
-
-			newIndexes[key(fld, val)] = id
 		}
+		c.indexRecord(id, nil, rec)
 
-		c.Indexing.Indexes = newIndexes
 		return nil
 	}); err != nil {
 		return err
 	}
 
+	c.Indexing.Indexes = newIndexes
+	c.Indexing.KeyVersion = keyVersion
 	return nil
+}
 
+// indexed returns true if the loaded indexes were built for the provided
+// indexed fields and the settings of c.
+func (c *Collection) indexed(fields []string) bool {
+	// Keys of another format can't be looked up.
+	if (len(fields) > 0 || c.indexesRecords()) && c.Indexing.KeyVersion != keyVersion {
+		return false
+	}
+
+	return cmp.Equal(fields, c.Indexing.Fields) && c.referencesIndexed() &&
+		c.fullTextIndexed() && c.multiKeyIndexed() && c.normalizersIndexed() && c.sparseIndexed()
 }
 
 // Init will initialize a Collection.
@@ -278,6 +619,9 @@ func (c *Collection) Init() (*Collection, error) {
 	}
 
 	// Ensure that an encoder and decoder are set.
+	if (c.Encoder == nil || c.Decoder == nil) && c.encoding != "" {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownCodec, c.encoding)
+	}
 	if c.Encoder == nil {
 		return nil, fmt.Errorf("nil encoder")
 	}
@@ -285,74 +629,175 @@ func (c *Collection) Init() (*Collection, error) {
 		return nil, fmt.Errorf("nil decoder")
 	}
 
+	// Ensure that a backend is set.
+	if c.Backend == nil {
+		return nil, fmt.Errorf("nil backend")
+	}
+
+	// Ensure that records can be compressed.
+	if c.Compression.Algorithm != NoCompression {
+		if _, err := compressor(c.Compression.Algorithm); err != nil {
+			return nil, err
+		}
+	}
+
 	// Ensure the destination directory exists.
+	// Read-only collections can't be created.
+	if c.readOnly {
+		if _, err := c.Backend.Stat(c.fullpath()); err != nil {
+			return nil, fmt.Errorf("opening collection: %w", err)
+		}
+	}
 	_, dirPerm := c.filePerms()
-	os.MkdirAll(c.fullpath(), dirPerm)
+	if err := c.Backend.MkdirAll(c.fullpath(), dirPerm); err != nil {
+		return nil, fmt.Errorf("creating collection directory: %w", err)
+	}
 
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	// Determine the layout of the records.
+	if err := c.initLayout(); err != nil {
+		return nil, err
+	}
+
+	// Rename records stored before ids were encoded.
+	if err := c.initNames(); err != nil {
+		return nil, err
+	}
+
 	// IndexedFields by current settings.
 	indexedFields := c.Indexing.Fields
 
+	// Resolve the normalizers of the indexed fields.
+	for field := range c.normalizers {
+		if !contains(indexedFields, field) && !contains(c.multiKey, field) {
+			return nil, fmt.Errorf("normalizer for field %s, which isn't indexed", field)
+		}
+	}
+	if err := c.initNormalizers(c.normalizers); err != nil {
+		return nil, err
+	}
+
+	// Sparse and partial indexes are unique indexes.
+	for _, field := range append(c.partialFields(), c.sparse...) {
+		if !contains(indexedFields, field) {
+			return nil, fmt.Errorf("sparse or partial index for field %s, which isn't indexed", field)
+		}
+	}
+
 	// Load the index file contents. Continue if there's no index file.
-	if err := c.loadIndexes(); err != nil && !errors.Is(err, os.ErrNotExist) {
+	// A missing or corrupt index file is rebuilt from the records.
+	var corrupt *CorruptRecordError
+	err := c.loadIndexes()
+	if err != nil && !errors.Is(err, os.ErrNotExist) && !errors.As(err, &corrupt) {
 		return nil, err
 	}
+	missing := errors.Is(err, os.ErrNotExist) && (len(indexedFields) > 0 || c.indexesRecords())
 
-	// Recreate the indexes if the indexed fields from the load and settings differ.
-	if diff := cmp.Diff(indexedFields, c.Indexing.Fields); diff != "" {
+	// Recreate the indexes if the indexed fields or references from the load and settings differ.
+	if corrupt != nil || missing || !c.indexed(indexedFields) {
+		c.Indexing.Fields = indexedFields
 		if err := c.recreateIndexes(); err != nil {
 			return nil, fmt.Errorf("reindexing: %w", err)
 		}
 	}
 
+	if err := c.initReferences(); err != nil {
+		return nil, err
+	}
+
 	c.initialized = true
 	return c, nil
 }
 
 // Create encodes and stores the provided record to disk.
 func (c *Collection) Create(id string, data any) error {
+	return c.CreateAs("", id, data)
+}
+
+// CreateAs creates a record like Create, recording actor as the author in the
+// metadata of the record if the Collection stores metadata.
+func (c *Collection) CreateAs(actor string, id string, data any) error {
 	if !c.initialized {
 		return ErrNotInitialized
 	}
+	if c.readOnly {
+		return ErrReadOnly
+	}
 
 	// Ensure that data is in a workable format.
 	if !isStruct(data) && !isPointerToStruct(data) {
 		return ErrInvalidRecordType
 	}
 
+	if err := validateID(id); err != nil {
+		return err
+	}
+
+	c.gate.RLock()
+	defer c.gate.RUnlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	// IDs must be unique. Fail if the id already exists.
 	if c.exists(id) {
 		return ErrNotIDNotUnique
 	}
 
-	b, err := c.Encoder.Encode(data)
+	// Run the hooks, which may modify or reject the record.
+	data, err = c.beforeSave(c.hooks.beforeCreate, id, data)
+	if err != nil {
+		return err
+	}
+	if err := c.checkReferences(data); err != nil {
+		return err
+	}
+
+	var meta *Meta
+	if c.Metadata {
+		meta = nextMeta(nil, actor)
+	}
+
+	b, err := c.encodeRecord(data, meta)
 	if err != nil {
 		return fmt.Errorf("encoding data: %w", err)
 	}
 
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	// Get the index keys of the fields that should be indexed.
+	// Fields that don't exist or are nil aren't indexed.
+	keys, err := c.indexKeys(data)
+	if err != nil {
+		return err
+	}
 
-	// Loop over all fields that should be indexed
+	// Return an error if a field/value combination is not unique, before
+	// any of the keys is indexed.
 	for _, fld := range c.Indexing.Fields {
-		// Get the value from the field.
-		// Continue if the field doesn't exist.
-		// TODO: Consider to returning error.
-		v := getFieldValue(data, fld)
-		if v == nil {
-			continue
-		}
-
-		// Return an error if the field/value combination is not unique.
-		if c.existsIndexed(fld, v) {
+		if k, ok := keys[fld]; ok && c.existsIndexed(k) {
 			err := IndexedValueNotUniqueError{Field: fld}
 			return &err
 		}
+	}
 
-		// Store the index data.
-		c.setIndex(fld, v, id)
+	// Store the index data.
+	for _, k := range keys {
+		c.Indexing.Indexes[k] = id
+	}
+	if c.indexesRecords() {
+		c.indexRecord(id, nil, data)
+	}
+	if len(keys) > 0 || c.indexesRecords() {
+		if err := c.saveIndexes(); err != nil {
+			return fmt.Errorf("saving indexes: %w", err)
+		}
 	}
 
 	// Store the record to disk.
@@ -360,20 +805,43 @@ func (c *Collection) Create(id string, data any) error {
 		return fmt.Errorf("saving record: %w", err)
 	}
 
-	return nil
+	return runHooks(c.hooks.afterCreate, id, data)
 }
 
 // Query returns a slice of data based on the result of the filter function.
 // The filter function uses the type as set in Init.
-func (c *Collection) Query(f func(any) bool) (res []any, err error) {
+//
+// Query aborts with a *CorruptRecordError when a record can't be read,
+// unless the WithSkipCorrupt option is provided.
+func (c *Collection) Query(f func(any) bool, opts ...QueryOption) (res []any, err error) {
 	if !c.initialized {
 		return nil, ErrNotInitialized
 	}
 
+	qo := newQueryOptions(opts)
+	if res, err = c.query(f, qo); err != nil {
+		return nil, err
+	}
+	if _, err := c.include(res, qo); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// query returns the records for which f returns true, without including
+// referenced records.
+func (c *Collection) query(f func(any) bool, qo *queryOptions) (res []any, err error) {
+	var metas []Meta
+
 	// Loop over the directory.
-	if err := filepath.Walk(c.fullpath(), func(path string, info fs.FileInfo, _ error) error {
+	if err := walkDir(c.Backend, c.fullpath(), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
 		// Skip directories.
-		if info.IsDir() {
+		if d.IsDir() {
 			return nil
 		}
 
@@ -382,19 +850,28 @@ func (c *Collection) Query(f func(any) bool) (res []any, err error) {
 			return nil
 		}
 
-		o := reflect.New(c.record).Interface()
 		// Load and decode the record file.
-		b, err := c.load(path)
+		o := reflect.New(c.record).Interface()
+		meta, ok, err := c.queryRecord(path, &o, qo)
 		if err != nil {
-			return err
+			var corrupt *CorruptRecordError
+			if !qo.skipCorrupt || !errors.As(err, &corrupt) {
+				return err
+			}
+
+			if qo.onCorrupt != nil {
+				qo.onCorrupt(corrupt)
+			}
+			return nil
 		}
-		if err := c.Decoder.Decode(b, &o); err != nil {
-			return err
+		if !ok {
+			return nil
 		}
 
 		// Run the filter and append to result if result is positive.
 		if f(o) {
 			res = append(res, o)
+			metas = append(metas, meta)
 		}
 
 		return nil
@@ -402,20 +879,28 @@ func (c *Collection) Query(f func(any) bool) (res []any, err error) {
 		return nil, err
 	}
 
+	qo.sortByMeta(res, metas)
+
 	return res, nil
 }
 
-func (c *Collection) QueryPaginated(f func(any) bool, page int, rows int) (res []any, pages int, err error) {
+// QueryPaginated returns a page of data based on the result of the filter function
+// and the total number of pages. It accepts the same options as Query.
+func (c *Collection) QueryPaginated(f func(any) bool, page int, rows int, opts ...QueryOption) (res []any, pages int, err error) {
 	if !c.initialized {
 		return nil, 0, ErrNotInitialized
 	}
 
-	recs, err := c.Query(f)
+	qo := newQueryOptions(opts)
+	recs, err := c.query(f, qo)
 	if err != nil {
 		return res, 0, err
 	}
 	// Return everything if page and row are 0.
 	if page == 0 && rows == 0 {
+		if _, err := c.include(recs, qo); err != nil {
+			return nil, 0, err
+		}
 		return recs, 0, nil
 	}
 
@@ -439,6 +924,11 @@ func (c *Collection) QueryPaginated(f func(any) bool, page int, rows int) (res [
 		to = count
 	}
 
+	// Include the referenced records of the page only.
+	if _, err := c.include(recs[from:to], qo); err != nil {
+		return nil, 0, err
+	}
+
 	return recs[from:to], pages, nil
 }
 
@@ -456,28 +946,29 @@ func (c *Collection) Get(id string, dest any) error {
 		return ErrInvalidRecordType
 	}
 
+	if err := validateID(id); err != nil {
+		return err
+	}
+
 	// Return an error if the record doesn't exist.
 	if !c.exists(id) {
 		return ErrNotFound
 	}
 
 	// Load the record from disk and decode the file's contents.
-	b, err := c.load(c.filepath(id, false))
-	if err != nil {
+	if err := c.read(c.filepath(id, false), dest); err != nil {
 		return fmt.Errorf("loading record: %w", err)
 	}
-	if err := c.Decoder.Decode(b, dest); err != nil {
-		return fmt.Errorf("decoding data: %w", err)
-	}
 
 	return nil
 }
 
 // GetIndexed receives a record from disk through the index of field/v
-// and will decode the result to dest.
+// and will decode the result to dest. v matches values of the same kind, so
+// an int field can be looked up with any integer or integral float.
 //
 // dest should be a pointer to a struct.
-func (c *Collection) GetIndexed(field string, v string, dest any) error {
+func (c *Collection) GetIndexed(field string, v any, dest any) error {
 	if !c.initialized {
 		return ErrNotInitialized
 	}
@@ -490,74 +981,142 @@ func (c *Collection) GetIndexed(field string, v string, dest any) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	k, err := c.indexKey(field, v)
+	if err != nil {
+		return err
+	}
+
 	// Retrieve the id from the index. Return ErrNotFound is it doesn't exist.
-	id, ok := c.Indexing.Indexes[key(field, v)]
+	id, ok := c.Indexing.Indexes[k]
 	if !ok {
 		return ErrNotFound
 	}
 
 	// Load the record from file and decode contents.
-	b, err := c.load(c.filepath(id, false))
-	if err != nil {
+	if err := c.read(c.filepath(id, false), dest); err != nil {
 		return fmt.Errorf("loading record: %w", err)
 	}
-	if err := c.Decoder.Decode(b, dest); err != nil {
-		return fmt.Errorf("decoding data: %w", err)
-	}
 
 	return nil
 }
 
 // Update stores an updated record to disk.
 func (c *Collection) Update(id string, data any) error {
+	return c.UpdateAs("", id, data)
+}
+
+// UpdateAs updates a record like Update, recording actor as the author in the
+// metadata of the record if the Collection stores metadata.
+func (c *Collection) UpdateAs(actor string, id string, data any) error {
 	if !c.initialized {
 		return ErrNotInitialized
 	}
+	if c.readOnly {
+		return ErrReadOnly
+	}
 
 	// Ensure that data is in a workable format.
 	if !isStruct(data) && !isPointerToStruct(data) {
 		return ErrInvalidRecordType
 	}
 
-	// Get old data or return error if doesn't exist.
-	var oldRec any
-	{
-		b, err := c.load(c.filepath(id, false))
+	if err := validateID(id); err != nil {
+		return err
+	}
+
+	c.gate.RLock()
+	defer c.gate.RUnlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Get old data or return error if doesn't exist. It's read under the
+	// locks, so concurrent updates don't unindex stale values.
+	oldRec := reflect.New(c.record).Interface()
+	if err := c.read(c.filepath(id, false), oldRec); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	return c.update(actor, id, oldRec, data)
+}
+
+// update replaces the record id, which was oldRec, by data on disk and in the
+// indexes, running the update hooks. The Collection must be locked.
+func (c *Collection) update(actor string, id string, oldRec any, data any) error {
+	// Run the hooks, which may modify or reject the record.
+	data, err := c.beforeSave(c.hooks.beforeUpdate, id, data)
+	if err != nil {
+		return err
+	}
+	if err := c.checkReferences(data); err != nil {
+		return err
+	}
+
+	// Keep the creation time and count the revisions of the record.
+	var meta *Meta
+	if c.Metadata {
+		old, err := c.readMeta(c.filepath(id, false), nil)
 		if err != nil {
-			if errors.Is(err, os.ErrNotExist) {
-				return ErrNotFound
-			}
 			return err
 		}
-		if err := c.Decoder.Decode(b, &oldRec); err != nil {
-			return err
+		if old == nil {
+			// The record was created before metadata was enabled.
+			old = &Meta{}
 		}
+		meta = nextMeta(old, actor)
 	}
 
 	// Encode data
-	b, err := c.Encoder.Encode(data)
+	b, err := c.encodeRecord(data, meta)
 	if err != nil {
 		return fmt.Errorf("encoding data: %w", err)
 	}
 
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	keys, err := c.indexKeys(data)
+	if err != nil {
+		return err
+	}
 
-	// Update indexes.
+	// Return an error if a field/value combination is indexed for another record.
 	for _, fld := range c.Indexing.Fields {
-		// Remove old value, if any, to prevent index polution.
-		oldVals, oldValsOk := oldRec.(map[string]any)
-		oldv, oldValOk := oldVals[fld]
-		if oldValsOk && oldValOk {
-			delete(c.Indexing.Indexes, key(fld, oldv))
+		k, ok := keys[fld]
+		if !ok {
+			continue
+		}
+		if owner, ok := c.Indexing.Indexes[k]; ok && owner != id {
+			err := IndexedValueNotUniqueError{Field: fld}
+			return &err
 		}
+	}
 
-		// Set new value.
-		v := getFieldValue(data, fld)
-		if v == nil {
-			continue
+	// Update indexes. Remove old values, if any, to prevent index polution
+	// before the new values are set.
+	for _, fld := range c.Indexing.Fields {
+		if oldv := getFieldValue(oldRec, fld); oldv != nil {
+			if k, err := c.indexKey(fld, oldv); err == nil && c.Indexing.Indexes[k] == id {
+				delete(c.Indexing.Indexes, k)
+			}
+		}
+	}
+	for _, k := range keys {
+		c.Indexing.Indexes[k] = id
+	}
+	if c.indexesRecords() {
+		c.indexRecord(id, oldRec, data)
+	}
+	if len(c.Indexing.Fields) > 0 || c.indexesRecords() {
+		if err := c.saveIndexes(); err != nil {
+			return fmt.Errorf("saving indexes: %w", err)
 		}
-		c.setIndex(fld, v, id)
 	}
 
 	// Store record to disk.
@@ -565,7 +1124,7 @@ func (c *Collection) Update(id string, data any) error {
 		return fmt.Errorf("saving record: %w", err)
 	}
 
-	return nil
+	return runHooks(c.hooks.afterUpdate, id, data)
 }
 
 // Delete removes a record from disk and indexes.
@@ -573,16 +1132,68 @@ func (c *Collection) Delete(id string) error {
 	if !c.initialized {
 		return ErrNotInitialized
 	}
+	if c.readOnly {
+		return ErrReadOnly
+	}
+
+	if err := validateID(id); err != nil {
+		return err
+	}
+
+	c.gate.RLock()
+	defer c.gate.RUnlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	// Return an error if the record doesn't exist.
 	if !c.exists(id) {
 		return ErrNotFound
 	}
 
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	// Apply the delete rules of the records referencing the record, locking
+	// their collections. c is locked already.
+	locks := refLocks{c: nil}
+	defer locks.unlock()
+
+	var plan deletePlan
+	if err := c.planDelete(id, locks, &plan); err != nil {
+		return err
+	}
+
+	return plan.apply()
+}
+
+// remove removes the record id from disk and indexes. The Collection must be locked.
+func (c *Collection) remove(id string) error {
+	if c.readOnly {
+		return ErrReadOnly
+	}
+
+	// The delete hooks are called with the stored record, which is needed
+	// to remove its references as well.
+	var old any
+	if len(c.hooks.beforeDelete) > 0 || len(c.hooks.afterDelete) > 0 || c.indexesRecords() {
+		old = &map[string]any{}
+		if c.record != nil {
+			old = reflect.New(c.record).Interface()
+		}
+		if err := c.read(c.filepath(id, false), old); err != nil {
+			return fmt.Errorf("reading record: %w", err)
+		}
+		if err := runHooks(c.hooks.beforeDelete, id, old); err != nil {
+			return err
+		}
+	}
+
 	// Remove the physical file.
-	if err := os.Remove(c.filepath(id, false)); err != nil {
+	if err := c.Backend.Remove(c.filepath(id, false)); err != nil {
 		return fmt.Errorf("deleting record: %w", err)
 	}
 
@@ -594,10 +1205,16 @@ func (c *Collection) Delete(id string) error {
 
 		delete(c.Indexing.Indexes, k)
 	}
+	if c.indexesRecords() {
+		c.indexRecord(id, old, nil)
+	}
+	if c.record == nil {
+		c.unindexRecord(id)
+	}
 
 	if err := c.saveIndexes(); err != nil {
 		return fmt.Errorf("saving indexes: %w", err)
 	}
 
-	return nil
+	return runHooks(c.hooks.afterDelete, id, old)
 }
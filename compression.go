@@ -0,0 +1,345 @@
+package sdstore
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"strings"
+	"sync"
+)
+
+// Compression identifies the compression algorithm used for records.
+type Compression byte
+
+// Supported compression algorithms.
+//
+// Gzip, Flate and Snappy are provided out of the box. Zstd is a reserved
+// identifier without a built-in implementation, which keeps this module free of
+// extra dependencies; a Compressor has to be registered for it with
+// RegisterCompressor before it can be used, for example one backed by
+// github.com/klauspost/compress/zstd.
+const (
+	NoCompression Compression = iota
+	Gzip
+	Flate
+	Zstd
+	Snappy
+)
+
+// String implements the Stringer interface for Compression.
+func (c Compression) String() string {
+	switch c {
+	case NoCompression:
+		return "none"
+	case Gzip:
+		return "gzip"
+	case Flate:
+		return "flate"
+	case Zstd:
+		return "zstd"
+	case Snappy:
+		return "snappy"
+	}
+	return fmt.Sprintf("compression(%d)", byte(c))
+}
+
+// ErrUnknownCompression is an error returned when a record is compressed with
+// an algorithm for which no Compressor is registered.
+var ErrUnknownCompression = errors.New("unknown compression algorithm")
+
+// errDecompressedSize is returned when compressed data expands beyond the size
+// recorded in its header.
+var errDecompressedSize = errors.New("decompressed size exceeds the recorded size")
+
+// maxDecompressedSize is the limit of the Decompress methods of the built-in
+// Compressors, which don't know the recorded size.
+const maxDecompressedSize = math.MaxInt64 - 1
+
+// compressionMagic prefixes every compressed record. Records are encoded structs,
+// which never start with a zero byte in any of the supported encodings, so
+// compressed and uncompressed records can coexist in one collection.
+const compressionMagic = "\x00SDZ"
+
+// Compressor is an interface that record compressors have to implement.
+type Compressor interface {
+	Compress([]byte) ([]byte, error)
+	Decompress([]byte) ([]byte, error)
+}
+
+// limitDecompressor is implemented by Compressors that stop decompressing as
+// soon as the output exceeds limit bytes, so a corrupt or crafted file can't
+// exhaust memory.
+type limitDecompressor interface {
+	decompressLimit(b []byte, limit uint64) ([]byte, error)
+}
+
+var (
+	compressorsMu sync.RWMutex
+	compressors   = map[Compression]Compressor{
+		Gzip:   gzipCompressor{},
+		Flate:  flateCompressor{},
+		Snappy: snappyCompressor{},
+	}
+)
+
+// RegisterCompressor registers the Compressor for the provided algorithm,
+// replacing any previously registered implementation.
+func RegisterCompressor(algo Compression, c Compressor) {
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+
+	compressors[algo] = c
+}
+
+// compressor returns the registered Compressor for algo.
+func compressor(algo Compression) (Compressor, error) {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+
+	c, ok := compressors[algo]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownCompression, algo)
+	}
+	return c, nil
+}
+
+// WithCompression is an option to compress records and indexes with algo.
+// Encoded data smaller than minSize bytes is stored uncompressed. Opening the
+// collection fails with ErrUnknownCompression if no Compressor is registered
+// for algo.
+func WithCompression(algo Compression, minSize int) CollectionOption {
+	return func(c *Collection) {
+		c.Compression.Algorithm = algo
+		c.Compression.MinSize = minSize
+	}
+}
+
+// compress compresses b according to the Collection's settings and prefixes
+// the result with a header describing the algorithm and original size.
+func (c *Collection) compress(b []byte) ([]byte, error) {
+	algo := c.Compression.Algorithm
+	if algo == NoCompression || len(b) < c.Compression.MinSize {
+		return b, nil
+	}
+
+	comp, err := compressor(algo)
+	if err != nil {
+		return nil, err
+	}
+
+	z, err := comp.Compress(b)
+	if err != nil {
+		return nil, fmt.Errorf("compressing: %w", err)
+	}
+
+	hdr := make([]byte, len(compressionMagic)+1+binary.MaxVarintLen64)
+	n := copy(hdr, compressionMagic)
+	hdr[n] = byte(algo)
+	n++
+	n += binary.PutUvarint(hdr[n:], uint64(len(b)))
+
+	return append(hdr[:n], z...), nil
+}
+
+// parseCompressionHeader returns the algorithm, the uncompressed size and the
+// compressed payload of b. ok is false if b isn't compressed.
+func parseCompressionHeader(b []byte) (algo Compression, size uint64, payload []byte, ok bool) {
+	if !bytes.HasPrefix(b, []byte(compressionMagic)) || len(b) < len(compressionMagic)+2 {
+		return NoCompression, 0, b, false
+	}
+
+	b = b[len(compressionMagic):]
+	algo = Compression(b[0])
+	size, n := binary.Uvarint(b[1:])
+	if n <= 0 {
+		return NoCompression, 0, nil, false
+	}
+
+	return algo, size, b[1+n:], true
+}
+
+// decompress returns the uncompressed content of b. Data without a
+// compression header is returned as is.
+func decompress(b []byte) ([]byte, error) {
+	if !bytes.HasPrefix(b, []byte(compressionMagic)) {
+		return b, nil
+	}
+
+	algo, size, payload, ok := parseCompressionHeader(b)
+	if !ok || size > maxDecompressedSize {
+		return nil, fmt.Errorf("invalid compression header")
+	}
+
+	comp, err := compressor(algo)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw []byte
+	if lc, ok := comp.(limitDecompressor); ok {
+		raw, err = lc.decompressLimit(payload, size)
+	} else {
+		raw, err = comp.Decompress(payload)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decompressing: %w", err)
+	}
+	if uint64(len(raw)) != size {
+		return nil, fmt.Errorf("decompressing: expected %d bytes, got %d", size, len(raw))
+	}
+
+	return raw, nil
+}
+
+// CompressionStats describes the compression achieved for a Collection's records.
+type CompressionStats struct {
+	Records    int
+	Compressed int
+	RawBytes   int64
+	DiskBytes  int64
+}
+
+// Ratio returns the ratio between the raw and the stored size of the records.
+// A ratio of 4 means that records take a quarter of their encoded size on disk.
+func (s CompressionStats) Ratio() float64 {
+	if s.DiskBytes == 0 {
+		return 1
+	}
+	return float64(s.RawBytes) / float64(s.DiskBytes)
+}
+
+// CompressionStats returns the compression statistics of the Collection's records.
+func (c *Collection) CompressionStats() (CompressionStats, error) {
+	if !c.initialized {
+		return CompressionStats{}, ErrNotInitialized
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var stats CompressionStats
+	if err := walkDir(c.Backend, c.fullpath(), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Skip directories and files that are not records.
+		if d.IsDir() || !strings.HasSuffix(path, ".sds") {
+			return nil
+		}
+
+		b, err := c.load(path)
+		if err != nil {
+			return err
+		}
+
+		stats.Records++
+		stats.DiskBytes += int64(len(b))
+
+		// Records are compressed before their checksum is added.
+		payload, err := c.unseal(b)
+		if err != nil {
+			return &CorruptRecordError{ID: c.idFromPath(path), Path: path, Err: err}
+		}
+
+		_, size, _, ok := parseCompressionHeader(payload)
+		if !ok {
+			stats.RawBytes += int64(len(payload))
+			return nil
+		}
+
+		stats.Compressed++
+		stats.RawBytes += int64(size)
+		return nil
+	}); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return stats, nil
+		}
+		return stats, err
+	}
+
+	return stats, nil
+}
+
+// gzipCompressor implements the Compressor interface using gzip.
+type gzipCompressor struct{}
+
+// Compress implements the Compressor interface for gzipCompressor.
+func (gzipCompressor) Compress(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress implements the Compressor interface for gzipCompressor.
+func (c gzipCompressor) Decompress(b []byte) ([]byte, error) {
+	return c.decompressLimit(b, maxDecompressedSize)
+}
+
+// decompressLimit implements the limitDecompressor interface for
+// gzipCompressor.
+func (gzipCompressor) decompressLimit(b []byte, limit uint64) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readLimit(r, limit)
+}
+
+// flateCompressor implements the Compressor interface using DEFLATE.
+type flateCompressor struct{}
+
+// Compress implements the Compressor interface for flateCompressor.
+func (flateCompressor) Compress(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress implements the Compressor interface for flateCompressor.
+func (c flateCompressor) Decompress(b []byte) ([]byte, error) {
+	return c.decompressLimit(b, maxDecompressedSize)
+}
+
+// decompressLimit implements the limitDecompressor interface for
+// flateCompressor.
+func (flateCompressor) decompressLimit(b []byte, limit uint64) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(b))
+	defer r.Close()
+	return readLimit(r, limit)
+}
+
+// readLimit reads r up to EOF and fails as soon as it yields more than limit
+// bytes.
+func readLimit(r io.Reader, limit uint64) ([]byte, error) {
+	b, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(b)) > limit {
+		return nil, fmt.Errorf("%w: more than %d bytes", errDecompressedSize, limit)
+	}
+	return b, nil
+}
@@ -0,0 +1,176 @@
+package sdstore_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/toqns/sdstore"
+)
+
+func TestCompression(t *testing.T) {
+	store, err := sdstore.New("compression", t.TempDir(), sdstore.WithJSONEncoding())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+	}
+	t.Logf("%s\tShould be able to create a new store.", success)
+
+	for _, algo := range []sdstore.Compression{sdstore.Zstd, sdstore.Compression(9)} {
+		if _, err := store.Collection("test", Record{}, sdstore.WithCompression(algo, 0)); !errors.Is(err, sdstore.ErrUnknownCompression) {
+			t.Fatalf("%s\tShould not be able to use an unregistered compression algorithm: %v.", failed, err)
+		}
+	}
+	t.Logf("%s\tShould not be able to use an unregistered compression algorithm.", success)
+
+	// Write a record without compression first to verify that compressed and
+	// uncompressed records can coexist.
+	plain, err := store.Collection("test", Record{})
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+	}
+	t.Logf("%s\tShould be able to create a new collection.", success)
+
+	rec1 := Record{ID: "1", Name: "Plain", Email: "plain@example.com"}
+	if err := plain.Create(rec1.ID, rec1); err != nil {
+		t.Fatalf("%s\tShould be able to create an uncompressed record: %v.", failed, err)
+	}
+	t.Logf("%s\tShould be able to create an uncompressed record.", success)
+
+	for _, algo := range []sdstore.Compression{sdstore.Gzip, sdstore.Flate, sdstore.Snappy} {
+		c, err := store.Collection("test", Record{}, sdstore.WithCompression(algo, 64))
+		if err != nil {
+			t.Fatalf("%s\tShould be able to create a compressed collection: %v.", failed, err)
+		}
+		t.Logf("%s\tShould be able to create a compressed %s collection.", success, algo)
+
+		rec2 := Record{ID: algo.String(), Name: strings.Repeat("compressible ", 100), Email: "zip@example.com"}
+		if err := c.Create(rec2.ID, rec2); err != nil {
+			t.Fatalf("%s\tShould be able to create a compressed record: %v.", failed, err)
+		}
+		t.Logf("%s\tShould be able to create a compressed record.", success)
+
+		for _, exp := range []Record{rec1, rec2} {
+			var got Record
+			if err := c.Get(exp.ID, &got); err != nil {
+				t.Fatalf("%s\tShould be able to get record %q: %v.", failed, exp.ID, err)
+			}
+			if diff := cmp.Diff(got, exp); diff != "" {
+				t.Fatalf("%s\tShould get expected result: %v.", failed, diff)
+			}
+		}
+		t.Logf("%s\tShould be able to get compressed and uncompressed records.", success)
+	}
+
+	c, err := store.Collection("test", Record{})
+	if err != nil {
+		t.Fatalf("%s\tShould be able to reopen the collection: %v.", failed, err)
+	}
+
+	stats, err := c.CompressionStats()
+	if err != nil {
+		t.Fatalf("%s\tShould be able to get compression stats: %v.", failed, err)
+	}
+	t.Logf("%s\tShould be able to get compression stats.", success)
+
+	if stats.Records != 4 || stats.Compressed != 3 {
+		t.Fatalf("%s\tShould count 4 records of which 3 compressed, got: %+v.", failed, stats)
+	}
+	t.Logf("%s\tShould count 4 records of which 3 compressed.", success)
+
+	if stats.Ratio() <= 1 {
+		t.Fatalf("%s\tShould achieve a compression ratio above 1, got: %f.", failed, stats.Ratio())
+	}
+	t.Logf("%s\tShould achieve a compression ratio above 1.", success)
+}
+
+func TestCompressionStatsWithChecksums(t *testing.T) {
+	store, err := sdstore.New("compression", t.TempDir(), sdstore.WithJSONEncoding())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+	}
+	c, err := store.Collection("test", Record{}, sdstore.WithCompression(sdstore.Gzip, 64), sdstore.WithChecksums())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a compressed collection with checksums: %v.", failed, err)
+	}
+
+	for _, rec := range []Record{
+		{ID: "1", Name: "Small"},
+		{ID: "2", Name: strings.Repeat("compressible ", 100)},
+	} {
+		if err := c.Create(rec.ID, rec); err != nil {
+			t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+		}
+	}
+
+	stats, err := c.CompressionStats()
+	if err != nil {
+		t.Fatalf("%s\tShould be able to get compression stats: %v.", failed, err)
+	}
+	if stats.Records != 2 || stats.Compressed != 1 || stats.Ratio() <= 1 {
+		t.Fatalf("%s\tShould count the compressed records with checksums, got: %+v.", failed, stats)
+	}
+	t.Logf("%s\tShould count the compressed records with checksums.", success)
+}
+
+func TestDecompressRecordedSize(t *testing.T) {
+	path := t.TempDir()
+	store, err := sdstore.New("compression", path, sdstore.WithJSONEncoding())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+	}
+	c, err := store.Collection("test", Record{}, sdstore.WithCompression(sdstore.Snappy, 0))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a compressed collection: %v.", failed, err)
+	}
+
+	// A record encoded by hand in the Snappy block format, with a copy of the
+	// last 4 bytes of the literal.
+	raw := `{"ID":"1","Name":"abcdabcdab"}`
+	snappy := append([]byte{byte(len(raw)), 21 << 2}, `{"ID":"1","Name":"abcd`...)
+	snappy = append(snappy, 2<<2|0x01, 4, 1<<2)
+	snappy = append(snappy, `"}`...)
+	record := func(size int, payload []byte) []byte {
+		return append([]byte{0x00, 'S', 'D', 'Z', byte(sdstore.Snappy), byte(size)}, payload...)
+	}
+
+	recPath := filepath.Join(path, "compression", "test", "1.sds")
+	if err := os.WriteFile(recPath, record(len(raw), snappy), 0600); err != nil {
+		t.Fatalf("%s\tShould be able to write the record file: %v.", failed, err)
+	}
+	var got Record
+	if err := c.Get("1", &got); err != nil || got.Name != "abcdabcdab" {
+		t.Fatalf("%s\tShould be able to decode Snappy compressed data: %+v, %v.", failed, got, err)
+	}
+	t.Logf("%s\tShould be able to decode Snappy compressed data.", success)
+
+	// Record a smaller size than the compressed data expands to.
+	if err := os.WriteFile(recPath, record(len(raw)-1, snappy), 0600); err != nil {
+		t.Fatalf("%s\tShould be able to write the record file: %v.", failed, err)
+	}
+	if err := c.Get("1", &got); err == nil {
+		t.Fatalf("%s\tShould not decompress beyond the recorded size.", failed)
+	}
+	t.Logf("%s\tShould not decompress beyond the recorded size.", success)
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(make([]byte, 1<<20)); err != nil {
+		t.Fatalf("%s\tShould be able to compress data: %v.", failed, err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("%s\tShould be able to compress data: %v.", failed, err)
+	}
+	bomb := append([]byte{0x00, 'S', 'D', 'Z', byte(sdstore.Gzip), 64}, buf.Bytes()...)
+	if err := os.WriteFile(recPath, bomb, 0600); err != nil {
+		t.Fatalf("%s\tShould be able to write the record file: %v.", failed, err)
+	}
+	if err := c.Get("1", &got); err == nil {
+		t.Fatalf("%s\tShould not decompress gzip data beyond the recorded size.", failed)
+	}
+	t.Logf("%s\tShould not decompress gzip data beyond the recorded size.", success)
+}
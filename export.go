@@ -0,0 +1,595 @@
+package sdstore
+
+import (
+	"bufio"
+	"encoding"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Format is a neutral data format records can be exported to and imported from.
+type Format int
+
+// Supported export and import formats.
+const (
+	// FormatJSONL writes one JSON object per line in the form
+	// {"id": "<id>", "record": {...}}.
+	FormatJSONL Format = iota
+
+	// FormatCSV writes a header row with an "id" column followed by the exported
+	// top-level fields of the record type, and one row per record.
+	// Fields that aren't scalars or encoding.TextMarshalers are written as JSON.
+	FormatCSV
+)
+
+// ImportMode determines how Import handles records whose id already exists.
+type ImportMode int
+
+// Supported import modes.
+const (
+	// ImportFail aborts the import with ErrNotIDNotUnique.
+	ImportFail ImportMode = iota
+
+	// ImportSkip keeps the existing record.
+	ImportSkip
+
+	// ImportOverwrite replaces the existing record.
+	ImportOverwrite
+)
+
+// ErrUnknownFormat is an error returned when an unsupported Format is used.
+var ErrUnknownFormat = errors.New("unknown format")
+
+// exportLine is a single line of a JSONL export.
+type exportLine struct {
+	ID     string          `json:"id"`
+	Record json.RawMessage `json:"record"`
+}
+
+// Export writes all records of the Collection to w in the provided format,
+// ordered by id.
+func (c *Collection) Export(w io.Writer, format Format) error {
+	if !c.initialized {
+		return ErrNotInitialized
+	}
+
+	ids, err := c.ids()
+	if err != nil {
+		return err
+	}
+
+	var cw *csv.Writer
+	var fields []reflect.StructField
+	switch format {
+	case FormatJSONL:
+	case FormatCSV:
+		cw = csv.NewWriter(w)
+		fields = csvFields(c.record)
+
+		header := []string{"id"}
+		for _, f := range fields {
+			header = append(header, f.Name)
+		}
+		if err := cw.Write(header); err != nil {
+			return fmt.Errorf("writing header: %w", err)
+		}
+	default:
+		return ErrUnknownFormat
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, id := range ids {
+		rec := reflect.New(c.record)
+		if err := c.read(c.filepath(id, false), rec.Interface()); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return fmt.Errorf("loading record: %w", err)
+		}
+
+		switch format {
+		case FormatJSONL:
+			b, err := json.Marshal(rec.Interface())
+			if err != nil {
+				return fmt.Errorf("encoding record %q: %w", id, err)
+			}
+			line, err := json.Marshal(exportLine{ID: id, Record: b})
+			if err != nil {
+				return fmt.Errorf("encoding record %q: %w", id, err)
+			}
+			if _, err := bw.Write(append(line, '\n')); err != nil {
+				return fmt.Errorf("writing record %q: %w", id, err)
+			}
+
+		case FormatCSV:
+			row := []string{id}
+			for _, f := range fields {
+				s, err := formatCSVValue(rec.Elem().FieldByIndex(f.Index))
+				if err != nil {
+					return fmt.Errorf("encoding record %q: %s: %w", id, f.Name, err)
+				}
+				row = append(row, s)
+			}
+			if err := cw.Write(row); err != nil {
+				return fmt.Errorf("writing record %q: %w", id, err)
+			}
+		}
+	}
+
+	if cw != nil {
+		cw.Flush()
+		return cw.Error()
+	}
+	return bw.Flush()
+}
+
+// Import reads records in the provided format from r and stores them in the
+// Collection. mode determines what happens to records whose id already exists.
+// It returns the number of records that were written.
+func (c *Collection) Import(r io.Reader, format Format, mode ImportMode) (int, error) {
+	if !c.initialized {
+		return 0, ErrNotInitialized
+	}
+	if c.readOnly {
+		return 0, ErrReadOnly
+	}
+
+	var n int
+	store := func(id string, rec any) error {
+		err := c.Create(id, rec)
+		switch {
+		case err == nil:
+			n++
+			return nil
+		case !errors.Is(err, ErrNotIDNotUnique) || mode == ImportFail:
+			return fmt.Errorf("importing record %q: %w", id, err)
+		case mode == ImportSkip:
+			return nil
+		}
+
+		if err := c.Update(id, rec); err != nil {
+			return fmt.Errorf("importing record %q: %w", id, err)
+		}
+		n++
+		return nil
+	}
+
+	switch format {
+	case FormatJSONL:
+		dec := json.NewDecoder(r)
+		for {
+			var line exportLine
+			if err := dec.Decode(&line); err != nil {
+				if errors.Is(err, io.EOF) {
+					return n, nil
+				}
+				return n, fmt.Errorf("decoding line: %w", err)
+			}
+
+			rec := reflect.New(c.record)
+			if err := json.Unmarshal(line.Record, rec.Interface()); err != nil {
+				return n, fmt.Errorf("decoding record %q: %w", line.ID, err)
+			}
+			if err := store(line.ID, rec.Interface()); err != nil {
+				return n, err
+			}
+		}
+
+	case FormatCSV:
+		cr := csv.NewReader(r)
+		header, err := cr.Read()
+		if err != nil {
+			return n, fmt.Errorf("reading header: %w", err)
+		}
+		if len(header) == 0 || header[0] != "id" {
+			return n, fmt.Errorf("reading header: first column should be %q", "id")
+		}
+
+		for {
+			row, err := cr.Read()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					return n, nil
+				}
+				return n, fmt.Errorf("reading row: %w", err)
+			}
+
+			rec := reflect.New(c.record)
+			for i, name := range header[1:] {
+				f := rec.Elem().FieldByName(name)
+				if !f.IsValid() || !f.CanSet() {
+					return n, fmt.Errorf("decoding record %q: unknown field %q", row[0], name)
+				}
+				if err := parseCSVValue(row[i+1], f); err != nil {
+					return n, fmt.Errorf("decoding record %q: %s: %w", row[0], name, err)
+				}
+			}
+			if err := store(row[0], rec.Interface()); err != nil {
+				return n, err
+			}
+		}
+	}
+
+	return 0, ErrUnknownFormat
+}
+
+// convertFile is the name of the file in the directory of a converted collection
+// holding the codec name of its new encoding, until the encoding is recorded in
+// the store manifest.
+const convertFile = ".convert"
+
+// ConvertEncoding rewrites every record and the index of the Collection with the
+// provided encoder and decoder.
+//
+// The collection is converted into a new directory which replaces the current
+// one once all records have been converted, so a failed conversion leaves the
+// collection untouched. A conversion interrupted while the directories are
+// swapped is completed when the collection is opened again. Writes to the
+// collection are blocked during the conversion, in other processes as well.
+// The new encoding has to be used when the collection is opened again, unless
+// its codec name is recorded like with WithCollectionEncoding. Other open
+// handles of the collection switch to the new encoding on their next write if
+// its codec name is recorded.
+func (c *Collection) ConvertEncoding(e Encoder, d Decoder) error {
+	if !c.initialized {
+		return ErrNotInitialized
+	}
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	if e == nil || d == nil {
+		return fmt.Errorf("nil encoder or decoder")
+	}
+
+	c.gate.RLock()
+	defer c.gate.RUnlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	_, dirPerm := c.filePerms()
+	tmp, old := c.convertPaths()
+	if err := c.Backend.RemoveAll(tmp); err != nil {
+		return fmt.Errorf("removing conversion directory: %w", err)
+	}
+	if err := c.Backend.MkdirAll(tmp, dirPerm); err != nil {
+		return fmt.Errorf("creating conversion directory: %w", err)
+	}
+	defer c.Backend.RemoveAll(tmp)
+
+	// conv writes with the new encoding into the conversion directory.
+	conv := Collection{
+		initialized: true,
+		Encoder:     e,
+		Decoder:     d,
+		Compression: c.Compression,
+		Checksums:   c.Checksums,
+		Backend:     c.Backend,
+
+		SchemaVersion: c.SchemaVersion,
+		FilePerm:      c.FilePerm,
+		DirPerm:       c.DirPerm,
+	}
+
+	ids, err := c.ids()
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		rec := reflect.New(c.record).Interface()
+		meta, err := c.readMeta(c.filepath(id, false), rec)
+		if err != nil {
+			return fmt.Errorf("loading record: %w", err)
+		}
+
+		b, err := conv.encodeRecord(rec, meta)
+		if err != nil {
+			return fmt.Errorf("encoding record %q: %w", id, err)
+		}
+		rel, err := filepath.Rel(c.fullpath(), c.filepath(id, false))
+		if err != nil {
+			return err
+		}
+		if err := c.Backend.MkdirAll(filepath.Dir(filepath.Join(tmp, rel)), dirPerm); err != nil {
+			return fmt.Errorf("saving record %q: %w", id, err)
+		}
+		if err := conv.save(filepath.Join(tmp, rel), b); err != nil {
+			return fmt.Errorf("saving record %q: %w", id, err)
+		}
+	}
+
+	b, err := conv.encode(c.Indexing)
+	if err != nil {
+		return fmt.Errorf("encoding indexes: %w", err)
+	}
+	if err := conv.save(filepath.Join(tmp, filepath.Base(c.filepath(c.Name, true))), b); err != nil {
+		return fmt.Errorf("saving index: %w", err)
+	}
+	for _, meta := range metaFiles {
+		b, err := c.load(filepath.Join(c.fullpath(), meta))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("loading %s: %w", meta, err)
+		}
+		if err := conv.save(filepath.Join(tmp, meta), b); err != nil {
+			return fmt.Errorf("saving %s: %w", meta, err)
+		}
+	}
+
+	// Mark the conversion as complete, so an interrupted swap is completed
+	// by recoverConversion.
+	name := codecName(e, d)
+	if err := conv.save(filepath.Join(tmp, convertFile), []byte(name)); err != nil {
+		return fmt.Errorf("saving %s: %w", convertFile, err)
+	}
+
+	// Swap the directories.
+	if err := c.Backend.RemoveAll(old); err != nil {
+		return fmt.Errorf("replacing collection: %w", err)
+	}
+	if err := c.Backend.Rename(c.fullpath(), old); err != nil {
+		return fmt.Errorf("replacing collection: %w", err)
+	}
+	if err := c.Backend.Rename(tmp, c.fullpath()); err != nil {
+		c.Backend.Rename(old, c.fullpath())
+
+		// The collection on disk is converted if it's marked, even though
+		// the rename reported an error.
+		if _, serr := c.Backend.Stat(filepath.Join(c.fullpath(), convertFile)); serr != nil {
+			return fmt.Errorf("replacing collection: %w", err)
+		}
+	}
+
+	c.Encoder, c.Decoder = e, d
+	c.encoding = name
+	c.indexSum = c.sumIndex()
+
+	return c.completeConversion(name)
+}
+
+// convertPaths returns the directory a collection is converted into and the
+// directory the collection is moved to while the directories are swapped.
+func (c *Collection) convertPaths() (string, string) {
+	tmp := filepath.Join(c.Path, "."+c.Name+"-convert")
+	return tmp, tmp + ".old"
+}
+
+// completeConversion records the codec name of the encoding of a converted
+// collection in the store manifest, empty if the codec has no name, and
+// removes the directory of the original collection.
+func (c *Collection) completeConversion(name string) error {
+	if c.store != nil {
+		if err := c.store.updateManifest(func(m *storeManifest) error {
+			if cm, ok := m.Collections[c.Name]; ok {
+				cm.Encoding = name
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Backend.Remove(filepath.Join(c.fullpath(), convertFile)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("removing %s: %w", convertFile, err)
+	}
+	_, old := c.convertPaths()
+	return c.Backend.RemoveAll(old)
+}
+
+// recoverConversion completes a conversion of the encoding of the Collection
+// that was interrupted while the directories were swapped, or removes the
+// leftovers of a conversion that was interrupted before.
+//
+// It holds the lock of the collection, so a conversion that is still running
+// isn't mistaken for an interrupted one.
+func (c *Collection) recoverConversion() error {
+	if c.readOnly {
+		return nil
+	}
+	if _, err := c.Backend.Stat(c.Path); err != nil {
+		// A new store has nothing to recover.
+		return nil
+	}
+
+	unlock, err := c.Backend.Lock(c.lockpath())
+	if err != nil {
+		return fmt.Errorf("locking collection: %w", err)
+	}
+	defer unlock()
+
+	tmp, old := c.convertPaths()
+	if _, err := c.Backend.Stat(c.fullpath()); errors.Is(err, fs.ErrNotExist) {
+		// Interrupted between the renames, the converted collection is
+		// complete if it has been marked.
+		src := old
+		if _, err := c.Backend.Stat(filepath.Join(tmp, convertFile)); err == nil {
+			src = tmp
+		}
+		if _, err := c.Backend.Stat(src); err != nil {
+			return nil
+		}
+		if err := c.Backend.Rename(src, c.fullpath()); err != nil {
+			return fmt.Errorf("recovering conversion: %w", err)
+		}
+	}
+
+	b, err := c.Backend.ReadFile(filepath.Join(c.fullpath(), convertFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		// The conversion failed or was interrupted before the directories
+		// were swapped.
+		if err := c.Backend.RemoveAll(tmp); err != nil {
+			return err
+		}
+		return c.Backend.RemoveAll(old)
+	}
+	if err != nil {
+		return fmt.Errorf("recovering conversion: %w", err)
+	}
+
+	if err := c.Backend.RemoveAll(tmp); err != nil {
+		return err
+	}
+	return c.completeConversion(string(b))
+}
+
+// reloadEncoding switches the Collection to the encoding recorded in the store
+// manifest if another handle converted the collection. Only encodings with a
+// registered codec name can be followed.
+func (c *Collection) reloadEncoding() error {
+	if c.store == nil || c.encoding == "" {
+		return nil
+	}
+
+	m, err := c.store.loadManifest()
+	if err != nil {
+		return err
+	}
+	cm, ok := m.Collections[c.Name]
+	if !ok || cm.Encoding == "" || cm.Encoding == c.encoding {
+		return nil
+	}
+
+	rc, ok := lookupCodec(cm.Encoding)
+	if !ok {
+		return fmt.Errorf("%w: collection %q is %s encoded", ErrUnknownCodec, c.Name, cm.Encoding)
+	}
+	withEncoding(rc.encoder, rc.decoder)(c)
+	withEncodingName(cm.Encoding)(c)
+
+	return nil
+}
+
+// ids returns the ids of all records in the Collection in lexical order.
+func (c *Collection) ids() ([]string, error) {
+	var ids []string
+	if err := walkDir(c.Backend, c.fullpath(), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".sds") {
+			return nil
+		}
+
+		ids = append(ids, c.idFromPath(path))
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("listing records: %w", err)
+	}
+
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// csvFields returns the exported top-level fields of the struct type t.
+func csvFields(t reflect.Type) []reflect.StructField {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	var fields []reflect.StructField
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); f.IsExported() {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// formatCSVValue returns the CSV representation of v.
+func formatCSVValue(v reflect.Value) (string, error) {
+	// Nil pointers and interfaces are empty, pointers are formatted like
+	// the value they point to.
+	if (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) && v.IsNil() {
+		return "", nil
+	}
+	if v.Kind() == reflect.Pointer {
+		return formatCSVValue(v.Elem())
+	}
+
+	if m, ok := v.Interface().(encoding.TextMarshaler); ok {
+		b, err := m.MarshalText()
+		return string(b), err
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits()), nil
+	}
+
+	b, err := json.Marshal(v.Interface())
+	return string(b), err
+}
+
+// parseCSVValue sets v to the value represented by s.
+func parseCSVValue(s string, v reflect.Value) error {
+	// Empty values of pointers are nil.
+	if v.Kind() == reflect.Pointer {
+		if s == "" {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		p := reflect.New(v.Type().Elem())
+		if err := parseCSVValue(s, p.Elem()); err != nil {
+			return err
+		}
+		v.Set(p)
+		return nil
+	}
+
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(s))
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+		return nil
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		v.SetBool(b)
+		return err
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		v.SetInt(i)
+		return err
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		v.SetUint(u)
+		return err
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		v.SetFloat(f)
+		return err
+	}
+
+	if s == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(s), v.Addr().Interface())
+}
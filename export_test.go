@@ -0,0 +1,305 @@
+package sdstore_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/toqns/sdstore"
+)
+
+func TestExportImport(t *testing.T) {
+	path := t.TempDir()
+	src, err := sdstore.New("src", path, sdstore.WithJSONEncoding())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+	}
+	dst, err := sdstore.New("dst", path, sdstore.WithCborEncoding())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+	}
+	t.Logf("%s\tShould be able to create new stores.", success)
+
+	c, err := src.Collection("test", Record{})
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+	}
+
+	recs := []Record{
+		{ID: "1", Name: "One, \"the first\"", Email: "one@example.com"},
+		{ID: "2", Name: "Two", Email: "two@example.com"},
+	}
+	for _, rec := range recs {
+		if err := c.Create(rec.ID, rec); err != nil {
+			t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+		}
+	}
+
+	for _, format := range []sdstore.Format{sdstore.FormatJSONL, sdstore.FormatCSV} {
+		var buf bytes.Buffer
+		if err := c.Export(&buf, format); err != nil {
+			t.Fatalf("%s\tShould be able to export format %d: %v.", failed, format, err)
+		}
+		t.Logf("%s\tShould be able to export format %d.", success, format)
+
+		dc, err := dst.Collection("test", Record{})
+		if err != nil {
+			t.Fatalf("%s\tShould be able to open the destination collection: %v.", failed, err)
+		}
+
+		exported := buf.Bytes()
+		n, err := dc.Import(bytes.NewReader(exported), format, sdstore.ImportOverwrite)
+		if err != nil {
+			t.Fatalf("%s\tShould be able to import format %d: %v.", failed, format, err)
+		}
+		if n != len(recs) {
+			t.Fatalf("%s\tShould import %d records, got: %d.", failed, len(recs), n)
+		}
+		t.Logf("%s\tShould be able to import format %d.", success, format)
+
+		for _, exp := range recs {
+			var got Record
+			if err := dc.Get(exp.ID, &got); err != nil {
+				t.Fatalf("%s\tShould be able to get imported record %q: %v.", failed, exp.ID, err)
+			}
+			if diff := cmp.Diff(got, exp); diff != "" {
+				t.Fatalf("%s\tShould get expected result: %v.", failed, diff)
+			}
+		}
+		t.Logf("%s\tShould get the imported records.", success)
+
+		if n, err := dc.Import(bytes.NewReader(exported), format, sdstore.ImportSkip); err != nil || n != 0 {
+			t.Fatalf("%s\tShould skip existing records: %d, %v.", failed, n, err)
+		}
+		t.Logf("%s\tShould skip existing records.", success)
+
+		if _, err := dc.Import(bytes.NewReader(exported), format, sdstore.ImportFail); !errors.Is(err, sdstore.ErrNotIDNotUnique) {
+			t.Fatalf("%s\tShould fail on existing records: %v.", failed, err)
+		}
+		t.Logf("%s\tShould fail on existing records.", success)
+	}
+}
+
+func TestExportImportCSVPointers(t *testing.T) {
+	type Event struct {
+		ID    string
+		At    *time.Time
+		Count *int
+	}
+
+	store, err := sdstore.New("defaults", t.TempDir())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+	}
+	src, err := store.Collection("src", Event{})
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+	}
+	dst, err := store.Collection("dst", Event{})
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+	}
+
+	at, count := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), 3
+	recs := []Event{{ID: "1"}, {ID: "2", At: &at, Count: &count}}
+	for _, rec := range recs {
+		if err := src.Create(rec.ID, rec); err != nil {
+			t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := src.Export(&buf, sdstore.FormatCSV); err != nil {
+		t.Fatalf("%s\tShould be able to export nil pointers to CSV: %v.", failed, err)
+	}
+	t.Logf("%s\tShould be able to export nil pointers to CSV.", success)
+
+	if _, err := dst.Import(&buf, sdstore.FormatCSV, sdstore.ImportFail); err != nil {
+		t.Fatalf("%s\tShould be able to import pointers from CSV: %v.", failed, err)
+	}
+	for _, exp := range recs {
+		var got Event
+		if err := dst.Get(exp.ID, &got); err != nil {
+			t.Fatalf("%s\tShould be able to get imported record %q: %v.", failed, exp.ID, err)
+		}
+		if diff := cmp.Diff(got, exp); diff != "" {
+			t.Fatalf("%s\tShould get expected result: %v.", failed, diff)
+		}
+	}
+	t.Logf("%s\tShould be able to import pointers from CSV.", success)
+}
+
+func TestConvertEncoding(t *testing.T) {
+	path := t.TempDir()
+	store, err := sdstore.New("convert", path, sdstore.WithJSONEncoding())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+	}
+
+	c, err := store.Collection("test", Record{}, sdstore.WithIndexedFields("Email"))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+	}
+
+	rec := Record{ID: "1", Name: "One", Email: "one@example.com"}
+	if err := c.Create(rec.ID, rec); err != nil {
+		t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+	}
+
+	e := sdstore.NewCborEncoder()
+	if err := c.ConvertEncoding(e, e); err != nil {
+		t.Fatalf("%s\tShould be able to convert the collection to CBOR: %v.", failed, err)
+	}
+	t.Logf("%s\tShould be able to convert the collection to CBOR.", success)
+
+	reopened, err := sdstore.New("convert", path, sdstore.WithCborEncoding())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to reopen the store: %v.", failed, err)
+	}
+	rc, err := reopened.Collection("test", Record{}, sdstore.WithIndexedFields("Email"))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to reopen the collection with CBOR: %v.", failed, err)
+	}
+
+	var got Record
+	if err := rc.GetIndexed("Email", rec.Email, &got); err != nil {
+		t.Fatalf("%s\tShould be able to get the converted record: %v.", failed, err)
+	}
+	if diff := cmp.Diff(got, rec); diff != "" {
+		t.Fatalf("%s\tShould get expected result: %v.", failed, diff)
+	}
+	t.Logf("%s\tShould be able to get the converted record.", success)
+}
+
+func TestConvertEncodingSharedCollection(t *testing.T) {
+	// Two stores on the same path stand in for two processes.
+	path := t.TempDir()
+	var cs []*sdstore.Collection
+	for i := 0; i < 2; i++ {
+		store, err := sdstore.New("convert", path, sdstore.WithJSONEncoding())
+		if err != nil {
+			t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+		}
+		c, err := store.Collection("test", Record{}, sdstore.WithIndexedFields("Email"))
+		if err != nil {
+			t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+		}
+		cs = append(cs, c)
+	}
+
+	one := Record{ID: "1", Name: "One", Email: "one@example.com"}
+	if err := cs[0].Create(one.ID, one); err != nil {
+		t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+	}
+	e := sdstore.NewCborEncoder()
+	if err := cs[0].ConvertEncoding(e, e); err != nil {
+		t.Fatalf("%s\tShould be able to convert the collection to CBOR: %v.", failed, err)
+	}
+
+	two := Record{ID: "2", Name: "Two", Email: "two@example.com"}
+	if err := cs[1].Create(two.ID, two); err != nil {
+		t.Fatalf("%s\tShould be able to create a record with another handle: %v.", failed, err)
+	}
+
+	reopened, err := sdstore.New("convert", path, sdstore.WithCborEncoding())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to reopen the store: %v.", failed, err)
+	}
+	rc, err := reopened.Collection("test", Record{}, sdstore.WithIndexedFields("Email"))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to reopen the collection with CBOR: %v.", failed, err)
+	}
+	for _, rec := range []Record{one, two} {
+		var got Record
+		if err := rc.GetIndexed("Email", rec.Email, &got); err != nil {
+			t.Fatalf("%s\tShould be able to get record %s: %v.", failed, rec.ID, err)
+		}
+		if diff := cmp.Diff(got, rec); diff != "" {
+			t.Fatalf("%s\tShould get expected result: %v.", failed, diff)
+		}
+	}
+	t.Logf("%s\tShould write with the new encoding from other handles after a conversion.", success)
+}
+
+func TestConvertEncodingRecovery(t *testing.T) {
+	// create returns the directory of a new collection holding rec with the
+	// encoding of opt.
+	rec := Record{ID: "1", Name: "One", Email: "one@example.com"}
+	create := func(path string, opt sdstore.StoreOption) string {
+		t.Helper()
+		store, err := sdstore.New("convert", path, opt)
+		if err != nil {
+			t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+		}
+		c, err := store.Collection("test", Record{})
+		if err != nil {
+			t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+		}
+		if err := c.Create(rec.ID, rec); err != nil {
+			t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+		}
+		return filepath.Join(path, "convert", "test")
+	}
+
+	// reopen reopens the collection and returns its recorded encoding.
+	reopen := func(path string) string {
+		t.Helper()
+		store, err := sdstore.New("convert", path, sdstore.WithJSONEncoding())
+		if err != nil {
+			t.Fatalf("%s\tShould be able to reopen the store: %v.", failed, err)
+		}
+		c, err := store.Collection("test", Record{})
+		if err != nil {
+			t.Fatalf("%s\tShould be able to reopen the collection: %v.", failed, err)
+		}
+		var got Record
+		if err := c.Get(rec.ID, &got); err != nil || got != rec {
+			t.Fatalf("%s\tShould be able to get the record: got %+v, %v.", failed, got, err)
+		}
+		info, err := store.Describe("test")
+		if err != nil {
+			t.Fatalf("%s\tShould be able to describe the collection: %v.", failed, err)
+		}
+		for _, leftover := range []string{".test-convert", ".test-convert.old"} {
+			if _, err := os.Stat(filepath.Join(path, "convert", leftover)); !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("%s\tShould remove %s: %v.", failed, leftover, err)
+			}
+		}
+		return info.Encoding
+	}
+
+	// Interrupted after moving the collection away, before the converted
+	// collection was complete.
+	path := t.TempDir()
+	dir := create(path, sdstore.WithJSONEncoding())
+	if err := os.Rename(dir, filepath.Join(path, "convert", ".test-convert.old")); err != nil {
+		t.Fatalf("%s\tShould be able to move the collection: %v.", failed, err)
+	}
+	if enc := reopen(path); enc != "json" {
+		t.Fatalf("%s\tShould keep the original encoding: got %q.", failed, enc)
+	}
+	t.Logf("%s\tShould restore the original collection of an interrupted conversion.", success)
+
+	// Interrupted after moving the collection away, with a complete converted
+	// collection.
+	path = t.TempDir()
+	dir = create(path, sdstore.WithJSONEncoding())
+	converted := create(t.TempDir(), sdstore.WithMsgpackEncoding())
+	if err := os.WriteFile(filepath.Join(converted, ".convert"), []byte("msgpack"), 0o600); err != nil {
+		t.Fatalf("%s\tShould be able to mark the conversion: %v.", failed, err)
+	}
+	if err := os.Rename(dir, filepath.Join(path, "convert", ".test-convert.old")); err != nil {
+		t.Fatalf("%s\tShould be able to move the collection: %v.", failed, err)
+	}
+	if err := os.Rename(converted, filepath.Join(path, "convert", ".test-convert")); err != nil {
+		t.Fatalf("%s\tShould be able to move the converted collection: %v.", failed, err)
+	}
+	if enc := reopen(path); enc != "msgpack" {
+		t.Fatalf("%s\tShould record the new encoding: got %q.", failed, enc)
+	}
+	t.Logf("%s\tShould complete an interrupted conversion.", success)
+}
@@ -0,0 +1,126 @@
+package sdstore
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+)
+
+// ErrReadOnly is an error returned when a user attempts to modify a read-only store.
+var ErrReadOnly = errors.New("store is read-only")
+
+// FSBackend is a read-only Backend serving files from an fs.FS, such as an
+// embed.FS, a zip.Reader or os.DirFS.
+//
+// All methods modifying data return ErrReadOnly.
+type FSBackend struct {
+	FS fs.FS
+}
+
+// fsName converts name to the slash separated form fs.FS expects.
+func fsName(name string) string {
+	return filepath.ToSlash(filepath.Clean(name))
+}
+
+// ReadFile implements the Backend interface for FSBackend.
+func (b FSBackend) ReadFile(name string) ([]byte, error) {
+	return fs.ReadFile(b.FS, fsName(name))
+}
+
+// WriteFile implements the Backend interface for FSBackend.
+func (b FSBackend) WriteFile(name string, _ []byte, _ fs.FileMode) error {
+	return &fs.PathError{Op: "write", Path: name, Err: ErrReadOnly}
+}
+
+// Remove implements the Backend interface for FSBackend.
+func (b FSBackend) Remove(name string) error {
+	return &fs.PathError{Op: "remove", Path: name, Err: ErrReadOnly}
+}
+
+// RemoveAll implements the Backend interface for FSBackend.
+func (b FSBackend) RemoveAll(name string) error {
+	return &fs.PathError{Op: "remove", Path: name, Err: ErrReadOnly}
+}
+
+// Rename implements the Backend interface for FSBackend.
+func (b FSBackend) Rename(oldname string, _ string) error {
+	return &fs.PathError{Op: "rename", Path: oldname, Err: ErrReadOnly}
+}
+
+// Stat implements the Backend interface for FSBackend.
+func (b FSBackend) Stat(name string) (fs.FileInfo, error) {
+	return fs.Stat(b.FS, fsName(name))
+}
+
+// ReadDir implements the Backend interface for FSBackend.
+func (b FSBackend) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(b.FS, fsName(name))
+}
+
+// MkdirAll implements the Backend interface for FSBackend.
+// It succeeds only if the directory already exists.
+func (b FSBackend) MkdirAll(name string, _ fs.FileMode) error {
+	info, err := b.Stat(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return &fs.PathError{Op: "mkdir", Path: name, Err: ErrReadOnly}
+		}
+		return err
+	}
+	if !info.IsDir() {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: errors.New("not a directory")}
+	}
+	return nil
+}
+
+// Lock implements the Backend interface for FSBackend.
+func (b FSBackend) Lock(name string) (func() error, error) {
+	return nil, &fs.PathError{Op: "lock", Path: name, Err: ErrReadOnly}
+}
+
+// OpenFS returns a read-only store for the store directory name in fsys.
+//
+// The collections of the store support Get, GetIndexed, Query and
+// QueryPaginated. All methods modifying data return ErrReadOnly.
+// Like New, the store uses CBOR encoding unless an encoding option is provided
+// and checks the encodings recorded in the store manifest.
+func OpenFS(fsys fs.FS, name string, opts ...StoreOption) (*SDStore, error) {
+	store := SDStore{
+		Path:     ".",
+		Name:     name,
+		Perms:    defaultDirPerm,
+		readOnly: true,
+	}
+
+	WithCborEncoding()(&store)
+	for _, opt := range opts {
+		opt(&store)
+	}
+	store.Backend = FSBackend{FS: fsys}
+
+	if store.Encoder == nil || store.Decoder == nil {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownCodec, store.encoding)
+	}
+
+	info, err := store.Backend.Stat(name)
+	if err != nil {
+		return nil, fmt.Errorf("opening store: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("opening store: %s is not a directory", name)
+	}
+
+	if err := store.initManifest(); err != nil {
+		return nil, err
+	}
+
+	return &store, nil
+}
+
+// withReadOnly is an option to make a Collection read-only.
+func withReadOnly(readOnly bool) CollectionOption {
+	return func(c *Collection) {
+		c.readOnly = readOnly
+	}
+}
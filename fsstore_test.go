@@ -0,0 +1,118 @@
+package sdstore_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/toqns/sdstore"
+)
+
+func TestOpenFS(t *testing.T) {
+	path := t.TempDir()
+	store, err := sdstore.New("seed", path, sdstore.WithJSONEncoding())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+	}
+
+	c, err := store.Collection("test", Record{}, sdstore.WithIndexedFields("Email"))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+	}
+
+	recs := []Record{
+		{ID: "1", Name: "One", Email: "one@example.com"},
+		{ID: "2", Name: "Two", Email: "two@example.com"},
+	}
+	for _, rec := range recs {
+		if err := c.Create(rec.ID, rec); err != nil {
+			t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+		}
+	}
+
+	// Zip the store to verify it can be read from a zip.Reader.
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	if err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(path, p)
+		w, err := zw.Create(filepath.ToSlash(rel))
+		if err != nil {
+			return err
+		}
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(b)
+		return err
+	}); err != nil {
+		t.Fatalf("%s\tShould be able to zip the store: %v.", failed, err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("%s\tShould be able to zip the store: %v.", failed, err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to read the zip: %v.", failed, err)
+	}
+
+	tt := []struct {
+		Name string
+		FS   fs.FS
+	}{
+		{"dirfs", os.DirFS(path)},
+		{"zip", zr},
+	}
+
+	for _, tc := range tt {
+		ro, err := sdstore.OpenFS(tc.FS, "seed", sdstore.WithJSONEncoding())
+		if err != nil {
+			t.Fatalf("%s\tShould be able to open the store from %s: %v.", failed, tc.Name, err)
+		}
+		t.Logf("%s\tShould be able to open the store from %s.", success, tc.Name)
+
+		rc, err := ro.Collection("test", Record{}, sdstore.WithIndexedFields("Email"))
+		if err != nil {
+			t.Fatalf("%s\tShould be able to open a read-only collection: %v.", failed, err)
+		}
+
+		var got Record
+		if err := rc.Get("1", &got); err != nil {
+			t.Fatalf("%s\tShould be able to get a record: %v.", failed, err)
+		}
+		if diff := cmp.Diff(got, recs[0]); diff != "" {
+			t.Fatalf("%s\tShould get expected result: %v.", failed, diff)
+		}
+		t.Logf("%s\tShould be able to get a record.", success)
+
+		if err := rc.GetIndexed("Email", "two@example.com", &got); err != nil || got.ID != "2" {
+			t.Fatalf("%s\tShould be able to get an indexed record: %v.", failed, err)
+		}
+		t.Logf("%s\tShould be able to get an indexed record.", success)
+
+		res, pages, err := rc.QueryPaginated(func(any) bool { return true }, 2, 1)
+		if err != nil || pages != 2 || len(res) != 1 {
+			t.Fatalf("%s\tShould be able to query a page: %d pages, %d records, %v.", failed, pages, len(res), err)
+		}
+		t.Logf("%s\tShould be able to query a page.", success)
+
+		if err := rc.Create("3", Record{ID: "3"}); !errors.Is(err, sdstore.ErrReadOnly) {
+			t.Fatalf("%s\tShould get ErrReadOnly when creating a record: %v.", failed, err)
+		}
+		if err := rc.Update("1", recs[0]); !errors.Is(err, sdstore.ErrReadOnly) {
+			t.Fatalf("%s\tShould get ErrReadOnly when updating a record: %v.", failed, err)
+		}
+		if err := rc.Delete("1"); !errors.Is(err, sdstore.ErrReadOnly) {
+			t.Fatalf("%s\tShould get ErrReadOnly when deleting a record: %v.", failed, err)
+		}
+		t.Logf("%s\tShould get ErrReadOnly when modifying records.", success)
+	}
+}
@@ -0,0 +1,329 @@
+package sdstore
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// ErrNoFullTextIndex is an error returned when a user attempts to search a
+// collection without a full-text index.
+var ErrNoFullTextIndex = errors.New("collection has no full-text index")
+
+// BM25 parameters.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// FullTextIndex is the inverted index of the full-text fields of a Collection.
+type FullTextIndex struct {
+	Fields []string
+
+	// Stemmer identifies the stemmer the terms were stemmed with, empty if
+	// they aren't stemmed.
+	Stemmer string
+
+	// Terms holds the frequency of a term in the records by term and id.
+	Terms map[string]map[string]int
+
+	// Lengths holds the number of terms of the records by id.
+	Lengths map[string]int
+}
+
+// WithFullTextIndex is an option to maintain a full-text index over the provided
+// string fields, which can be searched with Search. Fields of type string,
+// *string and []string are indexed.
+func WithFullTextIndex(fields ...string) CollectionOption {
+	return func(c *Collection) {
+		c.fullText.fields = fields
+	}
+}
+
+// WithStemmer is an option to reduce the terms of the full-text index to their
+// stem with stemmer, such as EnglishStemmer. Terms are lowercased and stripped
+// of diacritics before they are stemmed.
+//
+// The stemmer is recorded in the index by the name of its function, and the
+// index is rebuilt when the collection is opened with another stemmer.
+func WithStemmer(stemmer func(term string) string) CollectionOption {
+	return func(c *Collection) {
+		c.fullText.stemmer = stemmer
+	}
+}
+
+// EnglishStemmer is a light stemmer for English, which strips common plural,
+// past tense and gerund suffixes.
+func EnglishStemmer(term string) string {
+	switch {
+	case len(term) > 4 && strings.HasSuffix(term, "ies"):
+		return term[:len(term)-3] + "y"
+	case len(term) > 5 && strings.HasSuffix(term, "ing"):
+		return undouble(term[:len(term)-3])
+	case len(term) > 4 && strings.HasSuffix(term, "ed"):
+		return undouble(term[:len(term)-2])
+	case len(term) > 4 && (strings.HasSuffix(term, "sses") || strings.HasSuffix(term, "xes") ||
+		strings.HasSuffix(term, "ches") || strings.HasSuffix(term, "shes")):
+		return term[:len(term)-2]
+	case len(term) > 3 && strings.HasSuffix(term, "s") && !strings.HasSuffix(term, "ss") &&
+		!strings.HasSuffix(term, "us") && !strings.HasSuffix(term, "is"):
+		return term[:len(term)-1]
+	}
+	return term
+}
+
+// undouble removes a doubled final consonant from a stem, such as in "stopp".
+func undouble(stem string) string {
+	n := len(stem)
+	if n > 2 && stem[n-1] == stem[n-2] && !strings.ContainsRune("aeioulsz", rune(stem[n-1])) {
+		return stem[:n-1]
+	}
+	return stem
+}
+
+// diacritics maps letters with diacritics to their base letter.
+var diacritics = map[rune]rune{}
+
+func init() {
+	for base, letters := range map[rune]string{
+		'a': "àáâãäåāăą", 'c': "çćĉċč", 'd': "ďđ", 'e': "èéêëēĕėęě",
+		'g': "ĝğġģ", 'h': "ĥħ", 'i': "ìíîïĩīĭįı", 'j': "ĵ", 'k': "ķ",
+		'l': "ĺļľŀł", 'n': "ñńņňŉ", 'o': "òóôõöøōŏő", 'r': "ŕŗř",
+		's': "śŝşš", 't': "ţťŧ", 'u': "ùúûüũūŭůűų", 'w': "ŵ", 'y': "ýÿŷ",
+		'z': "źżž",
+	} {
+		for _, r := range letters {
+			diacritics[r] = base
+		}
+	}
+}
+
+// tokenize splits s into lowercase terms without diacritics, stemmed with
+// stemmer if not nil. Combining marks are removed before s is split, so
+// decomposed letters don't split words.
+func tokenize(s string, stemmer func(string) string) []string {
+	s = strings.Map(func(r rune) rune {
+		if unicode.Is(unicode.Mn, r) {
+			return -1
+		}
+		return r
+	}, s)
+
+	var terms []string
+	for _, word := range strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		term := strings.Map(func(r rune) rune {
+			r = unicode.ToLower(r)
+			if base, ok := diacritics[r]; ok {
+				return base
+			}
+			return r
+		}, word)
+
+		if stemmer != nil {
+			term = stemmer(term)
+		}
+		if term != "" {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// textValues returns the strings of field of rec.
+func textValues(rec any, field string) []string {
+	v := reflect.ValueOf(getFieldValue(rec, field))
+	switch {
+	case v.Kind() == reflect.String:
+		return []string{v.String()}
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		vals := make([]string, v.Len())
+		for i := range vals {
+			vals[i] = v.Index(i).String()
+		}
+		return vals
+	}
+	return nil
+}
+
+// fullTextIndexed returns true if the full-text index matches the settings of c.
+func (c *Collection) fullTextIndexed() bool {
+	idx := c.Indexing.FullText
+	if len(c.fullText.fields) == 0 {
+		return len(idx.Fields) == 0
+	}
+	return cmp.Equal(idx.Fields, c.fullText.fields) && idx.Stemmer == stemmerName(c.fullText.stemmer)
+}
+
+// stemmerName returns the name of the function stemmer, which identifies it in
+// the index, or empty if stemmer is nil.
+func stemmerName(stemmer func(string) string) string {
+	if stemmer == nil {
+		return ""
+	}
+	return runtime.FuncForPC(reflect.ValueOf(stemmer).Pointer()).Name()
+}
+
+// resetFullText clears the full-text index before it is rebuilt.
+func (c *Collection) resetFullText() {
+	c.Indexing.FullText = FullTextIndex{}
+	if len(c.fullText.fields) == 0 {
+		return
+	}
+
+	c.Indexing.FullText = FullTextIndex{
+		Fields:  c.fullText.fields,
+		Stemmer: stemmerName(c.fullText.stemmer),
+		Terms:   make(map[string]map[string]int),
+		Lengths: make(map[string]int),
+	}
+}
+
+// indexText replaces the terms of the old record id in the full-text index by
+// those of rec. old or rec is nil if the record is created or deleted.
+func (c *Collection) indexText(id string, old any, rec any) {
+	if len(c.fullText.fields) == 0 {
+		return
+	}
+
+	idx := &c.Indexing.FullText
+	if old != nil {
+		for _, term := range c.terms(old) {
+			delete(idx.Terms[term], id)
+			if len(idx.Terms[term]) == 0 {
+				delete(idx.Terms, term)
+			}
+		}
+		delete(idx.Lengths, id)
+	}
+
+	if rec == nil {
+		return
+	}
+	if idx.Terms == nil {
+		idx.Terms = make(map[string]map[string]int)
+	}
+	if idx.Lengths == nil {
+		idx.Lengths = make(map[string]int)
+	}
+
+	terms := c.terms(rec)
+	for _, term := range terms {
+		if idx.Terms[term] == nil {
+			idx.Terms[term] = make(map[string]int)
+		}
+		idx.Terms[term][id]++
+	}
+	idx.Lengths[id] = len(terms)
+}
+
+// terms returns the terms of the full-text fields of rec.
+func (c *Collection) terms(rec any) []string {
+	var terms []string
+	for _, field := range c.fullText.fields {
+		for _, s := range textValues(rec, field) {
+			terms = append(terms, tokenize(s, c.fullText.stemmer)...)
+		}
+	}
+	return terms
+}
+
+// SearchResult is a result of Search.
+type SearchResult struct {
+	ID     string
+	Score  float64
+	Record any
+}
+
+// Search returns the records matching any of the terms of query in the full-text
+// index, ranked by relevance with BM25. At most limit results are returned, all
+// of them if limit is 0.
+func (c *Collection) Search(query string, limit int) ([]SearchResult, error) {
+	if !c.initialized {
+		return nil, ErrNotInitialized
+	}
+	if len(c.fullText.fields) == 0 {
+		return nil, ErrNoFullTextIndex
+	}
+
+	c.mu.RLock()
+	res := c.rank(tokenize(query, c.fullText.stemmer))
+	c.mu.RUnlock()
+
+	if limit > 0 && len(res) > limit {
+		res = res[:limit]
+	}
+
+	// Load the records, which may have been deleted in the meantime.
+	ids := make(map[string]bool, len(res))
+	for _, r := range res {
+		ids[r.ID] = true
+	}
+	recs, err := c.getMany(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	found := res[:0]
+	for _, r := range res {
+		if rec, ok := recs[r.ID]; ok {
+			r.Record = rec
+			found = append(found, r)
+		}
+	}
+
+	return found, nil
+}
+
+// rank returns the ids of the records matching terms with their BM25 score,
+// ordered by descending score. The Collection must be locked for reading.
+func (c *Collection) rank(terms []string) []SearchResult {
+	idx := c.Indexing.FullText
+	n := float64(len(idx.Lengths))
+	if n == 0 {
+		return nil
+	}
+
+	var total int
+	for _, l := range idx.Lengths {
+		total += l
+	}
+	avg := float64(total) / n
+
+	scores := make(map[string]float64)
+	seen := make(map[string]bool)
+	for _, term := range terms {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+
+		docs := idx.Terms[term]
+		idf := math.Log((n-float64(len(docs))+0.5)/(float64(len(docs))+0.5) + 1)
+		for id, tf := range docs {
+			f := float64(tf)
+			norm := 1 - bm25B + bm25B*float64(idx.Lengths[id])/avg
+			scores[id] += idf * f * (bm25K1 + 1) / (f + bm25K1*norm)
+		}
+	}
+
+	res := make([]SearchResult, 0, len(scores))
+	for id, score := range scores {
+		res = append(res, SearchResult{ID: id, Score: score})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Score != res[j].Score {
+			return res[i].Score > res[j].Score
+		}
+		return res[i].ID < res[j].ID
+	})
+
+	return res
+}
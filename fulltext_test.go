@@ -0,0 +1,130 @@
+package sdstore_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/toqns/sdstore"
+)
+
+// Note is a record with text to search.
+type Note struct {
+	ID    string
+	Title string
+	Body  string
+	Tags  []string
+}
+
+func TestFullTextSearch(t *testing.T) {
+	path := t.TempDir()
+	store, err := sdstore.New("defaults", path)
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+	}
+
+	c, err := store.Collection("notes", Note{})
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+	}
+	if _, err := c.Search("anything", 0); !errors.Is(err, sdstore.ErrNoFullTextIndex) {
+		t.Fatalf("%s\tShould not be able to search without a full-text index: %v.", failed, err)
+	}
+	t.Logf("%s\tShould not be able to search without a full-text index.", success)
+
+	for _, rec := range []Note{
+		{ID: "1", Title: "Café opening", Body: "The new café opens on Monday."},
+		{ID: "2", Title: "Shopping list", Body: "Coffee, milk and bread.", Tags: []string{"groceries"}},
+		{ID: "3", Title: "Coffee", Body: "Coffee beans, coffee filters and a coffee grinder."},
+	} {
+		if err := c.Create(rec.ID, rec); err != nil {
+			t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+		}
+	}
+
+	// Open with a full-text index to build it from the existing records.
+	opts := []sdstore.CollectionOption{sdstore.WithFullTextIndex("Title", "Body", "Tags"), sdstore.WithStemmer(sdstore.EnglishStemmer)}
+	c, err = store.Collection("notes", Note{}, opts...)
+	if err != nil {
+		t.Fatalf("%s\tShould be able to open a collection with a full-text index: %v.", failed, err)
+	}
+
+	search := func(query string, limit int) []string {
+		t.Helper()
+		res, err := c.Search(query, limit)
+		if err != nil {
+			t.Fatalf("%s\tShould be able to search: %v.", failed, err)
+		}
+		ids := []string{}
+		for _, r := range res {
+			if r.Record.(*Note).ID != r.ID {
+				t.Fatalf("%s\tShould return the records of the results: got %+v.", failed, r)
+			}
+			ids = append(ids, r.ID)
+		}
+		return ids
+	}
+
+	for _, tt := range []struct {
+		query string
+		limit int
+		want  []string
+	}{
+		{"COFFEE", 0, []string{"3", "2"}},
+		{"coffee", 1, []string{"3"}},
+		{"cafe", 0, []string{"1"}},
+		{"opening", 0, []string{"1"}},
+		{"grocery", 0, []string{"2"}},
+		{"tea", 0, []string{}},
+	} {
+		if diff := cmp.Diff(search(tt.query, tt.limit), tt.want); diff != "" {
+			t.Fatalf("%s\tShould rank the records matching %q:\n%s", failed, tt.query, diff)
+		}
+	}
+	t.Logf("%s\tShould rank the matching records.", success)
+
+	if err := c.Update("2", Note{ID: "2", Title: "Shopping list", Body: "Tea and bread."}); err != nil {
+		t.Fatalf("%s\tShould be able to update a record: %v.", failed, err)
+	}
+	if err := c.Delete("3"); err != nil {
+		t.Fatalf("%s\tShould be able to delete a record: %v.", failed, err)
+	}
+	if diff := cmp.Diff(search("coffee tea", 0), []string{"2"}); diff != "" {
+		t.Fatalf("%s\tShould update the full-text index:\n%s", failed, diff)
+	}
+	t.Logf("%s\tShould update the full-text index.", success)
+
+	// Reopen to verify that the full-text index is stored.
+	c, err = store.Collection("notes", Note{}, opts...)
+	if err != nil {
+		t.Fatalf("%s\tShould be able to reopen the collection: %v.", failed, err)
+	}
+	if diff := cmp.Diff(search("bread", 0), []string{"2"}); diff != "" {
+		t.Fatalf("%s\tShould store the full-text index:\n%s", failed, diff)
+	}
+	t.Logf("%s\tShould store the full-text index.", success)
+
+	if err := c.Create("4", Note{ID: "4", Title: "Nai\u0308ve art"}); err != nil {
+		t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+	}
+	if diff := cmp.Diff(search("naïve", 0), []string{"4"}); diff != "" {
+		t.Fatalf("%s\tShould find words with combining marks:\n%s", failed, diff)
+	}
+	t.Logf("%s\tShould find words with combining marks.", success)
+
+	// Reopen with another stemmer, which rebuilds the index.
+	prefix := func(term string) string {
+		if len(term) > 3 {
+			return term[:3]
+		}
+		return term
+	}
+	c, err = store.Collection("notes", Note{}, sdstore.WithFullTextIndex("Title", "Body", "Tags"), sdstore.WithStemmer(prefix))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to reopen the collection with another stemmer: %v.", failed, err)
+	}
+	if diff := cmp.Diff(search("breakfast", 0), []string{"2"}); diff != "" {
+		t.Fatalf("%s\tShould rebuild the full-text index for another stemmer:\n%s", failed, diff)
+	}
+	t.Logf("%s\tShould rebuild the full-text index for another stemmer.", success)
+}
@@ -0,0 +1,119 @@
+package sdstore
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Hook is a function called with the id and data of a record when it is
+// created, updated or deleted.
+//
+// Hooks are called while the collection is locked, so they must not use the
+// collection themselves. data is a pointer to the record, which Before hooks can
+// modify, and an error returned by a Before hook aborts the operation. An error
+// returned by an After hook is returned by the operation, which has completed.
+type Hook func(id string, data any) error
+
+// hooks holds the hooks of a Collection.
+type hooks struct {
+	beforeCreate []Hook
+	beforeUpdate []Hook
+	beforeDelete []Hook
+	afterCreate  []Hook
+	afterUpdate  []Hook
+	afterDelete  []Hook
+}
+
+// Validator is an interface records can implement to be validated before
+// they are created or updated. A non-nil error aborts the operation.
+type Validator interface {
+	Validate() error
+}
+
+// BeforeSaver is an interface records can implement to be modified before
+// they are created or updated, for example to set timestamps.
+type BeforeSaver interface {
+	BeforeSave()
+}
+
+// WithBeforeCreate is an option to add a hook called before a record is created.
+func WithBeforeCreate(h Hook) CollectionOption {
+	return func(c *Collection) {
+		c.hooks.beforeCreate = append(c.hooks.beforeCreate, h)
+	}
+}
+
+// WithBeforeUpdate is an option to add a hook called before a record is updated.
+func WithBeforeUpdate(h Hook) CollectionOption {
+	return func(c *Collection) {
+		c.hooks.beforeUpdate = append(c.hooks.beforeUpdate, h)
+	}
+}
+
+// WithBeforeDelete is an option to add a hook called with the stored record
+// before it is deleted.
+func WithBeforeDelete(h Hook) CollectionOption {
+	return func(c *Collection) {
+		c.hooks.beforeDelete = append(c.hooks.beforeDelete, h)
+	}
+}
+
+// WithAfterCreate is an option to add a hook called after a record is created.
+func WithAfterCreate(h Hook) CollectionOption {
+	return func(c *Collection) {
+		c.hooks.afterCreate = append(c.hooks.afterCreate, h)
+	}
+}
+
+// WithAfterUpdate is an option to add a hook called after a record is updated.
+func WithAfterUpdate(h Hook) CollectionOption {
+	return func(c *Collection) {
+		c.hooks.afterUpdate = append(c.hooks.afterUpdate, h)
+	}
+}
+
+// WithAfterDelete is an option to add a hook called with the deleted record
+// after it is deleted.
+func WithAfterDelete(h Hook) CollectionOption {
+	return func(c *Collection) {
+		c.hooks.afterDelete = append(c.hooks.afterDelete, h)
+	}
+}
+
+// runHooks calls hs in order and returns the first error.
+func runHooks(hs []Hook, id string, data any) error {
+	for _, h := range hs {
+		if err := h(id, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// beforeSave prepares the record data to be saved: Before hooks hs are called
+// first, followed by the BeforeSave and Validate methods of the record.
+//
+// data is returned as a pointer, so the hooks and methods can modify records
+// that were passed by value.
+func (c *Collection) beforeSave(hs []Hook, id string, data any) (any, error) {
+	rec := reflect.ValueOf(data)
+	if rec.Kind() != reflect.Pointer {
+		cp := reflect.New(rec.Type())
+		cp.Elem().Set(rec)
+		data = cp.Interface()
+	}
+
+	if err := runHooks(hs, id, data); err != nil {
+		return nil, err
+	}
+	if bs, ok := data.(BeforeSaver); ok {
+		bs.BeforeSave()
+	}
+	if v, ok := data.(Validator); ok {
+		if err := v.Validate(); err != nil {
+			return nil, fmt.Errorf("validating record %q: %w", id, err)
+		}
+	}
+
+	return data, nil
+}
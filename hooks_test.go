@@ -0,0 +1,102 @@
+package sdstore_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/toqns/sdstore"
+)
+
+// Account is a record that validates and prepares itself before it is saved.
+type Account struct {
+	ID      string
+	Name    string
+	Version int
+}
+
+var errNoName = errors.New("name is required")
+
+func (a *Account) Validate() error {
+	if a.Name == "" {
+		return errNoName
+	}
+	return nil
+}
+
+func (a *Account) BeforeSave() {
+	a.Version++
+}
+
+func TestHooks(t *testing.T) {
+	store, err := sdstore.New("defaults", t.TempDir(), sdstore.WithJSONEncoding())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+	}
+
+	var events []string
+	record := func(event string) sdstore.Hook {
+		return func(id string, data any) error {
+			events = append(events, event+" "+id+" "+data.(*Account).Name)
+			return nil
+		}
+	}
+	errDenied := errors.New("denied")
+
+	c, err := store.Collection("accounts", Account{},
+		sdstore.WithBeforeCreate(func(id string, data any) error {
+			if id == "denied" {
+				return errDenied
+			}
+			return nil
+		}),
+		sdstore.WithBeforeCreate(record("before create")),
+		sdstore.WithAfterCreate(record("after create")),
+		sdstore.WithBeforeUpdate(record("before update")),
+		sdstore.WithAfterUpdate(record("after update")),
+		sdstore.WithBeforeDelete(record("before delete")),
+		sdstore.WithAfterDelete(record("after delete")),
+	)
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+	}
+
+	if err := c.Create("1", Account{ID: "1"}); !errors.Is(err, errNoName) {
+		t.Fatalf("%s\tShould not be able to create an invalid record: %v.", failed, err)
+	}
+	if err := c.Create("denied", Account{ID: "denied", Name: "Denied"}); !errors.Is(err, errDenied) {
+		t.Fatalf("%s\tShould not be able to create a record rejected by a hook: %v.", failed, err)
+	}
+	var got Account
+	if err := c.Get("denied", &got); !errors.Is(err, sdstore.ErrNotFound) {
+		t.Fatalf("%s\tShould not store a rejected record: %v.", failed, err)
+	}
+	t.Logf("%s\tShould not be able to create rejected records.", success)
+
+	if err := c.Create("1", Account{ID: "1", Name: "One"}); err != nil {
+		t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+	}
+	if err := c.Update("1", Account{ID: "1", Name: "Uno", Version: 1}); err != nil {
+		t.Fatalf("%s\tShould be able to update a record: %v.", failed, err)
+	}
+	if err := c.Get("1", &got); err != nil || got.Version != 2 {
+		t.Fatalf("%s\tShould call BeforeSave before saving a record: got %+v, %v.", failed, got, err)
+	}
+	t.Logf("%s\tShould call BeforeSave before saving a record.", success)
+
+	if err := c.Delete("1"); err != nil {
+		t.Fatalf("%s\tShould be able to delete a record: %v.", failed, err)
+	}
+
+	// Hooks are called before the record is validated.
+	want := []string{
+		"before create 1 ",
+		"before create 1 One", "after create 1 One",
+		"before update 1 Uno", "after update 1 Uno",
+		"before delete 1 Uno", "after delete 1 Uno",
+	}
+	if diff := cmp.Diff(events, want); diff != "" {
+		t.Fatalf("%s\tShould call the hooks in order:\n%s", failed, diff)
+	}
+	t.Logf("%s\tShould call the hooks in order.", success)
+}
@@ -0,0 +1,174 @@
+package sdstore
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// ErrInvalidID is an error returned when a record id can't be stored.
+var ErrInvalidID = errors.New("invalid id")
+
+// maxIDLen is the maximum length of an encoded id, which leaves room for
+// extensions and temporary file names within the common 255 byte limit.
+const maxIDLen = 200
+
+// reservedNames are file names that can't be used on Windows, regardless of
+// their case or extension.
+var reservedNames = map[string]bool{
+	"con": true, "prn": true, "aux": true, "nul": true,
+	"com1": true, "com2": true, "com3": true, "com4": true, "com5": true,
+	"com6": true, "com7": true, "com8": true, "com9": true,
+	"lpt1": true, "lpt2": true, "lpt3": true, "lpt4": true, "lpt5": true,
+	"lpt6": true, "lpt7": true, "lpt8": true, "lpt9": true,
+}
+
+// validateID returns ErrInvalidID if id is empty or too long to be stored.
+func validateID(id string) error {
+	if id == "" {
+		return fmt.Errorf("%w: empty id", ErrInvalidID)
+	}
+	if len(encodeID(id)) > maxIDLen {
+		return fmt.Errorf("%w: id %.20q... is too long", ErrInvalidID, id)
+	}
+	return nil
+}
+
+// encodeID returns the file name of the record id.
+//
+// Lower case letters, digits, '-', '_' and '.' are kept and all other bytes are
+// percent-encoded, which keeps ids inside the collection directory and prevents
+// ids that differ only by case from colliding on case-insensitive filesystems.
+// A leading or trailing '.' and names reserved on Windows are encoded as well.
+func encodeID(id string) string {
+	const hex = "0123456789ABCDEF"
+
+	var sb strings.Builder
+	for i := 0; i < len(id); i++ {
+		b := id[i]
+		if safeIDByte(b) && !(b == '.' && (i == 0 || i == len(id)-1)) {
+			sb.WriteByte(b)
+			continue
+		}
+		sb.WriteByte('%')
+		sb.WriteByte(hex[b>>4])
+		sb.WriteByte(hex[b&0xf])
+	}
+
+	name := sb.String()
+	base, _, _ := strings.Cut(name, ".")
+	if reservedNames[base] {
+		name = fmt.Sprintf("%%%02X%s", name[0], name[1:])
+	}
+	return name
+}
+
+// safeIDByte returns true if b is kept as is in file names.
+func safeIDByte(b byte) bool {
+	return 'a' <= b && b <= 'z' || '0' <= b && b <= '9' || b == '-' || b == '_' || b == '.'
+}
+
+// decodeID returns the record id of the file name produced by encodeID.
+// Names that aren't validly encoded are returned as is.
+func decodeID(name string) string {
+	if !strings.Contains(name, "%") {
+		return name
+	}
+
+	b := make([]byte, 0, len(name))
+	for i := 0; i < len(name); i++ {
+		if name[i] == '%' && i+2 < len(name) {
+			hi, ok1 := unhex(name[i+1])
+			lo, ok2 := unhex(name[i+2])
+			if ok1 && ok2 {
+				b = append(b, hi<<4|lo)
+				i += 2
+				continue
+			}
+		}
+		b = append(b, name[i])
+	}
+	return string(b)
+}
+
+// unhex returns the value of the hex digit c.
+func unhex(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+// namesFile is the name of the file marking a collection whose records all
+// have encoded file names.
+const namesFile = ".names"
+
+// legacyName returns true if the record file name, without extension, isn't
+// produced by encodeID. Records stored before ids were encoded are named
+// after their id as is.
+//
+// Legacy names that happen to be valid encodings, such as lower case ids or
+// ids like "a%41", are taken to be encoded.
+func legacyName(name string) bool {
+	return encodeID(decodeID(name)) != name
+}
+
+// initNames renames the records of the collection stored under their id as is
+// to their encoded file name, and marks the collection as done.
+//
+// Read-only collections can't be renamed, they read the records with legacy
+// names instead. Init fails if a record is stored under both names.
+func (c *Collection) initNames() error {
+	marker := filepath.Join(c.fullpath(), namesFile)
+	if _, err := c.Backend.Stat(marker); err == nil {
+		return nil
+	}
+
+	var legacy []string
+	if err := walkDir(c.Backend, c.fullpath(), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(path, ".sds") && legacyName(strings.TrimSuffix(d.Name(), ".sds")) {
+			legacy = append(legacy, path)
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("listing records: %w", err)
+	}
+
+	if c.readOnly {
+		c.legacyNames = len(legacy) > 0
+		return nil
+	}
+
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	for _, path := range legacy {
+		id := c.idFromPath(path)
+		dest := filepath.Join(filepath.Dir(path), encodeID(id)+".sds")
+		if _, err := c.Backend.Stat(dest); err == nil {
+			return fmt.Errorf("renaming record %q: %w: stored as %s and %s", id, ErrNotIDNotUnique, filepath.Base(path), filepath.Base(dest))
+		}
+		if err := c.Backend.Rename(path, dest); err != nil {
+			return fmt.Errorf("renaming record %q: %w", id, err)
+		}
+	}
+
+	filePerm, _ := c.filePerms()
+	if err := c.Backend.WriteFile(marker, []byte("1"), filePerm); err != nil {
+		return fmt.Errorf("saving %s: %w", namesFile, err)
+	}
+	return nil
+}
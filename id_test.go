@@ -0,0 +1,161 @@
+package sdstore_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/toqns/sdstore"
+)
+
+func TestIDEncoding(t *testing.T) {
+	path := t.TempDir()
+	store, err := sdstore.New("defaults", path, sdstore.WithJSONEncoding())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+	}
+
+	c, err := store.Collection("ids", Record{})
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+	}
+
+	ids := []string{"../../escape", "a/b", "Case", "case", "con", ".hidden", "100%", "héllo wörld"}
+	for _, id := range ids {
+		if err := c.Create(id, Record{ID: id}); err != nil {
+			t.Fatalf("%s\tShould be able to create record %q: %v.", failed, id, err)
+		}
+	}
+	t.Logf("%s\tShould be able to create records with path-unsafe ids.", success)
+
+	for _, id := range ids {
+		var got Record
+		if err := c.Get(id, &got); err != nil || got.ID != id {
+			t.Fatalf("%s\tShould be able to get record %q: %v.", failed, id, err)
+		}
+	}
+	t.Logf("%s\tShould be able to get records with path-unsafe ids.", success)
+
+	entries, err := os.ReadDir(filepath.Join(path, "defaults", "ids"))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to read the collection directory: %v.", failed, err)
+	}
+	var names []string
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".sds") {
+			names = append(names, strings.ToLower(e.Name()))
+		}
+	}
+	if len(names) != len(ids) {
+		t.Fatalf("%s\tShould store all records in the collection directory: %v.", failed, names)
+	}
+	sort.Strings(names)
+	for i := 1; i < len(names); i++ {
+		if names[i] == names[i-1] {
+			t.Fatalf("%s\tShould store ids in case-insensitively unique file names: %v.", failed, names)
+		}
+	}
+	t.Logf("%s\tShould store records in safe file names.", success)
+
+	res, err := c.Query(func(any) bool { return true })
+	if err != nil {
+		t.Fatalf("%s\tShould be able to query the collection: %v.", failed, err)
+	}
+	var got []string
+	for _, r := range res {
+		got = append(got, r.(*Record).ID)
+	}
+	sort.Strings(got)
+	want := append([]string(nil), ids...)
+	sort.Strings(want)
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Fatalf("%s\tShould query all records:\n%s", failed, diff)
+	}
+
+	var rep *sdstore.Report
+	if rep, err = store.Verify(context.Background()); err != nil || !rep.OK() {
+		t.Fatalf("%s\tShould decode file names back into ids: %+v, %v.", failed, rep, err)
+	}
+	t.Logf("%s\tShould decode file names back into ids.", success)
+
+	for _, id := range []string{"", strings.Repeat("x", 250)} {
+		if err := c.Create(id, Record{ID: id}); !errors.Is(err, sdstore.ErrInvalidID) {
+			t.Fatalf("%s\tShould not be able to create a record with id %.10q: %v.", failed, id, err)
+		}
+	}
+	t.Logf("%s\tShould not be able to create records with invalid ids.", success)
+}
+
+func TestLegacyIDNames(t *testing.T) {
+	path := t.TempDir()
+	dir := filepath.Join(path, "defaults", "legacy")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatalf("%s\tShould be able to create a collection directory: %v.", failed, err)
+	}
+
+	// Records stored under their id as is, before ids were encoded.
+	ids := []string{"ABC", "lower", "Mixed Case"}
+	for _, id := range ids {
+		if err := os.WriteFile(filepath.Join(dir, id+".sds"), []byte(`{"ID":"`+id+`"}`), 0600); err != nil {
+			t.Fatalf("%s\tShould be able to write a legacy record: %v.", failed, err)
+		}
+	}
+
+	// A read-only store reads the legacy names.
+	ro, err := sdstore.OpenFS(os.DirFS(path), "defaults", sdstore.WithJSONEncoding())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to open a read-only store: %v.", failed, err)
+	}
+	rc, err := ro.Collection("legacy", Record{})
+	if err != nil {
+		t.Fatalf("%s\tShould be able to open a read-only collection: %v.", failed, err)
+	}
+	var got Record
+	if err := rc.Get("ABC", &got); err != nil || got.ID != "ABC" {
+		t.Fatalf("%s\tShould be able to get a legacy record from a read-only store: %v.", failed, err)
+	}
+	t.Logf("%s\tShould be able to get legacy records from a read-only store.", success)
+
+	store, err := sdstore.New("defaults", path, sdstore.WithJSONEncoding())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to open the store: %v.", failed, err)
+	}
+	c, err := store.Collection("legacy", Record{})
+	if err != nil {
+		t.Fatalf("%s\tShould be able to open the collection: %v.", failed, err)
+	}
+
+	for _, id := range ids {
+		if err := c.Get(id, &got); err != nil || got.ID != id {
+			t.Fatalf("%s\tShould be able to get legacy record %q: %v.", failed, id, err)
+		}
+	}
+	if err := c.Create("ABC", Record{ID: "ABC"}); !errors.Is(err, sdstore.ErrNotIDNotUnique) {
+		t.Fatalf("%s\tShould not be able to create a record with a legacy id: %v.", failed, err)
+	}
+	res, err := c.Query(func(any) bool { return true })
+	if err != nil || len(res) != len(ids) {
+		t.Fatalf("%s\tShould query each legacy record once: %d, %v.", failed, len(res), err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ABC.sds")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("%s\tShould rename legacy records: %v.", failed, err)
+	}
+	t.Logf("%s\tShould rename legacy records on open.", success)
+
+	// A record stored under both names can't be opened.
+	if err := os.WriteFile(filepath.Join(dir, "ABC.sds"), []byte(`{"ID":"ABC"}`), 0600); err != nil {
+		t.Fatalf("%s\tShould be able to write a legacy record: %v.", failed, err)
+	}
+	if err := os.Remove(filepath.Join(dir, ".names")); err != nil {
+		t.Fatalf("%s\tShould be able to remove the names marker: %v.", failed, err)
+	}
+	if _, err := store.Collection("legacy", Record{}); !errors.Is(err, sdstore.ErrNotIDNotUnique) {
+		t.Fatalf("%s\tShould not be able to open a collection with a record under both names: %v.", failed, err)
+	}
+	t.Logf("%s\tShould not be able to open a collection with a record under both names.", success)
+}
@@ -0,0 +1,252 @@
+package sdstore
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sequenceFile is the name of the file holding the last id generated by the
+// Sequence generator of a collection.
+const sequenceFile = ".sequence"
+
+// IDGenerator generates ids for records stored with Insert.
+type IDGenerator func(c *Collection) (string, error)
+
+// WithIDGenerator is an option to set the generator of the ids of records
+// stored with Insert. Collections use UUIDv7 by default.
+func WithIDGenerator(gen IDGenerator) CollectionOption {
+	return func(c *Collection) {
+		c.IDGenerator = gen
+	}
+}
+
+// UUIDv4 generates random UUIDs (RFC 9562, version 4).
+func UUIDv4(_ *Collection) (string, error) {
+	var u [16]byte
+	if _, err := rand.Read(u[:]); err != nil {
+		return "", fmt.Errorf("generating id: %w", err)
+	}
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+
+	return formatUUID(u), nil
+}
+
+// UUIDv7 generates time-ordered UUIDs (RFC 9562, version 7). Ids generated
+// within the same millisecond by this process increase monotonically.
+func UUIDv7(_ *Collection) (string, error) {
+	ms, r, err := uuidClock.next()
+	if err != nil {
+		return "", err
+	}
+
+	var u [16]byte
+	binary.BigEndian.PutUint16(u[0:2], uint16(ms>>32))
+	binary.BigEndian.PutUint32(u[2:6], uint32(ms))
+	u[6] = 0x70 | r[0]&0x0f
+	u[7] = r[1]
+	u[8] = 0x80 | r[2]&0x3f
+	copy(u[9:], r[3:])
+
+	return formatUUID(u), nil
+}
+
+// ULID generates time-ordered ULIDs. Ids generated within the same
+// millisecond by this process increase monotonically.
+//
+// ULIDs are returned in lower case, which keeps record file names readable;
+// the Crockford base32 alphabet is case-insensitive.
+func ULID(_ *Collection) (string, error) {
+	ms, r, err := ulidClock.next()
+	if err != nil {
+		return "", err
+	}
+
+	const alphabet = "0123456789abcdefghjkmnpqrstvwxyz"
+
+	// 48 bits of time followed by 80 random bits, 5 bits per character.
+	var b [16]byte
+	binary.BigEndian.PutUint16(b[0:2], uint16(ms>>32))
+	binary.BigEndian.PutUint32(b[2:6], uint32(ms))
+	copy(b[6:], r[:])
+
+	id := make([]byte, 26)
+	hi, lo := binary.BigEndian.Uint64(b[0:8]), binary.BigEndian.Uint64(b[8:16])
+	for i := 25; i >= 0; i-- {
+		id[i] = alphabet[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+
+	return string(id), nil
+}
+
+// Sequence generates increasing decimal ids, starting at 1. The last generated
+// id is stored in the collection directory, so the sequence continues when the
+// collection is opened again. Ids of existing records are skipped.
+func Sequence(c *Collection) (string, error) {
+	c.gate.RLock()
+	defer c.gate.RUnlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	unlock, err := c.lock()
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	name := filepath.Join(c.fullpath(), sequenceFile)
+
+	var n uint64
+	b, err := c.load(name)
+	switch {
+	case err == nil:
+		if n, err = strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64); err != nil {
+			return "", fmt.Errorf("loading sequence: invalid sequence %q", b)
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return "", fmt.Errorf("loading sequence: %w", err)
+	}
+
+	n++
+	for c.exists(strconv.FormatUint(n, 10)) {
+		n++
+	}
+	id := strconv.FormatUint(n, 10)
+
+	filePerm, _ := c.filePerms()
+	if err := c.Backend.WriteFile(name, []byte(id), filePerm); err != nil {
+		return "", fmt.Errorf("saving sequence: %w", err)
+	}
+
+	return id, nil
+}
+
+// formatUUID returns the canonical string form of u.
+func formatUUID(u [16]byte) string {
+	var b [36]byte
+	hex.Encode(b[0:8], u[0:4])
+	b[8] = '-'
+	hex.Encode(b[9:13], u[4:6])
+	b[13] = '-'
+	hex.Encode(b[14:18], u[6:8])
+	b[18] = '-'
+	hex.Encode(b[19:23], u[8:10])
+	b[23] = '-'
+	hex.Encode(b[24:], u[10:])
+	return string(b[:])
+}
+
+var (
+	uuidClock monotonicClock
+	ulidClock monotonicClock
+)
+
+// monotonicClock returns millisecond timestamps with 80 random bits, which
+// are incremented instead of regenerated while the timestamp doesn't advance.
+type monotonicClock struct {
+	mu   sync.Mutex
+	ms   uint64
+	rand [10]byte
+}
+
+// next returns the next timestamp and random bits.
+func (m *monotonicClock) next() (uint64, [10]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ms := uint64(time.Now().UnixMilli())
+	if ms > m.ms {
+		if _, err := rand.Read(m.rand[:]); err != nil {
+			return 0, m.rand, fmt.Errorf("generating id: %w", err)
+		}
+		// Leave room to increment within the millisecond.
+		m.rand[0] &= 0x7f
+		m.ms = ms
+		return m.ms, m.rand, nil
+	}
+
+	for i := len(m.rand) - 1; i >= 0; i-- {
+		m.rand[i]++
+		if m.rand[i] != 0 {
+			break
+		}
+	}
+	return m.ms, m.rand, nil
+}
+
+// Insert stores the provided record with an id generated by the IDGenerator of
+// the Collection and returns the id.
+//
+// The id is also written to the ID field of the record, if it has a string field
+// named ID. data should be a pointer to the record for the caller to see the id.
+func (c *Collection) Insert(data any) (string, error) {
+	if !c.initialized {
+		return "", ErrNotInitialized
+	}
+	if c.readOnly {
+		return "", ErrReadOnly
+	}
+
+	// Ensure that data is in a workable format.
+	if !isStruct(data) && !isPointerToStruct(data) {
+		return "", ErrInvalidRecordType
+	}
+
+	gen := c.IDGenerator
+	if gen == nil {
+		gen = UUIDv7
+	}
+
+	// Work on a copy of structs passed by value, so the id can be set.
+	rec := reflect.ValueOf(data)
+	if rec.Kind() != reflect.Pointer {
+		cp := reflect.New(rec.Type())
+		cp.Elem().Set(rec)
+		rec = cp
+	}
+
+	idField := rec.Elem().FieldByName("ID")
+	if !idField.IsValid() || idField.Kind() != reflect.String || !idField.CanSet() {
+		idField = reflect.Value{}
+	}
+
+	// Generated ids are unique, but records may have been created with
+	// the same id, so retry a few times.
+	for attempt := 0; ; attempt++ {
+		id, err := gen(c)
+		if err != nil {
+			return "", err
+		}
+
+		var prev string
+		if idField.IsValid() {
+			prev = idField.String()
+			idField.SetString(id)
+		}
+
+		err = c.Create(id, rec.Interface())
+		if err == nil {
+			return id, nil
+		}
+
+		if idField.IsValid() {
+			idField.SetString(prev)
+		}
+		if !errors.Is(err, ErrNotIDNotUnique) || attempt == 3 {
+			return "", err
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package sdstore_test
+
+import (
+	"regexp"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/toqns/sdstore"
+)
+
+func TestInsert(t *testing.T) {
+	tt := []struct {
+		name    string
+		gen     sdstore.IDGenerator
+		pattern string
+		ordered bool
+	}{
+		{name: "default", pattern: `^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, ordered: true},
+		{name: "uuidv4", gen: sdstore.UUIDv4, pattern: `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`},
+		{name: "uuidv7", gen: sdstore.UUIDv7, pattern: `^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, ordered: true},
+		{name: "ulid", gen: sdstore.ULID, pattern: `^[0-7][0-9a-hjkmnp-tv-z]{25}$`, ordered: true},
+		{name: "sequence", gen: sdstore.Sequence, pattern: `^[0-9]+$`},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			store, err := sdstore.New("defaults", t.TempDir(), sdstore.WithJSONEncoding())
+			if err != nil {
+				t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+			}
+
+			var opts []sdstore.CollectionOption
+			if tc.gen != nil {
+				opts = append(opts, sdstore.WithIDGenerator(tc.gen))
+			}
+			c, err := store.Collection("test", Record{}, opts...)
+			if err != nil {
+				t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+			}
+
+			re := regexp.MustCompile(tc.pattern)
+			var ids []string
+			for i := 0; i < 50; i++ {
+				rec := Record{Name: "Name"}
+				id, err := c.Insert(&rec)
+				if err != nil {
+					t.Fatalf("%s\tShould be able to insert a record: %v.", failed, err)
+				}
+				if !re.MatchString(id) {
+					t.Fatalf("%s\tShould generate a valid id: got %q.", failed, id)
+				}
+				if rec.ID != id {
+					t.Fatalf("%s\tShould set the ID field: got %q, want %q.", failed, rec.ID, id)
+				}
+
+				var got Record
+				if err := c.Get(id, &got); err != nil || got.ID != id {
+					t.Fatalf("%s\tShould store the record with the generated id: %v.", failed, err)
+				}
+				ids = append(ids, id)
+			}
+			t.Logf("%s\tShould be able to insert records with generated ids.", success)
+
+			if tc.ordered && !sort.StringsAreSorted(ids) {
+				t.Fatalf("%s\tShould generate ordered ids: %v.", failed, ids)
+			}
+
+			seen := make(map[string]bool)
+			for _, id := range ids {
+				if seen[id] {
+					t.Fatalf("%s\tShould generate unique ids: %q is duplicate.", failed, id)
+				}
+				seen[id] = true
+			}
+			t.Logf("%s\tShould generate unique ids.", success)
+		})
+	}
+}
+
+func TestSequence(t *testing.T) {
+	store, err := sdstore.New("defaults", t.TempDir(), sdstore.WithJSONEncoding())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+	}
+
+	opts := []sdstore.CollectionOption{sdstore.WithIDGenerator(sdstore.Sequence)}
+	c, err := store.Collection("test", Record{}, opts...)
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+	}
+
+	// Records created with an explicit id are skipped.
+	if err := c.Create("2", Record{ID: "2"}); err != nil {
+		t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+	}
+
+	var ids []string
+	for i := 0; i < 2; i++ {
+		id, err := c.Insert(Record{})
+		if err != nil {
+			t.Fatalf("%s\tShould be able to insert a record: %v.", failed, err)
+		}
+		ids = append(ids, id)
+	}
+
+	// Reopen to verify that the sequence is persisted.
+	c, err = store.Collection("test", Record{}, opts...)
+	if err != nil {
+		t.Fatalf("%s\tShould be able to reopen the collection: %v.", failed, err)
+	}
+	if err := c.Delete("3"); err != nil {
+		t.Fatalf("%s\tShould be able to delete a record: %v.", failed, err)
+	}
+	id, err := c.Insert(Record{})
+	if err != nil {
+		t.Fatalf("%s\tShould be able to insert a record: %v.", failed, err)
+	}
+	ids = append(ids, id)
+
+	if diff := cmp.Diff(ids, []string{"1", "3", "4"}); diff != "" {
+		t.Fatalf("%s\tShould generate a persistent sequence:\n%s", failed, diff)
+	}
+	t.Logf("%s\tShould generate a persistent sequence.", success)
+
+	var got Record
+	if err := c.Get("4", &got); err != nil || got.ID != "4" {
+		t.Fatalf("%s\tShould set the ID of records passed by value: %v.", failed, err)
+	}
+	t.Logf("%s\tShould set the ID of records passed by value.", success)
+}
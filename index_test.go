@@ -0,0 +1,144 @@
+package sdstore_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/toqns/sdstore"
+)
+
+type Device struct {
+	ID      string
+	Serial  int
+	Seen    time.Time
+	Key     []byte
+	Addr    net.IP
+	Owner   *string
+	Enabled bool
+	Size    uint64
+}
+
+func TestTypedIndexKeys(t *testing.T) {
+	store, err := sdstore.New("defaults", t.TempDir())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+	}
+
+	c, err := store.Collection("devices", Device{}, sdstore.WithIndexedFields("Serial", "Seen", "Key", "Addr", "Owner", "Size"))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+	}
+
+	seen := time.Date(2024, 5, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+	owner := "alice"
+	devices := []Device{
+		{ID: "1", Serial: 1, Seen: seen, Key: []byte{1, 2}, Addr: net.ParseIP("10.0.0.1"), Owner: &owner, Size: 1 << 63},
+		{ID: "2", Serial: 2, Seen: seen.Add(time.Hour), Key: []byte{3}, Addr: net.ParseIP("10.0.0.2")},
+	}
+	for _, d := range devices {
+		if err := c.Create(d.ID, d); err != nil {
+			t.Fatalf("%s\tShould be able to create record %s: %v.", failed, d.ID, err)
+		}
+	}
+	t.Logf("%s\tShould be able to create records with typed indexed fields.", success)
+
+	var got Device
+	for _, tt := range []struct {
+		field string
+		value any
+		id    string
+	}{
+		{"Serial", 1, "1"},
+		{"Serial", int64(2), "2"},
+		{"Serial", 2.0, "2"},
+		{"Seen", seen.UTC(), "1"},
+		{"Seen", seen.Add(time.Hour), "2"},
+		{"Key", []byte{3}, "2"},
+		{"Addr", net.ParseIP("10.0.0.1"), "1"},
+		{"Owner", "alice", "1"},
+		{"Owner", &owner, "1"},
+		{"Size", float64(1 << 63), "1"},
+	} {
+		if err := c.GetIndexed(tt.field, tt.value, &got); err != nil || got.ID != tt.id {
+			t.Fatalf("%s\tShould find record %s by %s %v: got %q, %v.", failed, tt.id, tt.field, tt.value, got.ID, err)
+		}
+	}
+	t.Logf("%s\tShould find records by typed values.", success)
+
+	for _, tt := range []struct {
+		field string
+		value string
+	}{
+		{"Serial", "1"},
+		{"Seen", seen.UTC().Format(time.RFC3339Nano)},
+		{"Addr", "10.0.0.1"},
+	} {
+		if err := c.GetIndexed(tt.field, tt.value, &got); !errors.Is(err, sdstore.ErrNotFound) {
+			t.Fatalf("%s\tShould not find %s by its string: %v.", failed, tt.field, err)
+		}
+	}
+	t.Logf("%s\tShould not find numbers, times and text by their string.", success)
+
+	var niue *sdstore.IndexedValueNotUniqueError
+	if err := c.Create("3", Device{ID: "3", Serial: 3, Seen: seen.UTC()}); !errors.As(err, &niue) {
+		t.Fatalf("%s\tShould not be able to create a record with the same time in another zone: %v.", failed, err)
+	}
+	t.Logf("%s\tShould not be able to create a record with the same time in another zone.", success)
+
+	if err := c.GetIndexed("Serial", struct{}{}, &got); !errors.Is(err, sdstore.ErrUnsupportedIndexValue) {
+		t.Fatalf("%s\tShould not be able to look up an unsupported value: %v.", failed, err)
+	}
+	t.Logf("%s\tShould not be able to look up an unsupported value.", success)
+
+	// Reopen to load the index from disk.
+	c, err = store.Collection("devices", Device{}, sdstore.WithIndexedFields("Serial", "Seen", "Key", "Addr", "Owner", "Size"))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to reopen the collection: %v.", failed, err)
+	}
+	if err := c.GetIndexed("Seen", seen, &got); err != nil || got.ID != "1" {
+		t.Fatalf("%s\tShould find a record by time after reopening: got %q, %v.", failed, got.ID, err)
+	}
+	t.Logf("%s\tShould find a record by time after reopening.", success)
+
+	type Unsupported struct {
+		ID   string
+		Tags map[string]string
+	}
+	u, err := store.Collection("unsupported", Unsupported{}, sdstore.WithIndexedFields("Tags"))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+	}
+	if err := u.Create("1", Unsupported{ID: "1", Tags: map[string]string{"a": "b"}}); !errors.Is(err, sdstore.ErrUnsupportedIndexValue) {
+		t.Fatalf("%s\tShould not be able to index an unsupported value: %v.", failed, err)
+	}
+	var rec Unsupported
+	if err := u.Get("1", &rec); !errors.Is(err, sdstore.ErrNotFound) {
+		t.Fatalf("%s\tShould not save a record with an unsupported indexed value.", failed)
+	}
+	t.Logf("%s\tShould not be able to index an unsupported value.", success)
+}
+
+func TestIndexedValueNotUniqueLeavesIndex(t *testing.T) {
+	store, err := sdstore.New("defaults", t.TempDir())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+	}
+	c, err := store.Collection("users", Record{}, sdstore.WithIndexedFields("Name", "Email"))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+	}
+
+	if err := c.Create("1", Record{ID: "1", Name: "One", Email: "one@example.com"}); err != nil {
+		t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+	}
+	var niue *sdstore.IndexedValueNotUniqueError
+	if err := c.Create("2", Record{ID: "2", Name: "Two", Email: "one@example.com"}); !errors.As(err, &niue) || niue.Field != "Email" {
+		t.Fatalf("%s\tShould not be able to create a record with a value that isn't unique: %v.", failed, err)
+	}
+	if err := c.Create("3", Record{ID: "3", Name: "Two", Email: "three@example.com"}); err != nil {
+		t.Fatalf("%s\tShould not index the values of a rejected record: %v.", failed, err)
+	}
+	t.Logf("%s\tShould not index the values of a rejected record.", success)
+}
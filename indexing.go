@@ -1,10 +1,38 @@
 package sdstore
 
 import (
+	"encoding"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"math"
 	"reflect"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// keyVersion is the version of the format of index keys. Indexes with keys
+// of another version are rebuilt.
+const keyVersion = 2
+
+// ErrUnsupportedIndexValue is an error returned when the value of an indexed
+// field is of a type that can't be indexed.
+var ErrUnsupportedIndexValue = errors.New("value can't be indexed")
+
+// indexing holds the indexes of a Collection, which are stored in its index file.
+type indexing struct {
+	Fields      []string
+	Indexes     map[string]string
+	References  map[string]map[string][]string
+	FullText    FullTextIndex
+	MultiKey    MultiKeyIndex
+	Normalizers map[string][]string
+	Sparse      []string
+	Partial     []string
+	KeyVersion  int
+}
+
 // isStruct returns true if v is a struct.
 func isStruct(v any) bool {
 	return reflect.ValueOf(v).Kind() == reflect.Struct
@@ -21,18 +49,207 @@ func isPointerToStruct(v any) bool {
 }
 
 // getFieldValue returns the value of a field if the provided struct has such a field.
+// field is a path of field names separated by dots for fields of nested structs,
+// such as "Address.PostalCode". Pointer fields are dereferenced.
 //
-// Returns nil if v is not a struct or pointer to struct or if v doesn't have the field
+// Returns nil if v is not a struct or pointer to struct, if v doesn't have the field
+// or if the field or a struct on its path is a nil pointer or interface.
 func getFieldValue(v any, field string) any {
 	// Check if v is struct or *struct to prevent panics.
 	if !isStruct(v) && !isPointerToStruct(v) {
 		return nil
 	}
 
-	s := reflect.ValueOf(v)
-	return s.FieldByName(field)
+	f := indirect(fieldByPath(reflect.ValueOf(v), field))
+	if !f.IsValid() || !f.CanInterface() {
+		return nil
+	}
+
+	return f.Interface()
+}
+
+// fieldByPath returns the field of the struct v at the dotted path field.
+// The returned value is invalid if the field can't be reached.
+func fieldByPath(v reflect.Value, field string) reflect.Value {
+	for _, name := range strings.Split(field, ".") {
+		v = indirect(v)
+		if v.Kind() != reflect.Struct {
+			return reflect.Value{}
+		}
+		v = v.FieldByName(name)
+	}
+	return v
+}
+
+// indirect dereferences pointers and interfaces, and returns an invalid value
+// if one of them is nil.
+func indirect(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// fieldValues returns the values of a field like getFieldValue, with one value
+// per element for slices, which may be anywhere on the path of field. Byte
+// slices are values, nil values are skipped.
+func fieldValues(v any, field string) []any {
+	if !isStruct(v) && !isPointerToStruct(v) {
+		return nil
+	}
+
+	var vals []any
+	collectValues(reflect.ValueOf(v), strings.Split(field, "."), &vals)
+	return vals
+}
+
+// collectValues appends the values of v at path to vals.
+func collectValues(v reflect.Value, path []string, vals *[]any) {
+	v = indirect(v)
+	if !v.IsValid() {
+		return
+	}
+
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8 {
+		for i := 0; i < v.Len(); i++ {
+			collectValues(v.Index(i), path, vals)
+		}
+		return
+	}
+
+	if len(path) == 0 {
+		if v.CanInterface() {
+			*vals = append(*vals, v.Interface())
+		}
+		return
+	}
+
+	if v.Kind() == reflect.Struct {
+		collectValues(v.FieldByName(path[0]), path[1:], vals)
+	}
 }
 
-func key(field string, value any) string {
-	return fmt.Sprintf("%s:%v", field, value)
+// key returns the key of the index of field for value.
+//
+// Values are encoded by kind, so equal values of different types have the same
+// key: numbers by their decimal value, strings and the text of
+// encoding.TextMarshalers as is, times in UTC, booleans as true or false and
+// byte slices in base64. The kind is part of the key, so the string "1" and
+// the number 1 differ, as do a string and a time or TextMarshaler with the same
+// text. Keys are text, to keep them intact in JSON encoded index files.
+func key(field string, value any) (string, error) {
+	v, err := keyValue(value)
+	if err != nil {
+		return "", fmt.Errorf("indexing %s: %w", field, err)
+	}
+	return field + ":" + v, nil
+}
+
+// Kinds of index values.
+const (
+	keyNumber    = "n"
+	keyText      = "s"
+	keyBool      = "b"
+	keyBytes     = "x"
+	keyTime      = "t"
+	keyMarshaler = "m"
+)
+
+// keyValue returns the canonical encoding of value in index keys.
+func keyValue(value any) (string, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return keyTime + v.UTC().Format(time.RFC3339Nano), nil
+	case encoding.TextMarshaler:
+		b, err := v.MarshalText()
+		if err != nil {
+			return "", err
+		}
+		return keyMarshaler + string(b), nil
+	}
+
+	rv := reflect.ValueOf(value)
+	if !rv.IsValid() {
+		return "", fmt.Errorf("%w: nil", ErrUnsupportedIndexValue)
+	}
+	if rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return "", fmt.Errorf("%w: nil", ErrUnsupportedIndexValue)
+		}
+		return keyValue(rv.Elem().Interface())
+	}
+
+	// Values of types with a TextMarshaler method with a pointer receiver.
+	if p := reflect.New(rv.Type()); p.Type().Implements(reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()) {
+		p.Elem().Set(rv)
+		return keyValue(p.Interface())
+	}
+
+	switch rv.Kind() {
+	case reflect.String:
+		return keyText + rv.String(), nil
+	case reflect.Bool:
+		return keyBool + strconv.FormatBool(rv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return keyNumber + strconv.FormatInt(rv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return keyNumber + strconv.FormatUint(rv.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		// Integral floats are encoded exactly in decimal like integers, so 1.0
+		// equals 1 and 1<<63 equals uint64(1<<63). Negative zero equals zero.
+		if f == math.Trunc(f) {
+			if f == 0 {
+				f = 0
+			}
+			return keyNumber + strconv.FormatFloat(f, 'f', 0, 64), nil
+		}
+		return keyNumber + strconv.FormatFloat(f, 'g', -1, 64), nil
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return keyBytes + base64.StdEncoding.EncodeToString(rv.Bytes()), nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: %s", ErrUnsupportedIndexValue, rv.Type())
+}
+
+// keyKind returns the kind of the value of the index key k.
+func keyKind(k string) string {
+	_, v, _ := strings.Cut(k, ":")
+	if len(v) == 0 {
+		return ""
+	}
+	return v[:1]
+}
+
+// textValue is a TextMarshaler holding the text of a value that has been
+// decoded without knowledge of its type.
+type textValue string
+
+// MarshalText implements the encoding.TextMarshaler interface for textValue.
+func (t textValue) MarshalText() ([]byte, error) {
+	return []byte(t), nil
+}
+
+// splitKey returns the field and the value of the index key k.
+func splitKey(k string) (string, string) {
+	field, v, _ := strings.Cut(k, ":")
+	if len(v) > 0 {
+		v = v[1:]
+	}
+	return field, v
+}
+
+// contains returns true if ss contains s.
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
 }
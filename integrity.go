@@ -0,0 +1,234 @@
+package sdstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// CorruptRecordError is an error indicating that a record or index file
+// failed its integrity check or could not be decoded.
+type CorruptRecordError struct {
+	ID   string
+	Path string
+	Err  error
+}
+
+// Error implements the Error interface for CorruptRecordError.
+func (err *CorruptRecordError) Error() string {
+	if err.ID == "" {
+		return fmt.Sprintf("%s is corrupt: %v", err.Path, err.Err)
+	}
+	return fmt.Sprintf("record %q (%s) is corrupt: %v", err.ID, err.Path, err.Err)
+}
+
+// Unwrap returns the underlying error.
+func (err *CorruptRecordError) Unwrap() error {
+	return err.Err
+}
+
+// ErrChecksumMismatch is an error returned when the content of a file doesn't
+// match its checksum.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// checksumMagic prefixes every file written with checksums enabled.
+// It is followed by the CRC32C (Castagnoli) of the remaining bytes.
+const checksumMagic = "\x00SDC"
+
+// checksumHeaderLen is the length of a checksum header.
+const checksumHeaderLen = len(checksumMagic) + 4
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// WithChecksums is an option to store a CRC32C checksum in the header of every
+// record and index file, which is verified on every read. Files without a
+// checksum are corrupt, unless the collection is opened with WithUnsealedFiles.
+func WithChecksums() CollectionOption {
+	return func(c *Collection) {
+		c.Checksums = true
+	}
+}
+
+// WithUnsealedFiles is an option to accept record and index files without a
+// checksum header in a collection with checksums, such as files written before
+// checksums were enabled. It is meant for migrating a collection to checksums
+// with Seal.
+func WithUnsealedFiles() CollectionOption {
+	return func(c *Collection) {
+		c.unsealedFiles = true
+	}
+}
+
+// seal prefixes b with a checksum header if checksums are enabled.
+func (c *Collection) seal(b []byte) []byte {
+	if !c.Checksums {
+		return b
+	}
+
+	out := make([]byte, checksumHeaderLen, checksumHeaderLen+len(b))
+	copy(out, checksumMagic)
+	binary.BigEndian.PutUint32(out[len(checksumMagic):], crc32.Checksum(b, crc32c))
+
+	return append(out, b...)
+}
+
+// unseal verifies and strips the checksum header of b. Data without a
+// checksum header is returned as is if checksums aren't enabled or unsealed
+// files are accepted.
+func (c *Collection) unseal(b []byte) ([]byte, error) {
+	if !bytes.HasPrefix(b, []byte(checksumMagic)) {
+		if c.Checksums && !c.unsealedFiles {
+			return nil, fmt.Errorf("%w: missing checksum", ErrChecksumMismatch)
+		}
+		return b, nil
+	}
+
+	if len(b) < checksumHeaderLen {
+		return nil, fmt.Errorf("%w: truncated header", ErrChecksumMismatch)
+	}
+
+	sum := binary.BigEndian.Uint32(b[len(checksumMagic):checksumHeaderLen])
+	payload := b[checksumHeaderLen:]
+	if crc32.Checksum(payload, crc32c) != sum {
+		return nil, ErrChecksumMismatch
+	}
+
+	return payload, nil
+}
+
+// Seal adds a checksum header to the record and index files of the Collection
+// that don't have one, such as those written before checksums were enabled, and
+// returns the number of sealed records. It does nothing if checksums aren't
+// enabled. Writes to the Collection are blocked while the files are sealed.
+//
+// Afterwards the collection can be opened without WithUnsealedFiles.
+func (c *Collection) Seal(ctx context.Context) (int, error) {
+	if !c.initialized {
+		return 0, ErrNotInitialized
+	}
+	if c.readOnly {
+		return 0, ErrReadOnly
+	}
+	if !c.Checksums {
+		return 0, nil
+	}
+
+	c.gate.RLock()
+	defer c.gate.RUnlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	unlock, err := c.lock()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	var sealed int
+	if err := walkDir(c.Backend, c.fullpath(), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".sds") {
+			return nil
+		}
+
+		b, err := c.load(path)
+		if err != nil {
+			return err
+		}
+		if bytes.HasPrefix(b, []byte(checksumMagic)) {
+			return nil
+		}
+
+		// Files are compressed before they are sealed, so the contents are
+		// sealed as they are.
+		if err := c.save(path, c.seal(b)); err != nil {
+			return fmt.Errorf("saving record %q: %w", c.idFromPath(path), err)
+		}
+		sealed++
+		return nil
+	}); err != nil {
+		return sealed, err
+	}
+
+	return sealed, c.saveIndexes()
+}
+
+// idFromPath returns the record id for the provided record file path.
+// Legacy names are the id as is.
+func (c *Collection) idFromPath(path string) string {
+	name := strings.TrimSuffix(filepath.Base(path), ".sds")
+	if legacyName(name) {
+		return name
+	}
+	return decodeID(name)
+}
+
+// read loads the file at path and decodes it to dest.
+//
+// Errors loading the file are returned as is, integrity and decoding errors
+// are returned as *CorruptRecordError.
+func (c *Collection) read(path string, dest any) error {
+	b, err := c.load(path)
+	if err != nil {
+		return err
+	}
+
+	record := strings.HasSuffix(path, ".sds")
+	decode := c.decode
+	if record {
+		decode = c.decodeRecord
+	}
+
+	if err := decode(b, dest); err != nil {
+		cerr := CorruptRecordError{Path: path, Err: err}
+		if record {
+			cerr.ID = c.idFromPath(path)
+		}
+		return &cerr
+	}
+
+	return nil
+}
+
+// QueryOption is an option for a Query.
+type QueryOption func(*queryOptions)
+
+// queryOptions holds the settings of a Query.
+type queryOptions struct {
+	skipCorrupt bool
+	onCorrupt   func(*CorruptRecordError)
+	metaFilter  func(Meta) bool
+	sortBy      MetaField
+	sortDesc    bool
+	includes    []include
+}
+
+// newQueryOptions returns the settings of a Query with options opts.
+func newQueryOptions(opts []QueryOption) *queryOptions {
+	var qo queryOptions
+	for _, opt := range opts {
+		opt(&qo)
+	}
+	return &qo
+}
+
+// WithSkipCorrupt is an option to skip corrupt records during a Query instead
+// of aborting it. report, if not nil, is called for every skipped record.
+func WithSkipCorrupt(report func(*CorruptRecordError)) QueryOption {
+	return func(o *queryOptions) {
+		o.skipCorrupt = true
+		o.onCorrupt = report
+	}
+}
@@ -0,0 +1,168 @@
+package sdstore_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/toqns/sdstore"
+)
+
+func TestChecksums(t *testing.T) {
+	path := t.TempDir()
+	store, err := sdstore.New("integrity", path, sdstore.WithJSONEncoding())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+	}
+	t.Logf("%s\tShould be able to create a new store.", success)
+
+	opts := []sdstore.CollectionOption{sdstore.WithChecksums(), sdstore.WithIndexedFields("Email")}
+	c, err := store.Collection("test", Record{}, opts...)
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+	}
+	t.Logf("%s\tShould be able to create a new collection.", success)
+
+	for _, rec := range []Record{
+		{ID: "1", Name: "One", Email: "one@example.com"},
+		{ID: "2", Name: "Two", Email: "two@example.com"},
+	} {
+		if err := c.Create(rec.ID, rec); err != nil {
+			t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+		}
+	}
+	t.Logf("%s\tShould be able to create records.", success)
+
+	// Flip a bit in the payload of the first record.
+	recPath := filepath.Join(path, "integrity", "test", "1.sds")
+	b, err := os.ReadFile(recPath)
+	if err != nil {
+		t.Fatalf("%s\tShould be able to read the record file: %v.", failed, err)
+	}
+	b[len(b)-2] ^= 0x01
+	if err := os.WriteFile(recPath, b, 0600); err != nil {
+		t.Fatalf("%s\tShould be able to write the record file: %v.", failed, err)
+	}
+
+	var corrupt *sdstore.CorruptRecordError
+	if err := c.Get("1", &Record{}); !errors.As(err, &corrupt) || corrupt.ID != "1" {
+		t.Fatalf("%s\tShould get a CorruptRecordError for record 1: %v.", failed, err)
+	}
+	t.Logf("%s\tShould get a CorruptRecordError for record 1.", success)
+
+	if !errors.Is(corrupt, sdstore.ErrChecksumMismatch) {
+		t.Fatalf("%s\tShould get a checksum mismatch: %v.", failed, corrupt)
+	}
+	t.Logf("%s\tShould get a checksum mismatch.", success)
+
+	all := func(any) bool { return true }
+	if _, err := c.Query(all); !errors.As(err, &corrupt) {
+		t.Fatalf("%s\tShould fail the query with a CorruptRecordError: %v.", failed, err)
+	}
+	t.Logf("%s\tShould fail the query with a CorruptRecordError.", success)
+
+	var reported []string
+	res, err := c.Query(all, sdstore.WithSkipCorrupt(func(err *sdstore.CorruptRecordError) {
+		reported = append(reported, err.ID)
+	}))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to query skipping corrupt records: %v.", failed, err)
+	}
+	t.Logf("%s\tShould be able to query skipping corrupt records.", success)
+
+	if len(res) != 1 || len(reported) != 1 || reported[0] != "1" {
+		t.Fatalf("%s\tShould get 1 record and 1 report, got: %d, %v.", failed, len(res), reported)
+	}
+	t.Logf("%s\tShould get 1 record and 1 report.", success)
+
+	// Truncate the index and make sure it's rebuilt on reopen.
+	if err := os.Remove(recPath); err != nil {
+		t.Fatalf("%s\tShould be able to remove the corrupt record: %v.", failed, err)
+	}
+	idxPath := filepath.Join(path, "integrity", "test", "test.sdx")
+	b, err = os.ReadFile(idxPath)
+	if err != nil {
+		t.Fatalf("%s\tShould be able to read the index file: %v.", failed, err)
+	}
+	if err := os.WriteFile(idxPath, b[:len(b)/2], 0600); err != nil {
+		t.Fatalf("%s\tShould be able to truncate the index file: %v.", failed, err)
+	}
+
+	c2, err := store.Collection("test", Record{}, opts...)
+	if err != nil {
+		t.Fatalf("%s\tShould be able to reopen a collection with a corrupt index: %v.", failed, err)
+	}
+	t.Logf("%s\tShould be able to reopen a collection with a corrupt index.", success)
+
+	var got Record
+	if err := c2.GetIndexed("Email", "two@example.com", &got); err != nil || got.ID != "2" {
+		t.Fatalf("%s\tShould be able to get a record through the rebuilt index: %v.", failed, err)
+	}
+	t.Logf("%s\tShould be able to get a record through the rebuilt index.", success)
+}
+
+func TestSealUnsealedFiles(t *testing.T) {
+	path := t.TempDir()
+	store, err := sdstore.New("integrity", path, sdstore.WithJSONEncoding())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+	}
+
+	// Write records before checksums are enabled.
+	c, err := store.Collection("test", Record{})
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+	}
+	for _, rec := range []Record{{ID: "1", Name: "One"}, {ID: "2", Name: "Two"}} {
+		if err := c.Create(rec.ID, rec); err != nil {
+			t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+		}
+	}
+
+	c, err = store.Collection("test", Record{}, sdstore.WithChecksums())
+	if err == nil {
+		err = c.Get("1", &Record{})
+	}
+	if !errors.Is(err, sdstore.ErrChecksumMismatch) {
+		t.Fatalf("%s\tShould reject files without a checksum: %v.", failed, err)
+	}
+	t.Logf("%s\tShould reject files without a checksum.", success)
+
+	c, err = store.Collection("test", Record{}, sdstore.WithChecksums(), sdstore.WithUnsealedFiles())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to open a collection with unsealed files: %v.", failed, err)
+	}
+	n, err := c.Seal(context.Background())
+	if err != nil || n != 2 {
+		t.Fatalf("%s\tShould seal 2 records: got %d, %v.", failed, n, err)
+	}
+	t.Logf("%s\tShould seal 2 records.", success)
+
+	c, err = store.Collection("test", Record{}, sdstore.WithChecksums())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to open a sealed collection: %v.", failed, err)
+	}
+	var got Record
+	if err := c.Get("2", &got); err != nil || got.Name != "Two" {
+		t.Fatalf("%s\tShould be able to get a sealed record: %v.", failed, err)
+	}
+	t.Logf("%s\tShould be able to get a sealed record.", success)
+
+	// Strip the checksum header of a sealed record.
+	recPath := filepath.Join(path, "integrity", "test", "1.sds")
+	b, err := os.ReadFile(recPath)
+	if err != nil {
+		t.Fatalf("%s\tShould be able to read the record file: %v.", failed, err)
+	}
+	if err := os.WriteFile(recPath, b[8:], 0600); err != nil {
+		t.Fatalf("%s\tShould be able to write the record file: %v.", failed, err)
+	}
+
+	var corrupt *sdstore.CorruptRecordError
+	if err := c.Get("1", &Record{}); !errors.As(err, &corrupt) || !errors.Is(err, sdstore.ErrChecksumMismatch) {
+		t.Fatalf("%s\tShould report a record without its checksum as corrupt: %v.", failed, err)
+	}
+	t.Logf("%s\tShould report a record without its checksum as corrupt.", success)
+}
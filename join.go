@@ -0,0 +1,176 @@
+package sdstore
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"reflect"
+	"sort"
+)
+
+// include is a reference to load with the results of a Query.
+type include struct {
+	field  string
+	target *Collection
+	into   string
+}
+
+// Include is an option to load the records of target referenced by field of
+// the results of a Query. Each referenced record is loaded once, however many
+// results reference it. The included records are returned by QueryWith.
+func Include(field string, target *Collection) QueryOption {
+	return func(o *queryOptions) {
+		o.includes = append(o.includes, include{field: field, target: target})
+	}
+}
+
+// IncludeInto is an option like Include which also sets the field into of the
+// results of a Query to the referenced record. into should be a field of the
+// record type of target, a pointer to it or an interface it implements, and is
+// left unchanged if the referenced record doesn't exist.
+func IncludeInto(field string, target *Collection, into string) QueryOption {
+	return func(o *queryOptions) {
+		o.includes = append(o.includes, include{field: field, target: target, into: into})
+	}
+}
+
+// Joined is a result of QueryWith.
+type Joined struct {
+	Record any
+
+	// Included holds the referenced records loaded with Include and IncludeInto
+	// by the name of the referencing field. Referenced records that don't exist
+	// are missing.
+	Included map[string]any
+}
+
+// QueryWith returns the records for which f returns true like Query, paired
+// with the referenced records loaded with the Include and IncludeInto options.
+func (c *Collection) QueryWith(f func(any) bool, opts ...QueryOption) ([]Joined, error) {
+	if !c.initialized {
+		return nil, ErrNotInitialized
+	}
+
+	qo := newQueryOptions(opts)
+	res, err := c.query(f, qo)
+	if err != nil {
+		return nil, err
+	}
+
+	included, err := c.include(res, qo)
+	if err != nil {
+		return nil, err
+	}
+
+	joined := make([]Joined, len(res))
+	for i, rec := range res {
+		joined[i] = Joined{Record: rec, Included: included[i]}
+	}
+
+	return joined, nil
+}
+
+// include loads the referenced records of the Query results res requested by
+// the query options o, and returns them per result by the referencing field.
+func (c *Collection) include(res []any, o *queryOptions) ([]map[string]any, error) {
+	if len(o.includes) == 0 {
+		return make([]map[string]any, len(res)), nil
+	}
+
+	included := make([]map[string]any, len(res))
+	for i := range included {
+		included[i] = make(map[string]any)
+	}
+
+	for _, inc := range o.includes {
+		if inc.target == nil || !inc.target.initialized {
+			return nil, fmt.Errorf("include %s: %w", inc.field, ErrNotInitialized)
+		}
+
+		// Collect the distinct referenced ids.
+		refs := make([]string, len(res))
+		ids := make(map[string]bool)
+		for i, rec := range res {
+			refs[i] = refID(rec, inc.field)
+			if refs[i] != "" {
+				ids[refs[i]] = true
+			}
+		}
+
+		recs, err := inc.target.getMany(ids)
+		if err != nil {
+			return nil, fmt.Errorf("include %s: %w", inc.field, err)
+		}
+
+		for i, rec := range res {
+			ref, ok := recs[refs[i]]
+			if !ok {
+				continue
+			}
+			included[i][inc.field] = ref
+
+			if inc.into == "" {
+				continue
+			}
+			if err := setField(rec, inc.into, ref); err != nil {
+				return nil, fmt.Errorf("include %s: %w", inc.field, err)
+			}
+		}
+	}
+
+	return included, nil
+}
+
+// getMany returns the records with the provided ids that exist, decoded to
+// pointers to the record type of the Collection, by id.
+func (c *Collection) getMany(ids map[string]bool) (map[string]any, error) {
+	sorted := make([]string, 0, len(ids))
+	for id := range ids {
+		sorted = append(sorted, id)
+	}
+	sort.Strings(sorted)
+
+	recs := make(map[string]any, len(ids))
+	for _, id := range sorted {
+		if validateID(id) != nil {
+			continue
+		}
+
+		rec := reflect.New(c.record).Interface()
+		if err := c.read(c.filepath(id, false), rec); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		recs[id] = rec
+	}
+
+	return recs, nil
+}
+
+// setField sets field of rec, a pointer to a struct, to v, a pointer to a
+// struct, or to the struct v points to.
+func setField(rec any, field string, v any) error {
+	rv := reflect.ValueOf(rec)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Struct {
+		return ErrInvalidRecordType
+	}
+
+	f := rv.Elem().FieldByName(field)
+	if !f.IsValid() || !f.CanSet() {
+		return fmt.Errorf("no field %s in %s", field, rv.Elem().Type())
+	}
+
+	val := reflect.ValueOf(v)
+	switch {
+	case val.Type().AssignableTo(f.Type()):
+		f.Set(val)
+	case val.Elem().Type().AssignableTo(f.Type()):
+		f.Set(val.Elem())
+	default:
+		return fmt.Errorf("can't set field %s of type %s to %s", field, f.Type(), val.Type())
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package sdstore_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/toqns/sdstore"
+)
+
+// Order is a record referencing a customer.
+type Order struct {
+	ID         string
+	CustomerID string
+	Customer   *Record `json:"-" codec:"-"`
+}
+
+// countingBackend counts the records read from a collection.
+type countingBackend struct {
+	*sdstore.MemBackend
+	collection string
+	reads      int
+}
+
+func (b *countingBackend) ReadFile(name string) ([]byte, error) {
+	if strings.Contains(name, b.collection) && strings.HasSuffix(name, ".sds") {
+		b.reads++
+	}
+	return b.MemBackend.ReadFile(name)
+}
+
+func TestInclude(t *testing.T) {
+	backend := countingBackend{MemBackend: sdstore.NewMemBackend(), collection: "customers"}
+	store, err := sdstore.New("defaults", "/", sdstore.WithBackend(&backend))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+	}
+
+	customers, err := store.Collection("customers", Record{})
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+	}
+	orders, err := store.Collection("orders", Order{})
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+	}
+
+	for _, rec := range []Record{{ID: "1", Name: "One"}, {ID: "2", Name: "Two"}} {
+		if err := customers.Create(rec.ID, rec); err != nil {
+			t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+		}
+	}
+	for _, rec := range []Order{
+		{ID: "o1", CustomerID: "1"},
+		{ID: "o2", CustomerID: "1"},
+		{ID: "o3", CustomerID: "2"},
+		{ID: "o4", CustomerID: "9"},
+		{ID: "o5"},
+	} {
+		if err := orders.Create(rec.ID, rec); err != nil {
+			t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+		}
+	}
+
+	backend.reads = 0
+	res, err := orders.QueryWith(func(any) bool { return true }, sdstore.Include("CustomerID", customers))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to query with included records: %v.", failed, err)
+	}
+	got := make(map[string]string)
+	for _, j := range res {
+		if c, ok := j.Included["CustomerID"]; ok {
+			got[j.Record.(*Order).ID] = c.(*Record).Name
+		}
+	}
+	if diff := cmp.Diff(got, map[string]string{"o1": "One", "o2": "One", "o3": "Two"}); diff != "" {
+		t.Fatalf("%s\tShould pair the results with the included records:\n%s", failed, diff)
+	}
+	// Customers 1, 2 and the missing 9 are read once each.
+	if backend.reads != 3 {
+		t.Fatalf("%s\tShould load every included record once: got %d reads.", failed, backend.reads)
+	}
+	t.Logf("%s\tShould pair the results with the included records.", success)
+
+	recs, err := orders.Query(func(rec any) bool {
+		return rec.(*Order).ID == "o3"
+	}, sdstore.IncludeInto("CustomerID", customers, "Customer"))
+	if err != nil || len(recs) != 1 {
+		t.Fatalf("%s\tShould be able to query with included records: %v.", failed, err)
+	}
+	if c := recs[0].(*Order).Customer; c == nil || c.Name != "Two" {
+		t.Fatalf("%s\tShould set the field of the results to the included records: got %+v.", failed, c)
+	}
+	t.Logf("%s\tShould set the field of the results to the included records.", success)
+
+	if _, err := orders.Query(func(any) bool { return true }, sdstore.IncludeInto("CustomerID", customers, "Missing")); err == nil {
+		t.Fatalf("%s\tShould not be able to include into a missing field.", failed)
+	}
+	t.Logf("%s\tShould not be able to include into a missing field.", success)
+}
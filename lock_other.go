@@ -0,0 +1,31 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+
+package sdstore
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// fileLocks holds the in-process locks of lockFile, which is used on systems
+// without advisory file locking.
+var fileLocks sync.Map
+
+// lockFile acquires an exclusive lock on the file name within this process.
+func lockFile(name string) (func() error, error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, defaultFilePerm)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+
+	l, _ := fileLocks.LoadOrStore(filepath.Clean(name), &sync.Mutex{})
+	mu := l.(*sync.Mutex)
+	mu.Lock()
+
+	return func() error {
+		mu.Unlock()
+		return nil
+	}, nil
+}
@@ -0,0 +1,290 @@
+package sdstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// metaMagic prefixes the metadata of records written by a collection with
+// metadata enabled.
+const metaMagic = "\x00SDM"
+
+// Meta is the metadata of a record, which is stored alongside the record by
+// collections opened with WithMetadata.
+type Meta struct {
+	// CreatedAt is the time the record was created, zero for records created
+	// before metadata was enabled.
+	CreatedAt time.Time
+
+	// UpdatedAt is the time the record was last written.
+	UpdatedAt time.Time
+
+	// Revision is the number of times the record has been written, 1 for a
+	// newly created record and 0 for records without metadata.
+	Revision int
+
+	// Actor is the actor provided to CreateAs or UpdateAs, if any.
+	Actor string
+}
+
+// WithMetadata is an option to store the metadata of the records of a Collection,
+// see Meta. Records written before metadata was enabled have no metadata until
+// they are updated.
+func WithMetadata() CollectionOption {
+	return func(c *Collection) {
+		c.Metadata = true
+	}
+}
+
+// nextMeta returns the metadata of a record written by actor, which is created
+// if old is nil and updated otherwise.
+func nextMeta(old *Meta, actor string) *Meta {
+	now := time.Now().UTC()
+	if old == nil {
+		return &Meta{CreatedAt: now, UpdatedAt: now, Revision: 1, Actor: actor}
+	}
+	return &Meta{CreatedAt: old.CreatedAt, UpdatedAt: now, Revision: old.Revision + 1, Actor: actor}
+}
+
+// addMeta prefixes the encoded record b with the metadata m, b is returned as
+// is if m is nil.
+func addMeta(b []byte, m *Meta) []byte {
+	if m == nil {
+		return b
+	}
+
+	var fields bytes.Buffer
+	buf := make([]byte, binary.MaxVarintLen64)
+	fields.Write(buf[:binary.PutVarint(buf, unixNano(m.CreatedAt))])
+	fields.Write(buf[:binary.PutVarint(buf, unixNano(m.UpdatedAt))])
+	fields.Write(buf[:binary.PutUvarint(buf, uint64(m.Revision))])
+	fields.Write(buf[:binary.PutUvarint(buf, uint64(len(m.Actor)))])
+	fields.WriteString(m.Actor)
+
+	hdr := append([]byte(metaMagic), buf[:binary.PutUvarint(buf, uint64(fields.Len()))]...)
+	return append(append(hdr, fields.Bytes()...), b...)
+}
+
+// parseMeta returns the metadata and the remainder of b. The metadata is nil
+// if b has none.
+func parseMeta(b []byte) (*Meta, []byte, error) {
+	if !bytes.HasPrefix(b, []byte(metaMagic)) {
+		return nil, b, nil
+	}
+
+	b = b[len(metaMagic):]
+	size, n := binary.Uvarint(b)
+	if n <= 0 || uint64(len(b)-n) < size {
+		return nil, nil, fmt.Errorf("invalid metadata header")
+	}
+	fields, rest := b[n:n+int(size)], b[n+int(size):]
+
+	// Fields are read in order, fields added later may follow.
+	r := bytes.NewReader(fields)
+	created, err1 := binary.ReadVarint(r)
+	updated, err2 := binary.ReadVarint(r)
+	revision, err3 := binary.ReadUvarint(r)
+	actor, err4 := binary.ReadUvarint(r)
+	if err := firstErr(err1, err2, err3, err4); err != nil || uint64(r.Len()) < actor {
+		return nil, nil, fmt.Errorf("invalid metadata")
+	}
+	name := fields[len(fields)-r.Len():][:actor]
+
+	m := Meta{
+		CreatedAt: fromUnixNano(created),
+		UpdatedAt: fromUnixNano(updated),
+		Revision:  int(revision),
+		Actor:     string(name),
+	}
+
+	return &m, rest, nil
+}
+
+// firstErr returns the first non-nil error of errs.
+func firstErr(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unixNano returns t as nanoseconds since the Unix epoch, 0 for the zero time.
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// fromUnixNano is the inverse of unixNano.
+func fromUnixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n).UTC()
+}
+
+// readMeta loads the record at path and returns its metadata, which is nil if
+// the record has none. The record is decoded to dest, unless dest is nil.
+//
+// Errors are returned like read.
+func (c *Collection) readMeta(path string, dest any) (*Meta, error) {
+	b, err := c.load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	m, v, b, err := c.unpackMeta(b)
+	if err == nil && dest != nil {
+		err = c.decodePayload(v, b, dest)
+	}
+	if err != nil {
+		return nil, &CorruptRecordError{ID: c.idFromPath(path), Path: path, Err: err}
+	}
+
+	return m, nil
+}
+
+// GetWithMeta receives a record from disk by the provided ID like Get and
+// returns its metadata. The metadata is zero if the record has none.
+//
+// dest should be a pointer to a struct.
+func (c *Collection) GetWithMeta(id string, dest any) (Meta, error) {
+	if !c.initialized {
+		return Meta{}, ErrNotInitialized
+	}
+
+	// Ensure that dest is in a workable format.
+	if !isStruct(dest) && !isPointerToStruct(dest) {
+		return Meta{}, ErrInvalidRecordType
+	}
+
+	if err := validateID(id); err != nil {
+		return Meta{}, err
+	}
+
+	// Return an error if the record doesn't exist.
+	if !c.exists(id) {
+		return Meta{}, ErrNotFound
+	}
+
+	m, err := c.readMeta(c.filepath(id, false), dest)
+	if err != nil {
+		return Meta{}, fmt.Errorf("loading record: %w", err)
+	}
+	if m == nil {
+		return Meta{}, nil
+	}
+
+	return *m, nil
+}
+
+// MetaField is a field of Meta by which Query results can be sorted.
+type MetaField int
+
+// Meta fields.
+const (
+	CreatedAt MetaField = iota + 1
+	UpdatedAt
+	Revision
+)
+
+// less returns true if a sorts before b by field f.
+func (f MetaField) less(a Meta, b Meta) bool {
+	switch f {
+	case CreatedAt:
+		return a.CreatedAt.Before(b.CreatedAt)
+	case UpdatedAt:
+		return a.UpdatedAt.Before(b.UpdatedAt)
+	case Revision:
+		return a.Revision < b.Revision
+	}
+	return false
+}
+
+// WithMetaFilter is an option to only return records of a Query for which f
+// returns true. f is called with the metadata of a record before the record
+// is decoded, records without metadata have zero metadata.
+func WithMetaFilter(f func(Meta) bool) QueryOption {
+	return func(o *queryOptions) {
+		o.metaFilter = f
+	}
+}
+
+// WithSortByMeta is an option to sort the results of a Query by a field of
+// their metadata, in descending order if desc is true. Records without
+// metadata have zero metadata.
+func WithSortByMeta(field MetaField, desc bool) QueryOption {
+	return func(o *queryOptions) {
+		o.sortBy = field
+		o.sortDesc = desc
+	}
+}
+
+// sortByMeta sorts the Query results res with the metadata metas as requested
+// by the query options.
+func (o *queryOptions) sortByMeta(res []any, metas []Meta) {
+	if o.sortBy == 0 {
+		return
+	}
+
+	idx := make([]int, len(res))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		if o.sortDesc {
+			return o.sortBy.less(metas[idx[j]], metas[idx[i]])
+		}
+		return o.sortBy.less(metas[idx[i]], metas[idx[j]])
+	})
+
+	sorted := make([]any, len(res))
+	for i, n := range idx {
+		sorted[i] = res[n]
+	}
+	copy(res, sorted)
+}
+
+// queryMeta returns true if the Query needs the metadata of the records.
+func (o *queryOptions) queryMeta() bool {
+	return o.metaFilter != nil || o.sortBy != 0
+}
+
+// queryRecord loads the record at path for a Query and decodes it to dest,
+// unless the metadata filter of the query options o excludes it. It returns
+// the metadata of the record if o needs it, and false if it was excluded.
+//
+// Errors are returned like read.
+func (c *Collection) queryRecord(path string, dest any, o *queryOptions) (Meta, bool, error) {
+	if !o.queryMeta() {
+		return Meta{}, true, c.read(path, dest)
+	}
+
+	b, err := c.load(path)
+	if err != nil {
+		return Meta{}, false, err
+	}
+
+	m, v, b, err := c.unpackMeta(b)
+	if err != nil {
+		return Meta{}, false, &CorruptRecordError{ID: c.idFromPath(path), Path: path, Err: err}
+	}
+	if m == nil {
+		m = &Meta{}
+	}
+	if o.metaFilter != nil && !o.metaFilter(*m) {
+		return *m, false, nil
+	}
+
+	if err := c.decodePayload(v, b, dest); err != nil {
+		return Meta{}, false, &CorruptRecordError{ID: c.idFromPath(path), Path: path, Err: err}
+	}
+
+	return *m, true, nil
+}
@@ -0,0 +1,101 @@
+package sdstore_test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/toqns/sdstore"
+)
+
+func TestMetadata(t *testing.T) {
+	store, err := sdstore.New("defaults", t.TempDir())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+	}
+
+	// Create a record before metadata is enabled.
+	c, err := store.Collection("users", Record{})
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+	}
+	if err := c.Create("0", Record{ID: "0", Name: "Zero"}); err != nil {
+		t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+	}
+
+	c, err = store.Collection("users", Record{}, sdstore.WithMetadata(), sdstore.WithChecksums(), sdstore.WithUnsealedFiles())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to open a collection with metadata: %v.", failed, err)
+	}
+
+	var got Record
+	meta, err := c.GetWithMeta("0", &got)
+	if err != nil || got.Name != "Zero" || meta != (sdstore.Meta{}) {
+		t.Fatalf("%s\tShould return zero metadata for records without metadata: got %+v, %v.", failed, meta, err)
+	}
+	t.Logf("%s\tShould return zero metadata for records without metadata.", success)
+
+	if err := c.CreateAs("alice", "1", Record{ID: "1", Name: "One"}); err != nil {
+		t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+	}
+	created, err := c.GetWithMeta("1", &got)
+	if err != nil || got.Name != "One" {
+		t.Fatalf("%s\tShould be able to get a record with metadata: %v.", failed, err)
+	}
+	if created.CreatedAt.IsZero() || !created.UpdatedAt.Equal(created.CreatedAt) || created.Revision != 1 || created.Actor != "alice" {
+		t.Fatalf("%s\tShould record the metadata of created records: got %+v.", failed, created)
+	}
+	t.Logf("%s\tShould record the metadata of created records.", success)
+
+	if err := c.UpdateAs("bob", "1", Record{ID: "1", Name: "Uno"}); err != nil {
+		t.Fatalf("%s\tShould be able to update a record: %v.", failed, err)
+	}
+	if err := c.Update("0", Record{ID: "0", Name: "Cero"}); err != nil {
+		t.Fatalf("%s\tShould be able to update a record: %v.", failed, err)
+	}
+	updated, err := c.GetWithMeta("1", &got)
+	if err != nil {
+		t.Fatalf("%s\tShould be able to get a record with metadata: %v.", failed, err)
+	}
+	if !updated.CreatedAt.Equal(created.CreatedAt) || updated.UpdatedAt.Before(created.UpdatedAt) || updated.Revision != 2 || updated.Actor != "bob" {
+		t.Fatalf("%s\tShould record the metadata of updated records: got %+v.", failed, updated)
+	}
+	if meta, err := c.GetWithMeta("0", &got); err != nil || !meta.CreatedAt.IsZero() || meta.Revision != 1 {
+		t.Fatalf("%s\tShould record the metadata of records updated after metadata was enabled: got %+v, %v.", failed, meta, err)
+	}
+	t.Logf("%s\tShould record the metadata of updated records.", success)
+
+	names := func(recs []any) []string {
+		var names []string
+		for _, rec := range recs {
+			names = append(names, rec.(*Record).Name)
+		}
+		return names
+	}
+	all := func(any) bool { return true }
+
+	recs, err := c.Query(all, sdstore.WithSortByMeta(sdstore.Revision, true))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to query records: %v.", failed, err)
+	}
+	if diff := cmp.Diff(names(recs), []string{"Uno", "Cero"}); diff != "" {
+		t.Fatalf("%s\tShould sort records by metadata:\n%s", failed, diff)
+	}
+	t.Logf("%s\tShould sort records by metadata.", success)
+
+	recs, err = c.Query(all, sdstore.WithMetaFilter(func(m sdstore.Meta) bool { return m.Actor == "bob" }))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to query records: %v.", failed, err)
+	}
+	if diff := cmp.Diff(names(recs), []string{"Uno"}); diff != "" {
+		t.Fatalf("%s\tShould filter records by metadata:\n%s", failed, diff)
+	}
+	t.Logf("%s\tShould filter records by metadata.", success)
+
+	if err := c.ConvertEncoding(sdstore.NewMsgpackEncoder(), sdstore.NewMsgpackEncoder()); err != nil {
+		t.Fatalf("%s\tShould be able to convert the encoding: %v.", failed, err)
+	}
+	if meta, err := c.GetWithMeta("1", &got); err != nil || meta != updated {
+		t.Fatalf("%s\tShould keep the metadata when converting the encoding: got %+v, %v.", failed, meta, err)
+	}
+	t.Logf("%s\tShould keep the metadata when converting the encoding.", success)
+}
@@ -0,0 +1,255 @@
+package sdstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"reflect"
+	"strings"
+)
+
+// versionMagic prefixes records written by a collection with a schema version
+// above 0. Records without it have schema version 0.
+const versionMagic = "\x00SDV"
+
+// WithMigrations is an option to set the migrations of the records of a collection.
+//
+// migrations[v] transforms a record of schema version v-1 into version v. The
+// highest version in migrations is the schema version of the collection, which
+// is stored with every record written. Records of an older version are migrated
+// when they are read, and can be rewritten with Migrate.
+//
+// Migrations receive records decoded into maps. With JSON encoding, numbers are
+// json.Number values, so integers beyond the precision of float64 are kept.
+func WithMigrations(migrations map[int]func(old map[string]any) (map[string]any, error)) CollectionOption {
+	return func(c *Collection) {
+		c.migrations = migrations
+		c.SchemaVersion = 0
+		for v := range migrations {
+			if v > c.SchemaVersion {
+				c.SchemaVersion = v
+			}
+		}
+	}
+}
+
+// addVersion prefixes the encoded record b with the schema version of the
+// Collection. Records of collections without migrations are returned as is.
+func (c *Collection) addVersion(b []byte) []byte {
+	return addSchemaVersion(b, c.SchemaVersion)
+}
+
+// addSchemaVersion prefixes the encoded record b with the schema version v.
+// Records of schema version 0 aren't prefixed.
+func addSchemaVersion(b []byte, v int) []byte {
+	if v <= 0 {
+		return b
+	}
+
+	hdr := make([]byte, len(versionMagic)+binary.MaxVarintLen64)
+	n := copy(hdr, versionMagic)
+	n += binary.PutUvarint(hdr[n:], uint64(v))
+
+	return append(hdr[:n], b...)
+}
+
+// parseVersion returns the schema version and the encoded record of b.
+func parseVersion(b []byte) (int, []byte, error) {
+	if !bytes.HasPrefix(b, []byte(versionMagic)) {
+		return 0, b, nil
+	}
+
+	v, n := binary.Uvarint(b[len(versionMagic):])
+	if n <= 0 {
+		return 0, nil, fmt.Errorf("invalid version header")
+	}
+
+	return int(v), b[len(versionMagic)+n:], nil
+}
+
+// migrate applies the migrations of the Collection to the encoded record b
+// of schema version from and returns the encoded result.
+func (c *Collection) migrate(b []byte, from int) ([]byte, error) {
+	rec, err := c.decodeMap(b)
+	if err != nil {
+		return nil, err
+	}
+
+	for v := from + 1; v <= c.SchemaVersion; v++ {
+		m, ok := c.migrations[v]
+		if !ok {
+			continue
+		}
+
+		if rec, err = m(rec); err != nil {
+			return nil, fmt.Errorf("migrating to version %d: %w", v, err)
+		}
+	}
+
+	return c.Encoder.Encode(rec)
+}
+
+// decodeMap decodes the encoded record b into a map for migrations. JSON is
+// decoded with numbers as json.Number, which keeps their precision when they
+// are encoded again.
+func (c *Collection) decodeMap(b []byte) (map[string]any, error) {
+	var rec map[string]any
+	if c.encoding == "json" || c.encoding == "" && json.Valid(b) {
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.UseNumber()
+		return rec, d.Decode(&rec)
+	}
+
+	return rec, c.Decoder.Decode(b, &rec)
+}
+
+// MigrateOption is an option for Migrate.
+type MigrateOption func(*migrateOptions)
+
+// migrateOptions holds the settings of Migrate.
+type migrateOptions struct {
+	progress func(done, total int)
+}
+
+// WithProgress is an option to report the progress of Migrate. progress is
+// called after every record with the number of records processed so far and
+// the total number of records.
+func WithProgress(progress func(done, total int)) MigrateOption {
+	return func(o *migrateOptions) {
+		o.progress = progress
+	}
+}
+
+// Migrate rewrites all records of an older schema version with the current
+// schema version of the Collection and returns the number of migrated records.
+// The indexes are rebuilt after the records have been migrated.
+//
+// Writes are blocked one record at a time, so the Collection stays available
+// during the migration. A cancelled or failed Migrate can be resumed by running
+// it again, records that have been migrated already are skipped.
+func (c *Collection) Migrate(ctx context.Context, opts ...MigrateOption) (int, error) {
+	if !c.initialized {
+		return 0, ErrNotInitialized
+	}
+	if c.readOnly {
+		return 0, ErrReadOnly
+	}
+
+	var mo migrateOptions
+	for _, opt := range opts {
+		opt(&mo)
+	}
+
+	var paths []string
+	if err := walkDir(c.Backend, c.fullpath(), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(path, ".sds") {
+			paths = append(paths, path)
+		}
+		return nil
+	}); err != nil {
+		return 0, fmt.Errorf("listing records: %w", err)
+	}
+
+	var migrated int
+	for i, path := range paths {
+		if err := ctx.Err(); err != nil {
+			return migrated, err
+		}
+
+		ok, err := c.migrateRecord(path)
+		if err != nil {
+			return migrated, err
+		}
+		if ok {
+			migrated++
+		}
+
+		if mo.progress != nil {
+			mo.progress(i+1, len(paths))
+		}
+	}
+
+	if migrated == 0 {
+		return 0, nil
+	}
+
+	c.gate.RLock()
+	defer c.gate.RUnlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	unlock, err := c.lock()
+	if err != nil {
+		return migrated, err
+	}
+	defer unlock()
+
+	if err := c.recreateIndexes(); err != nil {
+		return migrated, fmt.Errorf("recreating indexes: %w", err)
+	}
+	if err := c.saveIndexes(); err != nil {
+		return migrated, fmt.Errorf("saving indexes: %w", err)
+	}
+
+	return migrated, nil
+}
+
+// migrateRecord rewrites the record at path if it has an older schema version
+// and returns true if it has been rewritten.
+func (c *Collection) migrateRecord(path string) (bool, error) {
+	c.gate.RLock()
+	defer c.gate.RUnlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	unlock, err := c.lock()
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	b, err := c.load(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		// Deleted since the records were listed.
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	meta, v, _, err := c.unpackMeta(b)
+	if err != nil {
+		return false, &CorruptRecordError{ID: c.idFromPath(path), Path: path, Err: err}
+	}
+	if v >= c.SchemaVersion {
+		return false, nil
+	}
+
+	// Decode into the record type to ensure that the migrated record is valid.
+	var rec any = map[string]any{}
+	if c.record != nil {
+		rec = reflect.New(c.record).Interface()
+	}
+	if err := c.decodeRecord(b, &rec); err != nil {
+		return false, &CorruptRecordError{ID: c.idFromPath(path), Path: path, Err: err}
+	}
+
+	b, err = c.encodeRecord(rec, meta)
+	if err != nil {
+		return false, fmt.Errorf("encoding record %q: %w", c.idFromPath(path), err)
+	}
+	if err := c.save(path, b); err != nil {
+		return false, fmt.Errorf("saving record %q: %w", c.idFromPath(path), err)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,148 @@
+package sdstore_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/toqns/sdstore"
+)
+
+func TestMigrations(t *testing.T) {
+	type RecordV0 struct {
+		ID   string
+		Name string
+		Mail string
+	}
+
+	migrations := map[int]func(map[string]any) (map[string]any, error){
+		1: func(old map[string]any) (map[string]any, error) {
+			old["Email"] = old["Mail"]
+			delete(old, "Mail")
+			return old, nil
+		},
+	}
+
+	for name, opt := range map[string]sdstore.StoreOption{
+		"json": sdstore.WithJSONEncoding(),
+		"cbor": sdstore.WithCborEncoding(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := t.TempDir()
+			store, err := sdstore.New("defaults", path, opt)
+			if err != nil {
+				t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+			}
+
+			old, err := store.Collection("test", RecordV0{})
+			if err != nil {
+				t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+			}
+			for _, rec := range []RecordV0{
+				{ID: "1", Name: "One", Mail: "one@example.com"},
+				{ID: "2", Name: "Two", Mail: "two@example.com"},
+			} {
+				if err := old.Create(rec.ID, rec); err != nil {
+					t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+				}
+			}
+
+			opts := []sdstore.CollectionOption{sdstore.WithIndexedFields("Email"), sdstore.WithMigrations(migrations)}
+			c, err := store.Collection("test", Record{}, opts...)
+			if err != nil {
+				t.Fatalf("%s\tShould be able to open a collection with migrations: %v.", failed, err)
+			}
+
+			var got Record
+			if err := c.Get("1", &got); err != nil || got.Email != "one@example.com" {
+				t.Fatalf("%s\tShould migrate records on read: got %+v, %v.", failed, got, err)
+			}
+			if err := c.GetIndexed("Email", "two@example.com", &got); err != nil || got.ID != "2" {
+				t.Fatalf("%s\tShould index migrated records: %v.", failed, err)
+			}
+			t.Logf("%s\tShould migrate records on read.", success)
+
+			var calls, total int
+			n, err := c.Migrate(context.Background(), sdstore.WithProgress(func(done, all int) {
+				calls, total = done, all
+			}))
+			if err != nil || n != 2 {
+				t.Fatalf("%s\tShould be able to migrate all records: got %d, %v.", failed, n, err)
+			}
+			if calls != 2 || total != 2 {
+				t.Fatalf("%s\tShould report the progress: got %d/%d.", failed, calls, total)
+			}
+			t.Logf("%s\tShould be able to migrate all records.", success)
+
+			b, err := os.ReadFile(filepath.Join(path, "defaults", "test", "1.sds"))
+			if err != nil || !bytes.HasPrefix(b, []byte("\x00SDV")) {
+				t.Fatalf("%s\tShould store the schema version with migrated records: %v.", failed, err)
+			}
+
+			if n, err := c.Migrate(context.Background()); err != nil || n != 0 {
+				t.Fatalf("%s\tShould skip migrated records: got %d, %v.", failed, n, err)
+			}
+			t.Logf("%s\tShould skip migrated records.", success)
+
+			// Reopen to verify that migrated records are read as is.
+			c, err = store.Collection("test", Record{}, opts...)
+			if err != nil {
+				t.Fatalf("%s\tShould be able to reopen the collection: %v.", failed, err)
+			}
+			if err := c.Get("2", &got); err != nil || got.Email != "two@example.com" {
+				t.Fatalf("%s\tShould read migrated records: got %+v, %v.", failed, got, err)
+			}
+			t.Logf("%s\tShould read migrated records.", success)
+		})
+	}
+}
+
+func TestMigrationsLargeNumbers(t *testing.T) {
+	type CounterV0 struct {
+		ID    string
+		Count int64
+	}
+	type Counter struct {
+		ID    string
+		Total int64
+	}
+
+	migrations := map[int]func(map[string]any) (map[string]any, error){
+		1: func(old map[string]any) (map[string]any, error) {
+			old["Total"] = old["Count"]
+			delete(old, "Count")
+			return old, nil
+		},
+	}
+
+	store, err := sdstore.New("defaults", t.TempDir(), sdstore.WithJSONEncoding())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+	}
+	old, err := store.Collection("counters", CounterV0{})
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+	}
+	const count = 1<<53 + 1
+	if err := old.Create("1", CounterV0{ID: "1", Count: count}); err != nil {
+		t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+	}
+
+	c, err := store.Collection("counters", Counter{}, sdstore.WithMigrations(migrations))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to open a collection with migrations: %v.", failed, err)
+	}
+	var got Counter
+	if err := c.Get("1", &got); err != nil || got.Total != count {
+		t.Fatalf("%s\tShould keep the precision of large numbers: got %d, %v.", failed, got.Total, err)
+	}
+	if _, err := c.Migrate(context.Background()); err != nil {
+		t.Fatalf("%s\tShould be able to migrate all records: %v.", failed, err)
+	}
+	if err := c.Get("1", &got); err != nil || got.Total != count {
+		t.Fatalf("%s\tShould keep the precision of large numbers when rewriting: got %d, %v.", failed, got.Total, err)
+	}
+	t.Logf("%s\tShould keep the precision of large numbers.", success)
+}
@@ -0,0 +1,171 @@
+package sdstore
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// ErrNotIndexed is an error returned when a user attempts to find records by a
+// field that isn't indexed.
+var ErrNotIndexed = errors.New("field is not indexed")
+
+// MultiKeyIndex is the index of the multi-key fields of a Collection.
+type MultiKeyIndex struct {
+	Fields []string
+
+	// Keys holds the sorted ids of the records by index key.
+	Keys map[string][]string
+}
+
+// WithMultiKeyIndex is an option to maintain a non-unique index over the
+// provided fields, which can be looked up with FindBy. Slices are indexed with
+// one entry per element, so a record with Tags []string is found by each of
+// its tags. Fields may be dotted paths through nested structs and slices of
+// structs, such as "Contacts.Email".
+func WithMultiKeyIndex(fields ...string) CollectionOption {
+	return func(c *Collection) {
+		c.multiKey = fields
+	}
+}
+
+// multiKeyIndexed returns true if the multi-key index matches the settings of c.
+func (c *Collection) multiKeyIndexed() bool {
+	if len(c.multiKey) == 0 {
+		return len(c.Indexing.MultiKey.Fields) == 0
+	}
+	return cmp.Equal(c.Indexing.MultiKey.Fields, c.multiKey)
+}
+
+// resetMultiKey clears the multi-key index before it is rebuilt.
+func (c *Collection) resetMultiKey() {
+	c.Indexing.MultiKey = MultiKeyIndex{}
+	if len(c.multiKey) == 0 {
+		return
+	}
+
+	c.Indexing.MultiKey = MultiKeyIndex{
+		Fields: c.multiKey,
+		Keys:   make(map[string][]string),
+	}
+}
+
+// multiKeys returns the distinct keys of the multi-key fields of rec.
+func (c *Collection) multiKeys(rec any) ([]string, error) {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, field := range c.multiKey {
+		for _, v := range fieldValues(rec, field) {
+			k, err := c.indexKey(field, v)
+			if err != nil {
+				return nil, err
+			}
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// indexMultiKeys replaces the keys of the old record id in the multi-key index
+// by those of rec. old or rec is nil if the record is created or deleted.
+func (c *Collection) indexMultiKeys(id string, old any, rec any) {
+	if len(c.multiKey) == 0 {
+		return
+	}
+
+	idx := &c.Indexing.MultiKey
+	if old != nil {
+		keys, _ := c.multiKeys(old)
+		for _, k := range keys {
+			c.removeMultiKey(k, id)
+		}
+	}
+
+	if rec == nil {
+		return
+	}
+	if idx.Keys == nil {
+		idx.Keys = make(map[string][]string)
+	}
+
+	keys, _ := c.multiKeys(rec)
+	for _, k := range keys {
+		ids := idx.Keys[k]
+		i := sort.SearchStrings(ids, id)
+		if i < len(ids) && ids[i] == id {
+			continue
+		}
+		ids = append(ids, "")
+		copy(ids[i+1:], ids[i:])
+		ids[i] = id
+		idx.Keys[k] = ids
+	}
+}
+
+// removeMultiKey removes the record id from key k of the multi-key index.
+func (c *Collection) removeMultiKey(k string, id string) {
+	idx := &c.Indexing.MultiKey
+	ids := idx.Keys[k]
+	if i := sort.SearchStrings(ids, id); i < len(ids) && ids[i] == id {
+		ids = append(ids[:i:i], ids[i+1:]...)
+	}
+	if len(ids) == 0 {
+		delete(idx.Keys, k)
+		return
+	}
+	idx.Keys[k] = ids
+}
+
+// FindBy returns the records whose field has the value v, ordered by id. field
+// should be a multi-key field or a field with a unique index. v matches values
+// of the same kind like GetIndexed.
+//
+// The records are decoded to pointers to the record type of the Collection.
+func (c *Collection) FindBy(field string, v any) ([]any, error) {
+	if !c.initialized {
+		return nil, ErrNotInitialized
+	}
+
+	c.mu.RLock()
+	k, err := c.indexKey(field, v)
+	var ids []string
+	switch {
+	case err != nil:
+	case contains(c.multiKey, field):
+		ids = append(ids, c.Indexing.MultiKey.Keys[k]...)
+	case contains(c.Indexing.Fields, field):
+		if id, ok := c.Indexing.Indexes[k]; ok {
+			ids = append(ids, id)
+		}
+	default:
+		err = fmt.Errorf("%w: %s", ErrNotIndexed, field)
+	}
+	c.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// Load the records, which may have been deleted in the meantime.
+	set := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	recs, err := c.getMany(set)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]any, 0, len(recs))
+	for _, id := range ids {
+		if rec, ok := recs[id]; ok {
+			res = append(res, rec)
+		}
+	}
+
+	return res, nil
+}
@@ -0,0 +1,139 @@
+package sdstore_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/toqns/sdstore"
+)
+
+type Address struct {
+	Street     string
+	PostalCode string
+}
+
+type Contact struct {
+	Kind  string
+	Value string
+}
+
+type Customer struct {
+	ID       string
+	Address  *Address
+	Tags     []string
+	Contacts []Contact
+}
+
+// ids returns the ids of the Customer records res.
+func ids(res []any) []string {
+	var ids []string
+	for _, r := range res {
+		ids = append(ids, r.(*Customer).ID)
+	}
+	return ids
+}
+
+func TestMultiKeyIndexes(t *testing.T) {
+	store, err := sdstore.New("defaults", t.TempDir())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+	}
+
+	opts := []sdstore.CollectionOption{
+		sdstore.WithIndexedFields("Address.PostalCode"),
+		sdstore.WithMultiKeyIndex("Tags", "Contacts.Value"),
+	}
+	c, err := store.Collection("customers", Customer{}, opts...)
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+	}
+
+	customers := []Customer{
+		{ID: "1", Address: &Address{PostalCode: "1000"}, Tags: []string{"vip", "new", "vip"},
+			Contacts: []Contact{{"email", "one@example.com"}, {"phone", "555-0101"}}},
+		{ID: "2", Address: &Address{PostalCode: "2000"}, Tags: []string{"vip"}},
+		{ID: "3", Tags: []string{"new"}, Contacts: []Contact{{"email", "three@example.com"}}},
+	}
+	for _, cu := range customers {
+		if err := c.Create(cu.ID, cu); err != nil {
+			t.Fatalf("%s\tShould be able to create record %s: %v.", failed, cu.ID, err)
+		}
+	}
+	t.Logf("%s\tShould be able to create records.", success)
+
+	var got Customer
+	if err := c.GetIndexed("Address.PostalCode", "2000", &got); err != nil || got.ID != "2" {
+		t.Fatalf("%s\tShould find a record by a nested field: got %q, %v.", failed, got.ID, err)
+	}
+	var niue *sdstore.IndexedValueNotUniqueError
+	if err := c.Create("4", Customer{ID: "4", Address: &Address{PostalCode: "1000"}}); !errors.As(err, &niue) {
+		t.Fatalf("%s\tShould not be able to create a record with a duplicate nested value: %v.", failed, err)
+	}
+	t.Logf("%s\tShould index nested fields.", success)
+
+	find := func(field string, v any, want ...string) {
+		t.Helper()
+		res, err := c.FindBy(field, v)
+		if err != nil {
+			t.Fatalf("%s\tShould be able to find records by %s %v: %v.", failed, field, v, err)
+		}
+		if got := ids(res); !equal(got, want) {
+			t.Fatalf("%s\tShould find %v by %s %v: got %v.", failed, want, field, v, got)
+		}
+	}
+
+	find("Tags", "vip", "1", "2")
+	find("Tags", "new", "1", "3")
+	find("Contacts.Value", "three@example.com", "3")
+	find("Address.PostalCode", "1000", "1")
+	find("Tags", "missing")
+	t.Logf("%s\tShould find records by slice elements.", success)
+
+	if _, err := c.FindBy("ID", "1"); !errors.Is(err, sdstore.ErrNotIndexed) {
+		t.Fatalf("%s\tShould not be able to find records by a field that isn't indexed: %v.", failed, err)
+	}
+	t.Logf("%s\tShould not be able to find records by a field that isn't indexed.", success)
+
+	if err := c.Update("1", Customer{ID: "1", Address: &Address{PostalCode: "1000"}, Tags: []string{"new", "gold"}}); err != nil {
+		t.Fatalf("%s\tShould be able to update a record: %v.", failed, err)
+	}
+	find("Tags", "vip", "2")
+	find("Tags", "gold", "1")
+	find("Tags", "new", "1", "3")
+	find("Contacts.Value", "one@example.com")
+	t.Logf("%s\tShould maintain the index on updates.", success)
+
+	if err := c.Delete("3"); err != nil {
+		t.Fatalf("%s\tShould be able to delete a record: %v.", failed, err)
+	}
+	find("Tags", "new", "1")
+	t.Logf("%s\tShould maintain the index on deletes.", success)
+
+	// Reopen to load the index from disk, and with another field to rebuild it.
+	c, err = store.Collection("customers", Customer{}, opts...)
+	if err != nil {
+		t.Fatalf("%s\tShould be able to reopen the collection: %v.", failed, err)
+	}
+	find("Tags", "gold", "1")
+
+	c, err = store.Collection("customers", Customer{}, sdstore.WithMultiKeyIndex("Tags", "Contacts.Kind"))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to reopen the collection: %v.", failed, err)
+	}
+	find("Tags", "vip", "2")
+	find("Contacts.Kind", "email")
+	t.Logf("%s\tShould load and rebuild the index.", success)
+}
+
+// equal returns true if a and b hold the same strings in the same order.
+func equal(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,146 @@
+package sdstore
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// ErrUnknownNormalizer is an error returned when a collection is opened with
+// a normalizer that isn't registered.
+var ErrUnknownNormalizer = errors.New("unknown normalizer")
+
+// Built-in normalizers.
+const (
+	// CaseFold folds the case of the value, so values that only differ in case
+	// are equal.
+	CaseFold = "casefold"
+
+	// Trim removes leading and trailing white space from the value.
+	Trim = "trim"
+)
+
+var (
+	normalizersMu sync.RWMutex
+	normalizers   = map[string]func(string) string{
+		CaseFold: foldCase,
+		Trim:     strings.TrimSpace,
+	}
+)
+
+// RegisterNormalizer makes a normalizer available by the provided name, to
+// be used with WithNormalizer. A normalizer registered under an existing name
+// replaces it. Unicode normalization, such as NFC with
+// golang.org/x/text/unicode/norm, is provided by registering a normalizer.
+//
+// Normalizers are recorded by name in the index, so a normalizer must not be
+// changed once it is in use without rebuilding the indexes.
+func RegisterNormalizer(name string, f func(string) string) {
+	normalizersMu.Lock()
+	defer normalizersMu.Unlock()
+
+	normalizers[name] = f
+}
+
+// Normalizers returns the names of the registered normalizers in lexical order.
+func Normalizers() []string {
+	normalizersMu.RLock()
+	defer normalizersMu.RUnlock()
+
+	names := make([]string, 0, len(normalizers))
+	for name := range normalizers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// lookupNormalizer returns the normalizer registered under name.
+func lookupNormalizer(name string) (func(string) string, bool) {
+	normalizersMu.RLock()
+	defer normalizersMu.RUnlock()
+
+	f, ok := normalizers[name]
+	return f, ok
+}
+
+// WithNormalizer is an option to normalize the string values of the indexed
+// field with the named normalizers, in order, before they are indexed or looked
+// up with GetIndexed. For example, WithNormalizer("Email", Trim, CaseFold) makes
+// the index of Email case-insensitive.
+//
+// The normalizers are recorded in the index, which is rebuilt when they change.
+func WithNormalizer(field string, names ...string) CollectionOption {
+	return func(c *Collection) {
+		if c.normalizers == nil {
+			c.normalizers = make(map[string][]string)
+		}
+		c.normalizers[field] = names
+	}
+}
+
+// initNormalizers resolves the normalizers of c.
+func (c *Collection) initNormalizers(names map[string][]string) error {
+	c.normalize = nil
+	for field, names := range names {
+		for _, name := range names {
+			f, ok := lookupNormalizer(name)
+			if !ok {
+				return fmt.Errorf("%w: %q for field %s", ErrUnknownNormalizer, name, field)
+			}
+
+			if c.normalize == nil {
+				c.normalize = make(map[string][]func(string) string)
+			}
+			c.normalize[field] = append(c.normalize[field], f)
+		}
+	}
+	return nil
+}
+
+// normalizersIndexed returns true if the index was built with the normalizers of c.
+func (c *Collection) normalizersIndexed() bool {
+	if len(c.normalizers) != len(c.Indexing.Normalizers) {
+		return false
+	}
+	for field, names := range c.normalizers {
+		if strings.Join(names, "\x00") != strings.Join(c.Indexing.Normalizers[field], "\x00") {
+			return false
+		}
+	}
+	return true
+}
+
+// indexKey returns the key of the unique index of field for value like key,
+// normalizing string values first.
+func (c *Collection) indexKey(field string, value any) (string, error) {
+	fs := c.normalize[field]
+	if v := reflect.ValueOf(value); len(fs) == 0 || v.Kind() != reflect.String {
+		return key(field, value)
+	}
+
+	s := reflect.ValueOf(value).String()
+	for _, f := range fs {
+		s = f(s)
+	}
+	return key(field, s)
+}
+
+// foldCase maps every rune of s to the smallest rune it is equivalent to under
+// simple Unicode case folding.
+func foldCase(s string) string {
+	return strings.Map(func(r rune) rune {
+		min := r
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			if f < min {
+				min = f
+			}
+		}
+		return min
+	}, s)
+}
@@ -0,0 +1,95 @@
+package sdstore_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/toqns/sdstore"
+)
+
+func TestNormalizedIndexes(t *testing.T) {
+	store, err := sdstore.New("defaults", t.TempDir())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+	}
+
+	c, err := store.Collection("users", Record{}, sdstore.WithIndexedFields("Email"))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+	}
+	if err := c.Create("1", Record{ID: "1", Email: " Alice@Example.com"}); err != nil {
+		t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+	}
+	if err := c.Create("2", Record{ID: "2", Email: "Jose\u0301@example.com"}); err != nil {
+		t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+	}
+
+	if _, err := store.Collection("users", Record{}, sdstore.WithIndexedFields("Email"), sdstore.WithNormalizer("Email", "missing")); !errors.Is(err, sdstore.ErrUnknownNormalizer) {
+		t.Fatalf("%s\tShould not be able to use an unknown normalizer: %v.", failed, err)
+	}
+	if _, err := store.Collection("users", Record{}, sdstore.WithIndexedFields("Email"), sdstore.WithNormalizer("Name", sdstore.Trim)); err == nil {
+		t.Fatalf("%s\tShould not be able to normalize a field that isn't indexed.", failed)
+	}
+	t.Logf("%s\tShould not be able to use invalid normalizers.", success)
+
+	// Reopen with normalizers to rebuild the index. Unicode normalization is
+	// left to registered normalizers.
+	sdstore.RegisterNormalizer("compose", func(s string) string {
+		return strings.NewReplacer("e\u0301", "\u00e9", "E\u0301", "\u00c9").Replace(s)
+	})
+	c, err = store.Collection("users", Record{}, sdstore.WithIndexedFields("Email"),
+		sdstore.WithNormalizer("Email", sdstore.Trim, "compose", sdstore.CaseFold))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to open a collection with normalizers: %v.", failed, err)
+	}
+
+	var niue *sdstore.IndexedValueNotUniqueError
+	if err := c.Create("3", Record{ID: "3", Email: "alice@example.COM"}); !errors.As(err, &niue) {
+		t.Fatalf("%s\tShould not be able to create a record with a value differing in case: %v.", failed, err)
+	}
+	t.Logf("%s\tShould not be able to create a record with a value differing in case.", success)
+
+	var got Record
+	if err := c.Update("2", Record{ID: "2", Email: "ALICE@example.com"}); !errors.As(err, &niue) {
+		t.Fatalf("%s\tShould not be able to update a record to a value differing in case: %v.", failed, err)
+	}
+	if err := c.GetIndexed("Email", "alice@example.com", &got); err != nil || got.ID != "1" {
+		t.Fatalf("%s\tShould keep the value indexed for its record: got %q, %v.", failed, got.ID, err)
+	}
+	if err := c.Update("1", Record{ID: "1", Email: "Alice@Example.com"}); err != nil {
+		t.Fatalf("%s\tShould be able to update a record to its own normalized value: %v.", failed, err)
+	}
+	t.Logf("%s\tShould not be able to update a record to a value differing in case.", success)
+
+	for email, id := range map[string]string{
+		"ALICE@example.com ": "1",
+		"josé@example.com":   "2",
+		"JOSÉ@EXAMPLE.COM":  "2",
+	} {
+		if err := c.GetIndexed("Email", email, &got); err != nil || got.ID != id {
+			t.Fatalf("%s\tShould find %q by its normalized value: got %q, %v.", failed, email, got.ID, err)
+		}
+	}
+	t.Logf("%s\tShould find records by their normalized value.", success)
+
+	sdstore.RegisterNormalizer("domain", func(s string) string {
+		_, domain, _ := strings.Cut(s, "@")
+		return domain
+	})
+	c, err = store.Collection("users", Record{}, sdstore.WithIndexedFields("Email"), sdstore.WithNormalizer("Email", "domain"))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to open a collection with a registered normalizer: %v.", failed, err)
+	}
+	if err := c.GetIndexed("Email", "someone@Example.com", &got); err != nil || got.ID != "1" {
+		t.Fatalf("%s\tShould rebuild the index when the normalizers change: got %q, %v.", failed, got.ID, err)
+	}
+	t.Logf("%s\tShould rebuild the index when the normalizers change.", success)
+
+	report, err := store.Verify(context.Background())
+	if err != nil || !report.OK() {
+		t.Fatalf("%s\tShould verify normalized indexes: got %+v, %v.", failed, report, err)
+	}
+	t.Logf("%s\tShould verify normalized indexes.", success)
+}
@@ -0,0 +1,407 @@
+package sdstore
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// tombstoneSuffix is appended to the name of a file in the upper layer of an
+// OverlayBackend to record that the file has been deleted from the lower layer.
+const tombstoneSuffix = ".deleted"
+
+// OverlayBackend is a Backend combining a read-only lower layer with a writable
+// upper layer.
+//
+// Reads see the merged view of both layers, with files in the upper layer taking
+// precedence. Writes go to the upper layer and deleting a file that exists in the
+// lower layer records a tombstone in the upper layer.
+//
+// Names are composed for the upper layer; the lower layer is accessed with the
+// name relative to Root.
+type OverlayBackend struct {
+	Upper Backend
+	Lower Backend
+	Root  string
+}
+
+// NewOverlayBackend returns an OverlayBackend for the provided layers.
+func NewOverlayBackend(upper Backend, lower Backend, root string) *OverlayBackend {
+	return &OverlayBackend{
+		Upper: upper,
+		Lower: lower,
+		Root:  root,
+	}
+}
+
+// OpenOverlay returns a store that combines the read-only store directory name
+// in base with a writable store in the directory path.
+//
+// Get and Query see the records of both layers, writes are stored in path and
+// deletes of base records are recorded as tombstones. The base is never modified.
+func OpenOverlay(base fs.FS, name string, path string, opts ...StoreOption) (*SDStore, error) {
+	if _, err := fs.Stat(base, name); err != nil {
+		return nil, fmt.Errorf("opening base store: %w", err)
+	}
+
+	overlay := func(s *SDStore) {
+		s.Backend = NewOverlayBackend(s.Backend, FSBackend{FS: base}, s.Path)
+	}
+
+	return New(name, path, append(opts, overlay)...)
+}
+
+// lowerName returns the name of name in the lower layer and false if name
+// is outside of Root.
+func (o *OverlayBackend) lowerName(name string) (string, bool) {
+	rel, err := filepath.Rel(o.Root, name)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
+
+// tombstoned returns true if name has been deleted from the lower layer.
+func (o *OverlayBackend) tombstoned(name string) bool {
+	_, err := o.Upper.Stat(name + tombstoneSuffix)
+	return err == nil
+}
+
+// inLower returns true if name exists in the lower layer and hasn't been deleted.
+func (o *OverlayBackend) inLower(name string) bool {
+	_, err := o.statLower(name)
+	return err == nil
+}
+
+// statLower returns the FileInfo of name in the lower layer.
+func (o *OverlayBackend) statLower(name string) (fs.FileInfo, error) {
+	lower, ok := o.lowerName(name)
+	if !ok || o.tombstoned(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return o.Lower.Stat(lower)
+}
+
+// inUpper returns true if name exists in the upper layer.
+func (o *OverlayBackend) inUpper(name string) bool {
+	_, err := o.Upper.Stat(name)
+	return err == nil
+}
+
+// ReadFile implements the Backend interface for OverlayBackend.
+func (o *OverlayBackend) ReadFile(name string) ([]byte, error) {
+	b, err := o.Upper.ReadFile(name)
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return b, err
+	}
+
+	lower, ok := o.lowerName(name)
+	if !ok || o.tombstoned(name) {
+		return nil, err
+	}
+	return o.Lower.ReadFile(lower)
+}
+
+// WriteFile implements the Backend interface for OverlayBackend.
+func (o *OverlayBackend) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	if err := o.Upper.MkdirAll(filepath.Dir(name), defaultDirPerm); err != nil {
+		return err
+	}
+	if err := o.Upper.WriteFile(name, data, perm); err != nil {
+		return err
+	}
+	if err := o.Upper.Remove(name + tombstoneSuffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Remove implements the Backend interface for OverlayBackend.
+func (o *OverlayBackend) Remove(name string) error {
+	inUpper, inLower := o.inUpper(name), o.inLower(name)
+	if !inUpper && !inLower {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+
+	if inUpper {
+		if err := o.Upper.Remove(name); err != nil {
+			return err
+		}
+	}
+	if inLower {
+		return o.tombstone(name)
+	}
+	return nil
+}
+
+// tombstone records that name has been deleted from the lower layer.
+func (o *OverlayBackend) tombstone(name string) error {
+	if err := o.Upper.MkdirAll(filepath.Dir(name), defaultDirPerm); err != nil {
+		return err
+	}
+	return o.Upper.WriteFile(name+tombstoneSuffix, nil, defaultFilePerm)
+}
+
+// RemoveAll implements the Backend interface for OverlayBackend.
+//
+// Files in the lower layer are tombstoned individually.
+func (o *OverlayBackend) RemoveAll(name string) error {
+	if err := o.Upper.RemoveAll(name); err != nil {
+		return err
+	}
+
+	if !o.inLower(name) {
+		return nil
+	}
+
+	return walkDir(o, name, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		return o.tombstone(path)
+	})
+}
+
+// Rename implements the Backend interface for OverlayBackend.
+//
+// Files are copied to the upper layer, directories can only be renamed if they
+// don't exist in the lower layer.
+func (o *OverlayBackend) Rename(oldname string, newname string) error {
+	info, err := o.Stat(oldname)
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		if o.inLower(oldname) {
+			return &fs.PathError{Op: "rename", Path: oldname, Err: ErrReadOnly}
+		}
+		if err := o.Upper.MkdirAll(filepath.Dir(newname), defaultDirPerm); err != nil {
+			return err
+		}
+		return o.Upper.Rename(oldname, newname)
+	}
+
+	b, err := o.ReadFile(oldname)
+	if err != nil {
+		return err
+	}
+	if err := o.WriteFile(newname, b, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return o.Remove(oldname)
+}
+
+// Stat implements the Backend interface for OverlayBackend.
+func (o *OverlayBackend) Stat(name string) (fs.FileInfo, error) {
+	info, err := o.Upper.Stat(name)
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return info, err
+	}
+
+	if info, lerr := o.statLower(name); lerr == nil {
+		return info, nil
+	}
+	return nil, err
+}
+
+// ReadDir implements the Backend interface for OverlayBackend.
+func (o *OverlayBackend) ReadDir(name string) ([]fs.DirEntry, error) {
+	upper, uerr := o.Upper.ReadDir(name)
+	if uerr != nil && !errors.Is(uerr, fs.ErrNotExist) {
+		return nil, uerr
+	}
+
+	var lower []fs.DirEntry
+	var lerr error = fs.ErrNotExist
+	if l, ok := o.lowerName(name); ok && !o.tombstoned(name) {
+		lower, lerr = o.Lower.ReadDir(l)
+		if lerr != nil && !errors.Is(lerr, fs.ErrNotExist) {
+			return nil, lerr
+		}
+	}
+
+	if uerr != nil && lerr != nil {
+		return nil, uerr
+	}
+
+	seen := make(map[string]bool)
+	var entries []fs.DirEntry
+	for _, e := range upper {
+		if strings.HasSuffix(e.Name(), tombstoneSuffix) {
+			seen[strings.TrimSuffix(e.Name(), tombstoneSuffix)] = true
+			continue
+		}
+		seen[e.Name()] = true
+		entries = append(entries, e)
+	}
+	for _, e := range lower {
+		if !seen[e.Name()] {
+			entries = append(entries, e)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// MkdirAll implements the Backend interface for OverlayBackend.
+func (o *OverlayBackend) MkdirAll(name string, perm fs.FileMode) error {
+	return o.Upper.MkdirAll(name, perm)
+}
+
+// Lock implements the Backend interface for OverlayBackend.
+func (o *OverlayBackend) Lock(name string) (func() error, error) {
+	if err := o.Upper.MkdirAll(filepath.Dir(name), defaultDirPerm); err != nil {
+		return nil, err
+	}
+	return o.Upper.Lock(name)
+}
+
+// loadOverlayIndexes loads and merges the indexes of both layers of o.
+//
+// Entries of every index from the lower layer are kept for records that only
+// exist in the lower layer, entries from the upper layer for records in the
+// upper layer.
+func (c *Collection) loadOverlayIndexes(o *OverlayBackend) error {
+	path := c.filepath(c.Name, true)
+
+	read := func(b Backend, name string) (*indexing, error) {
+		data, err := b.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+
+		var idx indexing
+		if err := c.decode(data, &idx); err != nil {
+			return nil, &CorruptRecordError{Path: path, Err: err}
+		}
+		return &idx, nil
+	}
+
+	// Without an index in the upper layer, the merged view is the lower index.
+	upper, err := read(o.Upper, path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			err = c.read(path, &c.Indexing)
+		}
+		if err != nil {
+			return fmt.Errorf("loading index: %w", err)
+		}
+		return nil
+	}
+
+	var lower *indexing
+	if name, ok := o.lowerName(path); ok {
+		lower, err = read(o.Lower, name)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("loading index: %w", err)
+		}
+	}
+	if lower == nil {
+		c.Indexing = *upper
+		return nil
+	}
+
+	// owner returns the layer holding the record id, nil if it was removed.
+	owner := func(id string) *indexing {
+		p := c.filepath(id, false)
+		switch {
+		case o.inUpper(p):
+			return upper
+		case o.inLower(p):
+			return lower
+		}
+		return nil
+	}
+
+	merged := indexing{
+		Indexes: make(map[string]string),
+		FullText: FullTextIndex{
+			Terms:   make(map[string]map[string]int),
+			Lengths: make(map[string]int),
+		},
+	}
+	for _, layer := range []*indexing{lower, upper} {
+		for k, id := range layer.Indexes {
+			if owner(id) == layer {
+				merged.Indexes[k] = id
+			}
+		}
+
+		for field, targets := range layer.References {
+			if merged.References == nil {
+				merged.References = make(map[string]map[string][]string)
+			}
+			if merged.References[field] == nil {
+				merged.References[field] = make(map[string][]string)
+			}
+			for target, ids := range targets {
+				for _, id := range ids {
+					if owner(id) == layer {
+						merged.References[field][target] = append(merged.References[field][target], id)
+					}
+				}
+			}
+		}
+
+		for term, freqs := range layer.FullText.Terms {
+			for id, n := range freqs {
+				if owner(id) != layer {
+					continue
+				}
+				if merged.FullText.Terms[term] == nil {
+					merged.FullText.Terms[term] = make(map[string]int)
+				}
+				merged.FullText.Terms[term][id] = n
+			}
+		}
+		for id, n := range layer.FullText.Lengths {
+			if owner(id) == layer {
+				merged.FullText.Lengths[id] = n
+			}
+		}
+
+		for k, ids := range layer.MultiKey.Keys {
+			for _, id := range ids {
+				if owner(id) == layer {
+					if merged.MultiKey.Keys == nil {
+						merged.MultiKey.Keys = make(map[string][]string)
+					}
+					merged.MultiKey.Keys[k] = append(merged.MultiKey.Keys[k], id)
+				}
+			}
+		}
+	}
+	for _, ids := range merged.MultiKey.Keys {
+		sort.Strings(ids)
+	}
+
+	// The settings the indexes were built with are those of the upper layer.
+	// Different settings cause the indexes to be recreated.
+	merged.Fields = upper.Fields
+	merged.FullText.Fields = upper.FullText.Fields
+	merged.FullText.Stemmer = upper.FullText.Stemmer
+	merged.MultiKey.Fields = upper.MultiKey.Fields
+	merged.Normalizers = upper.Normalizers
+	merged.Sparse = upper.Sparse
+	merged.Partial = upper.Partial
+	merged.KeyVersion = upper.KeyVersion
+	if !cmp.Equal(upper.Fields, lower.Fields) {
+		merged.Fields = nil
+	}
+	if upper.KeyVersion != lower.KeyVersion ||
+		!cmp.Equal(upper.FullText.Fields, lower.FullText.Fields) || upper.FullText.Stemmer != lower.FullText.Stemmer ||
+		!cmp.Equal(upper.MultiKey.Fields, lower.MultiKey.Fields) || !cmp.Equal(upper.Normalizers, lower.Normalizers) ||
+		!cmp.Equal(upper.Sparse, lower.Sparse) || !cmp.Equal(upper.Partial, lower.Partial) {
+		merged.KeyVersion = 0
+	}
+	c.Indexing = merged
+
+	return nil
+}
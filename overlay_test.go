@@ -0,0 +1,166 @@
+package sdstore_test
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/toqns/sdstore"
+)
+
+func TestOverlay(t *testing.T) {
+	basePath := t.TempDir()
+	base, err := sdstore.New("defaults", basePath, sdstore.WithJSONEncoding())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+	}
+
+	opts := []sdstore.CollectionOption{sdstore.WithIndexedFields("Email")}
+	bc, err := base.Collection("test", Record{}, opts...)
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+	}
+	for _, rec := range []Record{
+		{ID: "1", Name: "One", Email: "one@example.com"},
+		{ID: "2", Name: "Two", Email: "two@example.com"},
+	} {
+		if err := bc.Create(rec.ID, rec); err != nil {
+			t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+		}
+	}
+
+	upperPath := t.TempDir()
+	open := func() *sdstore.Collection {
+		store, err := sdstore.OpenOverlay(os.DirFS(basePath), "defaults", upperPath, sdstore.WithJSONEncoding())
+		if err != nil {
+			t.Fatalf("%s\tShould be able to open an overlay store: %v.", failed, err)
+		}
+		c, err := store.Collection("test", Record{}, opts...)
+		if err != nil {
+			t.Fatalf("%s\tShould be able to open an overlay collection: %v.", failed, err)
+		}
+		return c
+	}
+
+	c := open()
+	t.Logf("%s\tShould be able to open an overlay collection.", success)
+
+	var got Record
+	if err := c.GetIndexed("Email", "two@example.com", &got); err != nil || got.ID != "2" {
+		t.Fatalf("%s\tShould be able to get a base record through the index: %v.", failed, err)
+	}
+	t.Logf("%s\tShould be able to get a base record through the index.", success)
+
+	upd := Record{ID: "1", Name: "Uno", Email: "uno@example.com"}
+	if err := c.Update(upd.ID, upd); err != nil {
+		t.Fatalf("%s\tShould be able to update a base record: %v.", failed, err)
+	}
+	if err := c.Delete("2"); err != nil {
+		t.Fatalf("%s\tShould be able to delete a base record: %v.", failed, err)
+	}
+	rec3 := Record{ID: "3", Name: "Three", Email: "three@example.com"}
+	if err := c.Create(rec3.ID, rec3); err != nil {
+		t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+	}
+	t.Logf("%s\tShould be able to modify the overlay.", success)
+
+	// Reopen to verify that the indexes of both layers are merged.
+	for i, c := range []*sdstore.Collection{c, open()} {
+		res, err := c.Query(func(any) bool { return true })
+		if err != nil {
+			t.Fatalf("%s\tShould be able to query the overlay: %v.", failed, err)
+		}
+		var ids []string
+		for _, r := range res {
+			ids = append(ids, r.(*Record).ID)
+		}
+		sort.Strings(ids)
+		if diff := cmp.Diff(ids, []string{"1", "3"}); diff != "" {
+			t.Fatalf("%s\tShould see the merged records (%d): %v.", failed, i, diff)
+		}
+		t.Logf("%s\tShould see the merged records (%d).", success, i)
+
+		for _, exp := range []Record{upd, rec3} {
+			var got Record
+			if err := c.GetIndexed("Email", exp.Email, &got); err != nil {
+				t.Fatalf("%s\tShould be able to get %q through the index (%d): %v.", failed, exp.ID, i, err)
+			}
+			if diff := cmp.Diff(got, exp); diff != "" {
+				t.Fatalf("%s\tShould get expected result: %v.", failed, diff)
+			}
+		}
+		for _, email := range []string{"one@example.com", "two@example.com"} {
+			if err := c.GetIndexed("Email", email, &got); !errors.Is(err, sdstore.ErrNotFound) {
+				t.Fatalf("%s\tShould not find %q through the index (%d): %v.", failed, email, i, err)
+			}
+		}
+		t.Logf("%s\tShould see the merged indexes (%d).", success, i)
+	}
+
+	// The base must not have been modified.
+	bc2, err := base.Collection("test", Record{}, opts...)
+	if err != nil {
+		t.Fatalf("%s\tShould be able to reopen the base collection: %v.", failed, err)
+	}
+	if err := bc2.GetIndexed("Email", "two@example.com", &got); err != nil || got.Name != "Two" {
+		t.Fatalf("%s\tShould leave the base untouched: %v.", failed, err)
+	}
+	t.Logf("%s\tShould leave the base untouched.", success)
+}
+
+func TestOverlayRecordIndexes(t *testing.T) {
+	basePath := t.TempDir()
+	base, err := sdstore.New("defaults", basePath, sdstore.WithJSONEncoding())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+	}
+
+	opts := []sdstore.CollectionOption{sdstore.WithFullTextIndex("Name"), sdstore.WithMultiKeyIndex("Email")}
+	bc, err := base.Collection("test", Record{}, opts...)
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+	}
+	if err := bc.Create("1", Record{ID: "1", Name: "Alpha", Email: "one@example.com"}); err != nil {
+		t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+	}
+
+	// Two overlay stores on the same upper layer stand in for two processes.
+	upperPath := t.TempDir()
+	var cs []*sdstore.Collection
+	for i := 0; i < 2; i++ {
+		store, err := sdstore.OpenOverlay(os.DirFS(basePath), "defaults", upperPath, sdstore.WithJSONEncoding())
+		if err != nil {
+			t.Fatalf("%s\tShould be able to open an overlay store: %v.", failed, err)
+		}
+		c, err := store.Collection("test", Record{}, opts...)
+		if err != nil {
+			t.Fatalf("%s\tShould be able to open an overlay collection: %v.", failed, err)
+		}
+		cs = append(cs, c)
+	}
+
+	if err := cs[0].Create("2", Record{ID: "2", Name: "Beta", Email: "two@example.com"}); err != nil {
+		t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+	}
+	if err := cs[1].Create("3", Record{ID: "3", Name: "Gamma", Email: "three@example.com"}); err != nil {
+		t.Fatalf("%s\tShould be able to create a record with another handle: %v.", failed, err)
+	}
+
+	for _, tc := range []struct{ id, name, email string }{
+		{"1", "alpha", "one@example.com"},
+		{"2", "beta", "two@example.com"},
+		{"3", "gamma", "three@example.com"},
+	} {
+		res, err := cs[1].Search(tc.name, 0)
+		if err != nil || len(res) != 1 || res[0].ID != tc.id {
+			t.Fatalf("%s\tShould find record %s through the merged full-text index: %+v, %v.", failed, tc.id, res, err)
+		}
+		recs, err := cs[1].FindBy("Email", tc.email)
+		if err != nil || len(recs) != 1 || recs[0].(*Record).ID != tc.id {
+			t.Fatalf("%s\tShould find record %s through the merged multi-key index: %v, %v.", failed, tc.id, recs, err)
+		}
+	}
+	t.Logf("%s\tShould merge the full-text and multi-key indexes of both layers.", success)
+}
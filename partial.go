@@ -0,0 +1,85 @@
+package sdstore
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// WithSparseIndex is an option to leave records out of the unique indexes of
+// the provided fields while the field has its zero value, such as an empty
+// string, so any number of records may leave it empty. Nil pointers are never
+// indexed. The fields must be indexed.
+func WithSparseIndex(fields ...string) CollectionOption {
+	return func(c *Collection) {
+		c.sparse = append(c.sparse, fields...)
+	}
+}
+
+// WithPartialIndex is an option to only add the records for which f returns
+// true to the unique index of field, so uniqueness only applies to those
+// records, such as the records that aren't deleted. f is called with a pointer
+// to the record. The field must be indexed.
+//
+// The index is rebuilt when the partially indexed fields change, but not when
+// f changes. Reopen the collection without the option first to rebuild the
+// index after changing f.
+func WithPartialIndex(field string, f func(r any) bool) CollectionOption {
+	return func(c *Collection) {
+		if c.partial == nil {
+			c.partial = make(map[string]func(any) bool)
+		}
+		c.partial[field] = f
+	}
+}
+
+// partialFields returns the sorted fields of the partial indexes of c.
+func (c *Collection) partialFields() []string {
+	fields := make([]string, 0, len(c.partial))
+	for field := range c.partial {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// sparseIndexed returns true if the index was built with the sparse and
+// partial fields of c.
+func (c *Collection) sparseIndexed() bool {
+	sparse := append([]string(nil), c.sparse...)
+	sort.Strings(sparse)
+	return strings.Join(sparse, "\x00") == strings.Join(c.Indexing.Sparse, "\x00") &&
+		strings.Join(c.partialFields(), "\x00") == strings.Join(c.Indexing.Partial, "\x00")
+}
+
+// resetSparse records the sparse and partial fields of c in the index before
+// it is rebuilt.
+func (c *Collection) resetSparse() {
+	c.Indexing.Sparse = nil
+	if len(c.sparse) > 0 {
+		c.Indexing.Sparse = append([]string(nil), c.sparse...)
+		sort.Strings(c.Indexing.Sparse)
+	}
+
+	c.Indexing.Partial = nil
+	if len(c.partial) > 0 {
+		c.Indexing.Partial = c.partialFields()
+	}
+}
+
+// sparseZero returns true if field has a sparse index and v is its zero value.
+func (c *Collection) sparseZero(field string, v any) bool {
+	return contains(c.Indexing.Sparse, field) && reflect.ValueOf(v).IsZero()
+}
+
+// skipIndex returns true if rec is left out of the unique index of field, which
+// has the value v in rec.
+func (c *Collection) skipIndex(rec any, field string, v any) bool {
+	if v == nil || c.sparseZero(field, v) {
+		return true
+	}
+	if f, ok := c.partial[field]; ok && !f(rec) {
+		return true
+	}
+	return false
+}
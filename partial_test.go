@@ -0,0 +1,105 @@
+package sdstore_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/toqns/sdstore"
+)
+
+type Member struct {
+	ID      string
+	Email   string
+	Phone   *string
+	Deleted bool
+}
+
+func TestSparseAndPartialIndexes(t *testing.T) {
+	store, err := sdstore.New("defaults", t.TempDir())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+	}
+
+	active := func(r any) bool { return !r.(*Member).Deleted }
+	opts := []sdstore.CollectionOption{
+		sdstore.WithIndexedFields("Email", "Phone"),
+		sdstore.WithSparseIndex("Email"),
+		sdstore.WithPartialIndex("Email", active),
+	}
+
+	if _, err := store.Collection("members", Member{}, sdstore.WithSparseIndex("Email")); err == nil {
+		t.Fatalf("%s\tShould not be able to use a sparse index on a field that isn't indexed.", failed)
+	}
+	t.Logf("%s\tShould not be able to use a sparse index on a field that isn't indexed.", success)
+
+	c, err := store.Collection("members", Member{}, opts...)
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+	}
+
+	for _, m := range []Member{{ID: "1"}, {ID: "2"}} {
+		if err := c.Create(m.ID, m); err != nil {
+			t.Fatalf("%s\tShould be able to create record %s without an email: %v.", failed, m.ID, err)
+		}
+	}
+	t.Logf("%s\tShould be able to create records without values.", success)
+
+	if err := c.Create("3", Member{ID: "3", Email: "ann@example.com", Deleted: true}); err != nil {
+		t.Fatalf("%s\tShould be able to create a deleted record: %v.", failed, err)
+	}
+	if err := c.Create("4", Member{ID: "4", Email: "ann@example.com"}); err != nil {
+		t.Fatalf("%s\tShould be able to reuse the email of a deleted record: %v.", failed, err)
+	}
+	var niue *sdstore.IndexedValueNotUniqueError
+	if err := c.Create("5", Member{ID: "5", Email: "ann@example.com"}); !errors.As(err, &niue) {
+		t.Fatalf("%s\tShould not be able to reuse the email of an active record: %v.", failed, err)
+	}
+	t.Logf("%s\tShould only apply uniqueness to active records.", success)
+
+	var got Member
+	if err := c.GetIndexed("Email", "ann@example.com", &got); err != nil || got.ID != "4" {
+		t.Fatalf("%s\tShould find the active record: got %q, %v.", failed, got.ID, err)
+	}
+	if err := c.GetIndexed("Email", "", &got); !errors.Is(err, sdstore.ErrNotFound) {
+		t.Fatalf("%s\tShould not find records by an empty value: %v.", failed, err)
+	}
+	t.Logf("%s\tShould find records through sparse and partial indexes.", success)
+
+	// Deleting the active record frees its email.
+	if err := c.Update("4", Member{ID: "4", Email: "ann@example.com", Deleted: true}); err != nil {
+		t.Fatalf("%s\tShould be able to update a record: %v.", failed, err)
+	}
+	if err := c.Create("5", Member{ID: "5", Email: "ann@example.com"}); err != nil {
+		t.Fatalf("%s\tShould be able to reuse the email of a record deleted by an update: %v.", failed, err)
+	}
+	t.Logf("%s\tShould maintain partial indexes on updates.", success)
+
+	// Record 4 is deleted, so restoring it would take the email of record 5.
+	if err := c.Update("4", Member{ID: "4", Email: "ann@example.com"}); !errors.As(err, &niue) {
+		t.Fatalf("%s\tShould not be able to update a record into the partial index with a duplicate: %v.", failed, err)
+	}
+	if err := c.GetIndexed("Email", "ann@example.com", &got); err != nil || got.ID != "5" {
+		t.Fatalf("%s\tShould keep the value indexed for its record: got %q, %v.", failed, got.ID, err)
+	}
+	t.Logf("%s\tShould not be able to update a record into the partial index with a duplicate.", success)
+
+	report, err := store.Verify(context.Background())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to verify the store: %v.", failed, err)
+	}
+	if !report.OK() {
+		t.Fatalf("%s\tShould report no problems: %+v.", failed, report)
+	}
+	t.Logf("%s\tShould verify sparse and partial indexes.", success)
+
+	// Reopen to load the index from disk.
+	c, err = store.Collection("members", Member{}, opts...)
+	if err != nil {
+		t.Fatalf("%s\tShould be able to reopen the collection: %v.", failed, err)
+	}
+	if err := c.GetIndexed("Email", "ann@example.com", &got); err != nil || got.ID != "5" {
+		t.Fatalf("%s\tShould find the active record after reopening: got %q, %v.", failed, got.ID, err)
+	}
+	t.Logf("%s\tShould load sparse and partial indexes.", success)
+}
@@ -0,0 +1,676 @@
+package sdstore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrReferenceNotFound is an error returned when a user attempts to create or
+	// update a record referencing a record that doesn't exist.
+	ErrReferenceNotFound = errors.New("referenced record not found")
+
+	// ErrReferenced is an error returned when a user attempts to delete a record
+	// that is referenced by a record with the Restrict delete rule.
+	ErrReferenced = errors.New("record is referenced")
+
+	// ErrReferenceCycle is an error returned when a collection is opened with
+	// references that form a cycle between collections.
+	ErrReferenceCycle = errors.New("references form a cycle")
+)
+
+// DeleteChange is a change made by a delete to a record of a collection.
+type DeleteChange struct {
+	Collection string
+	ID         string
+
+	// Field is the reference set to its zero value, or empty if the record
+	// was deleted.
+	Field string
+}
+
+// PartialDeleteError is an error returned by Delete when it fails after some of
+// the changes to the records referencing the deleted record have been made.
+// Applied lists those changes in order, which aren't rolled back.
+type PartialDeleteError struct {
+	Applied []DeleteChange
+	Err     error
+}
+
+// Error implements the Error interface for PartialDeleteError.
+func (err *PartialDeleteError) Error() string {
+	return fmt.Sprintf("delete failed after %d changes: %v", len(err.Applied), err.Err)
+}
+
+// Unwrap returns the underlying error.
+func (err *PartialDeleteError) Unwrap() error {
+	return err.Err
+}
+
+// OnDelete is the rule applied to the records referencing a deleted record.
+type OnDelete int
+
+// Delete rules.
+const (
+	// Restrict prevents referenced records from being deleted.
+	Restrict OnDelete = iota
+
+	// Cascade deletes the referencing records with the referenced record.
+	Cascade
+
+	// SetNull sets the reference of the referencing records to its zero value.
+	SetNull
+)
+
+// reference is a field of the records of a Collection referencing the records
+// of the target collection.
+type reference struct {
+	field    string
+	target   *Collection
+	onDelete OnDelete
+}
+
+// referrer is a reference to a Collection from the records of another collection.
+type referrer struct {
+	from     *Collection
+	field    string
+	onDelete OnDelete
+}
+
+// WithReference is an option to declare that field holds the id of a record in
+// the target collection. The referenced record has to exist when a record is
+// created or updated, and onDelete determines what happens to the referencing
+// records when the referenced record is deleted. An empty or nil field doesn't
+// reference a record.
+//
+// The referencing records are found through a reverse index. Deletes take the
+// locks of the referencing collections, so references must not form a cycle
+// between collections and Init fails with ErrReferenceCycle if they would;
+// references to the same collection are allowed.
+//
+// References are recorded in the store manifest, so the delete rules also apply
+// when the referencing collection isn't open. It is then opened without its
+// record type to apply them, and its hooks don't run.
+func WithReference(field string, target *Collection, onDelete OnDelete) CollectionOption {
+	return func(c *Collection) {
+		c.references = append(c.references, reference{field: field, target: target, onDelete: onDelete})
+	}
+}
+
+// initReferences registers the references of c with the store, by the path of
+// their target collection. A reopened collection replaces the registration of
+// the collection it reopens.
+func (c *Collection) initReferences() error {
+	for _, ref := range c.references {
+		if ref.target == nil || !ref.target.initialized {
+			return fmt.Errorf("reference %s: %w", ref.field, ErrNotInitialized)
+		}
+		if c.store == nil || ref.target.store != c.store {
+			return fmt.Errorf("reference %s: target collection %s belongs to another store", ref.field, ref.target.Name)
+		}
+	}
+	if len(c.references) == 0 {
+		return nil
+	}
+
+	graph, err := c.store.referenceGraph(c.Name)
+	if err != nil {
+		return err
+	}
+	for _, ref := range c.references {
+		if ref.target.Name == c.Name {
+			continue
+		}
+		if path := graph.path(ref.target.Name, c.Name); path != nil {
+			return fmt.Errorf("reference %s: %w: %s -> %s", ref.field, ErrReferenceCycle, c.Name, strings.Join(path, " -> "))
+		}
+	}
+
+	for _, ref := range c.references {
+
+		s, target := c.store, ref.target.fullpath()
+		s.refMu.Lock()
+		if s.referrers == nil {
+			s.referrers = make(map[string][]referrer)
+		}
+		referrers := s.referrers[target][:0:0]
+		for _, r := range s.referrers[target] {
+			if r.field != ref.field || r.from.fullpath() != c.fullpath() {
+				referrers = append(referrers, r)
+			}
+		}
+		s.referrers[target] = append(referrers, referrer{from: c, field: ref.field, onDelete: ref.onDelete})
+		s.refMu.Unlock()
+	}
+
+	return nil
+}
+
+// referenceGraph maps collections to the collections they reference, other
+// than themselves.
+type referenceGraph map[string][]string
+
+// referenceGraph returns the references between the collections of the store
+// recorded in the store manifest and registered by open collections, leaving
+// out those of the collection name.
+func (s *SDStore) referenceGraph(name string) (referenceGraph, error) {
+	m, err := s.loadManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	graph := make(referenceGraph)
+	add := func(from string, to string) {
+		if from != name && from != to && !contains(graph[from], to) {
+			graph[from] = append(graph[from], to)
+		}
+	}
+	for from, cm := range m.Collections {
+		for _, rm := range cm.References {
+			add(from, rm.Target)
+		}
+	}
+
+	s.refMu.Lock()
+	defer s.refMu.Unlock()
+
+	for _, refs := range s.referrers {
+		for _, r := range refs {
+			for _, ref := range r.from.references {
+				if ref.target != nil {
+					add(r.from.Name, ref.target.Name)
+				}
+			}
+		}
+	}
+
+	return graph, nil
+}
+
+// path returns the collections on a path of references from from to to, or
+// nil if to can't be reached.
+func (g referenceGraph) path(from string, to string) []string {
+	seen := make(map[string]bool)
+	var walk func(name string) []string
+	walk = func(name string) []string {
+		if name == to {
+			return []string{name}
+		}
+		if seen[name] {
+			return nil
+		}
+		seen[name] = true
+
+		for _, next := range g[name] {
+			if path := walk(next); path != nil {
+				return append([]string{name}, path...)
+			}
+		}
+		return nil
+	}
+	return walk(from)
+}
+
+// referrers returns the references to c registered with the store and those
+// recorded in the store manifest, opening the referencing collections that
+// aren't open with plan.
+func (c *Collection) referrers(plan *deletePlan) ([]referrer, error) {
+	s := c.store
+	if s == nil {
+		return nil, nil
+	}
+
+	s.refMu.Lock()
+	refs := append([]referrer(nil), s.referrers[c.fullpath()]...)
+	s.refMu.Unlock()
+
+	m, err := s.loadManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(m.Collections))
+	for name := range m.Collections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		cm := m.Collections[name]
+		for _, rm := range cm.References {
+			if rm.Target != c.Name || registered(refs, name, rm.Field) {
+				continue
+			}
+
+			from, err := plan.open(s, name, cm.References)
+			if err != nil {
+				return nil, fmt.Errorf("opening referencing collection %s: %w", name, err)
+			}
+			refs = append(refs, referrer{from: from, field: rm.Field, onDelete: rm.OnDelete})
+		}
+	}
+
+	return refs, nil
+}
+
+// registered returns true if refs holds the reference of field of the
+// collection name.
+func registered(refs []referrer, name string, field string) bool {
+	for _, r := range refs {
+		if r.from.Name == name && r.field == field {
+			return true
+		}
+	}
+	return false
+}
+
+// referrerCollection returns the collection name with the references refs,
+// which isn't open, to apply the delete rules of its references. Its records
+// are decoded without knowledge of their type.
+func (s *SDStore) referrerCollection(name string, refs []referenceManifest) (*Collection, error) {
+	c, err := s.genericCollection(name)
+	if err != nil {
+		return nil, err
+	}
+	c.readOnly = s.readOnly
+	c.store = s
+
+	// Write the index like it was written.
+	if b, err := c.load(c.filepath(c.Name, true)); err == nil {
+		c.Checksums = bytes.HasPrefix(b, []byte(checksumMagic))
+	}
+	if err := c.loadIndexes(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	for _, rm := range refs {
+		c.references = append(c.references, reference{field: rm.Field, onDelete: rm.OnDelete})
+	}
+
+	return c, nil
+}
+
+// referencesIndexed returns true if the reverse index holds exactly the
+// references of c.
+func (c *Collection) referencesIndexed() bool {
+	if len(c.Indexing.References) != len(c.references) {
+		return false
+	}
+	for _, ref := range c.references {
+		if _, ok := c.Indexing.References[ref.field]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// refID returns the id of the record referenced by field of rec, empty if
+// field is empty or nil.
+func refID(rec any, field string) string {
+	val := getFieldValue(rec, field)
+	if m, ok := rec.(*map[string]any); ok {
+		val, _ = genericFieldValue(*m, field)
+	}
+	if val == nil {
+		return ""
+	}
+
+	v := reflect.ValueOf(val)
+	if v.Kind() == reflect.String {
+		return v.String()
+	}
+	if v.IsZero() {
+		return ""
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// checkReferences returns an error if a record referenced by rec doesn't exist.
+func (c *Collection) checkReferences(rec any) error {
+	for _, ref := range c.references {
+		id := refID(rec, ref.field)
+		if id == "" {
+			continue
+		}
+		if validateID(id) != nil || !ref.target.exists(id) {
+			return fmt.Errorf("%w: %s %q in %s", ErrReferenceNotFound, ref.field, id, ref.target.Name)
+		}
+	}
+	return nil
+}
+
+// indexReferences replaces the references of the old record id in the reverse
+// index by those of rec. old or rec is nil if the record is created or deleted.
+func (c *Collection) indexReferences(id string, old any, rec any) {
+	for _, ref := range c.references {
+		if old != nil {
+			c.removeReference(ref.field, refID(old, ref.field), id)
+		}
+		if rec != nil {
+			c.addReference(ref.field, refID(rec, ref.field), id)
+		}
+	}
+}
+
+// addReference adds the record id referencing target to the reverse index.
+func (c *Collection) addReference(field string, target string, id string) {
+	if target == "" {
+		return
+	}
+	if c.Indexing.References == nil {
+		c.Indexing.References = make(map[string]map[string][]string)
+	}
+	if c.Indexing.References[field] == nil {
+		c.Indexing.References[field] = make(map[string][]string)
+	}
+	c.Indexing.References[field][target] = append(c.Indexing.References[field][target], id)
+}
+
+// removeReference removes the record id referencing target from the reverse index.
+func (c *Collection) removeReference(field string, target string, id string) {
+	ids := c.Indexing.References[field][target]
+	for i, v := range ids {
+		if v == id {
+			ids = append(ids[:i:i], ids[i+1:]...)
+			break
+		}
+	}
+
+	if len(ids) == 0 {
+		delete(c.Indexing.References[field], target)
+		return
+	}
+	c.Indexing.References[field][target] = ids
+}
+
+// dependants returns the ids of the records of c referencing target through field.
+func (c *Collection) dependants(field string, target string) []string {
+	ids := append([]string(nil), c.Indexing.References[field][target]...)
+	sort.Strings(ids)
+	return ids
+}
+
+// resetReferences clears the reverse index before it is rebuilt.
+func (c *Collection) resetReferences() {
+	c.Indexing.References = nil
+	if len(c.references) == 0 {
+		return
+	}
+
+	c.Indexing.References = make(map[string]map[string][]string)
+	for _, ref := range c.references {
+		c.Indexing.References[ref.field] = make(map[string][]string)
+	}
+}
+
+// refLocks holds the locks of the collections taking part in a delete.
+type refLocks map[*Collection]func() error
+
+// lock locks c unless it is locked already.
+func (l refLocks) lock(c *Collection) error {
+	if _, ok := l[c]; ok {
+		return nil
+	}
+
+	c.mu.Lock()
+	unlock, err := c.lock()
+	if err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	l[c] = unlock
+
+	return nil
+}
+
+// unlock releases the locks taken by lock.
+func (l refLocks) unlock() {
+	for c, unlock := range l {
+		if unlock == nil {
+			continue
+		}
+		unlock()
+		c.mu.Unlock()
+	}
+}
+
+// recordRef identifies a record of a collection.
+type recordRef struct {
+	c  *Collection
+	id string
+}
+
+// deletePlan holds the changes of a delete. Records are deleted in order,
+// dependants before the records they reference.
+type deletePlan struct {
+	deletes []recordRef
+	nulls   []nullRef
+	planned map[recordRef]bool
+
+	// opened holds the referencing collections opened for the delete by name.
+	opened map[string]*Collection
+}
+
+// open returns the collection name with the references refs, which isn't open,
+// opening it once per plan.
+func (plan *deletePlan) open(s *SDStore, name string, refs []referenceManifest) (*Collection, error) {
+	if c, ok := plan.opened[name]; ok {
+		return c, nil
+	}
+
+	c, err := s.referrerCollection(name, refs)
+	if err != nil {
+		return nil, err
+	}
+	if plan.opened == nil {
+		plan.opened = make(map[string]*Collection)
+	}
+	plan.opened[name] = c
+
+	return c, nil
+}
+
+// nullRef is a reference of a record to set to its zero value.
+type nullRef struct {
+	recordRef
+	field string
+}
+
+// planDelete adds the delete of record id and the changes to its dependants
+// to plan, locking the collections of the dependants. ErrReferenced is
+// returned if a dependant restricts the delete.
+func (c *Collection) planDelete(id string, locks refLocks, plan *deletePlan) error {
+	if plan.planned == nil {
+		plan.planned = make(map[recordRef]bool)
+	}
+	self := recordRef{c: c, id: id}
+	if plan.planned[self] {
+		return nil
+	}
+	plan.planned[self] = true
+
+	referrers, err := c.referrers(plan)
+	if err != nil {
+		return err
+	}
+	for _, r := range referrers {
+		if err := locks.lock(r.from); err != nil {
+			return err
+		}
+
+		for _, dep := range r.from.dependants(r.field, id) {
+			ref := recordRef{c: r.from, id: dep}
+			if plan.planned[ref] {
+				continue
+			}
+
+			switch r.onDelete {
+			case Restrict:
+				return fmt.Errorf("%w by %s %q", ErrReferenced, r.from.Name, dep)
+			case Cascade:
+				if err := r.from.planDelete(dep, locks, plan); err != nil {
+					return err
+				}
+			case SetNull:
+				plan.nulls = append(plan.nulls, nullRef{ref, r.field})
+			}
+		}
+	}
+
+	plan.deletes = append(plan.deletes, self)
+	return nil
+}
+
+// apply applies the changes of plan. If a change fails after others have been
+// made, the error is a PartialDeleteError listing the changes made.
+func (plan *deletePlan) apply() error {
+	// Fail before any change is made if one of the collections is read-only.
+	for _, d := range plan.deletes {
+		if d.c.readOnly {
+			return ErrReadOnly
+		}
+	}
+	for _, n := range plan.nulls {
+		if n.c.readOnly {
+			return ErrReadOnly
+		}
+	}
+
+	var applied []DeleteChange
+	partial := func(err error) error {
+		if len(applied) == 0 {
+			return err
+		}
+		return &PartialDeleteError{Applied: applied, Err: err}
+	}
+
+	for _, n := range plan.nulls {
+		if plan.planned[n.recordRef] {
+			continue
+		}
+		if err := n.c.setNull(n.id, n.field); err != nil {
+			return partial(err)
+		}
+		applied = append(applied, DeleteChange{Collection: n.c.Name, ID: n.id, Field: n.field})
+	}
+
+	for _, d := range plan.deletes {
+		if err := d.c.remove(d.id); err != nil {
+			return partial(err)
+		}
+		applied = append(applied, DeleteChange{Collection: d.c.Name, ID: d.id})
+	}
+
+	return nil
+}
+
+// setNull sets field of record id to its zero value, updating the record like
+// Update. The Collection must be locked.
+func (c *Collection) setNull(id string, field string) error {
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	if c.record == nil {
+		return c.setNullGeneric(id, field)
+	}
+
+	// Read the record twice, as the old record is needed to update the indexes.
+	path := c.filepath(id, false)
+	old := reflect.New(c.record).Interface()
+	rec := reflect.New(c.record).Interface()
+	for _, dest := range []any{old, rec} {
+		if err := c.read(path, dest); err != nil {
+			return fmt.Errorf("loading record: %w", err)
+		}
+	}
+
+	v := fieldByPath(reflect.ValueOf(rec), field)
+	if !v.CanSet() {
+		return fmt.Errorf("record %q: field %s can't be set", id, field)
+	}
+	v.Set(reflect.Zero(v.Type()))
+
+	return c.update("", id, old, rec)
+}
+
+// setNullGeneric sets field of record id to null in a Collection opened without
+// its record type. The record keeps its schema version and metadata. The
+// Collection must be locked.
+func (c *Collection) setNullGeneric(id string, field string) error {
+	path := c.filepath(id, false)
+	b, err := c.load(path)
+	if err != nil {
+		return fmt.Errorf("loading record: %w", err)
+	}
+	meta, v, b, err := c.unpackMeta(b)
+	if err != nil {
+		return &CorruptRecordError{ID: id, Path: path, Err: err}
+	}
+	rec, err := c.decodeMap(b)
+	if err != nil {
+		return &CorruptRecordError{ID: id, Path: path, Err: err}
+	}
+
+	old := refID(&rec, field)
+	setGenericField(rec, field, nil)
+	if meta != nil {
+		meta = nextMeta(meta, "")
+	}
+
+	if b, err = c.Encoder.Encode(rec); err != nil {
+		return fmt.Errorf("encoding record %q: %w", id, err)
+	}
+	if b, err = c.pack(addMeta(addSchemaVersion(b, v), meta)); err != nil {
+		return fmt.Errorf("encoding record %q: %w", id, err)
+	}
+	if err := c.save(path, b); err != nil {
+		return fmt.Errorf("saving record %q: %w", id, err)
+	}
+
+	// Remove the keys of the field of the record from the indexes.
+	for k, owner := range c.Indexing.Indexes {
+		if f, _ := splitKey(k); f == field && owner == id {
+			delete(c.Indexing.Indexes, k)
+		}
+	}
+	for k := range c.Indexing.MultiKey.Keys {
+		if f, _ := splitKey(k); f == field {
+			c.removeMultiKey(k, id)
+		}
+	}
+	c.removeReference(field, old, id)
+	if err := c.saveIndexes(); err != nil {
+		return fmt.Errorf("saving indexes: %w", err)
+	}
+
+	return nil
+}
+
+// setGenericField sets field, which may be a dotted path, of a record decoded
+// without knowledge of its type to v, if the field exists.
+func setGenericField(rec map[string]any, field string, v any) {
+	var cur any = rec
+	names := strings.Split(field, ".")
+	for i, name := range names {
+		last := i == len(names)-1
+		switch m := cur.(type) {
+		case map[string]any:
+			if _, ok := m[name]; ok && last {
+				m[name] = v
+			}
+			cur = m[name]
+		case map[any]any:
+			if _, ok := m[name]; ok && last {
+				m[name] = v
+			}
+			cur = m[name]
+		default:
+			return
+		}
+	}
+}
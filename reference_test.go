@@ -0,0 +1,298 @@
+package sdstore_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/toqns/sdstore"
+)
+
+// Ref is a record referencing a record in another collection.
+type Ref struct {
+	ID    string
+	RefID string
+}
+
+func TestReferences(t *testing.T) {
+	store, err := sdstore.New("defaults", t.TempDir())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+	}
+
+	customers, err := store.Collection("customers", Record{})
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+	}
+	for _, id := range []string{"1", "2", "3"} {
+		if err := customers.Create(id, Record{ID: id}); err != nil {
+			t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+		}
+	}
+
+	collection := func(name string, target *sdstore.Collection, onDelete sdstore.OnDelete) *sdstore.Collection {
+		c, err := store.Collection(name, Ref{}, sdstore.WithReference("RefID", target, onDelete))
+		if err != nil {
+			t.Fatalf("%s\tShould be able to create a collection with references: %v.", failed, err)
+		}
+		return c
+	}
+	orders := collection("orders", customers, sdstore.Restrict)
+	invoices := collection("invoices", customers, sdstore.Cascade)
+	reminders := collection("reminders", invoices, sdstore.Cascade)
+	tickets := collection("tickets", customers, sdstore.SetNull)
+
+	if err := orders.Create("o1", Ref{ID: "o1", RefID: "4"}); !errors.Is(err, sdstore.ErrReferenceNotFound) {
+		t.Fatalf("%s\tShould not be able to reference a missing record: %v.", failed, err)
+	}
+	t.Logf("%s\tShould not be able to reference a missing record.", success)
+
+	for _, rec := range []struct {
+		c   *sdstore.Collection
+		ref Ref
+	}{
+		{orders, Ref{ID: "o1", RefID: "1"}},
+		{invoices, Ref{ID: "i1", RefID: "2"}},
+		{reminders, Ref{ID: "r1", RefID: "i1"}},
+		{tickets, Ref{ID: "t1", RefID: "3"}},
+		{tickets, Ref{ID: "t2"}},
+	} {
+		if err := rec.c.Create(rec.ref.ID, rec.ref); err != nil {
+			t.Fatalf("%s\tShould be able to create a record with a reference: %v.", failed, err)
+		}
+	}
+
+	// Reopen the referenced collection to verify that the references are kept.
+	customers, err = store.Collection("customers", Record{})
+	if err != nil {
+		t.Fatalf("%s\tShould be able to reopen a collection: %v.", failed, err)
+	}
+
+	if err := customers.Delete("1"); !errors.Is(err, sdstore.ErrReferenced) {
+		t.Fatalf("%s\tShould not be able to delete a restricted record: %v.", failed, err)
+	}
+	if err := orders.Update("o1", Ref{ID: "o1", RefID: "3"}); err != nil {
+		t.Fatalf("%s\tShould be able to update a reference: %v.", failed, err)
+	}
+	if err := customers.Delete("1"); err != nil {
+		t.Fatalf("%s\tShould be able to delete a record that is no longer referenced: %v.", failed, err)
+	}
+	t.Logf("%s\tShould restrict the delete of referenced records.", success)
+
+	if err := customers.Delete("2"); err != nil {
+		t.Fatalf("%s\tShould be able to delete a record with cascading references: %v.", failed, err)
+	}
+	var ref Ref
+	if err := invoices.Get("i1", &ref); !errors.Is(err, sdstore.ErrNotFound) {
+		t.Fatalf("%s\tShould delete referencing records: %v.", failed, err)
+	}
+	if err := reminders.Get("r1", &ref); !errors.Is(err, sdstore.ErrNotFound) {
+		t.Fatalf("%s\tShould cascade the delete of referencing records: %v.", failed, err)
+	}
+	t.Logf("%s\tShould cascade the delete of referenced records.", success)
+
+	// Reopen the referencing collection to verify that the reverse index is kept.
+	orders, err = store.Collection("orders", Ref{}, sdstore.WithReference("RefID", customers, sdstore.Restrict))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to reopen a collection with references: %v.", failed, err)
+	}
+	if err := customers.Delete("3"); !errors.Is(err, sdstore.ErrReferenced) {
+		t.Fatalf("%s\tShould restrict the delete after reopening: %v.", failed, err)
+	}
+	if err := orders.Delete("o1"); err != nil {
+		t.Fatalf("%s\tShould be able to delete a referencing record: %v.", failed, err)
+	}
+	if err := customers.Delete("3"); err != nil {
+		t.Fatalf("%s\tShould be able to delete a record with nullable references: %v.", failed, err)
+	}
+	if err := tickets.Get("t1", &ref); err != nil || ref.RefID != "" {
+		t.Fatalf("%s\tShould clear the references to deleted records: got %+v, %v.", failed, ref, err)
+	}
+	t.Logf("%s\tShould clear the references to deleted records.", success)
+}
+
+func TestReferencesNotOpen(t *testing.T) {
+	path := t.TempDir()
+	store, err := sdstore.New("defaults", path)
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+	}
+
+	customers, err := store.Collection("customers", Record{})
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+	}
+	for _, id := range []string{"1", "2", "3", "4"} {
+		if err := customers.Create(id, Record{ID: id}); err != nil {
+			t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+		}
+	}
+
+	var updates int
+	opts := map[string][]sdstore.CollectionOption{
+		"orders":   {sdstore.WithReference("RefID", customers, sdstore.Restrict)},
+		"invoices": {sdstore.WithReference("RefID", customers, sdstore.Cascade)},
+		"tickets": {
+			sdstore.WithReference("RefID", customers, sdstore.SetNull),
+			sdstore.WithIndexedFields("RefID"),
+			sdstore.WithAfterUpdate(func(string, any) error {
+				updates++
+				return nil
+			}),
+		},
+	}
+	refs := map[string]Ref{
+		"orders":   {ID: "o1", RefID: "1"},
+		"invoices": {ID: "i1", RefID: "2"},
+		"tickets":  {ID: "t1", RefID: "3"},
+	}
+	for name, ref := range refs {
+		c, err := store.Collection(name, Ref{}, opts[name]...)
+		if err != nil {
+			t.Fatalf("%s\tShould be able to create a collection with references: %v.", failed, err)
+		}
+		if err := c.Create(ref.ID, ref); err != nil {
+			t.Fatalf("%s\tShould be able to create a record with a reference: %v.", failed, err)
+		}
+	}
+	tickets, err := store.Collection("tickets", Ref{}, opts["tickets"]...)
+	if err != nil {
+		t.Fatalf("%s\tShould be able to reopen a collection with references: %v.", failed, err)
+	}
+	if err := tickets.Create("t2", Ref{ID: "t2", RefID: "4"}); err != nil {
+		t.Fatalf("%s\tShould be able to create a record with a reference: %v.", failed, err)
+	}
+
+	if err := customers.Delete("3"); err != nil {
+		t.Fatalf("%s\tShould be able to delete a record with nullable references: %v.", failed, err)
+	}
+	var ref Ref
+	if err := tickets.GetIndexed("RefID", "3", &ref); !errors.Is(err, sdstore.ErrNotFound) {
+		t.Fatalf("%s\tShould update the indexes of cleared references: %v.", failed, err)
+	}
+	if updates != 1 {
+		t.Fatalf("%s\tShould run the update hooks of cleared references: got %d.", failed, updates)
+	}
+	t.Logf("%s\tShould clear references like updates.", success)
+
+	// Reopen the store with only the referenced collection.
+	store, err = sdstore.New("defaults", path)
+	if err != nil {
+		t.Fatalf("%s\tShould be able to reopen the store: %v.", failed, err)
+	}
+	customers, err = store.Collection("customers", Record{})
+	if err != nil {
+		t.Fatalf("%s\tShould be able to reopen a collection: %v.", failed, err)
+	}
+
+	if err := customers.Delete("1"); !errors.Is(err, sdstore.ErrReferenced) {
+		t.Fatalf("%s\tShould restrict the delete when the referencing collection isn't open: %v.", failed, err)
+	}
+	if err := customers.Delete("2"); err != nil {
+		t.Fatalf("%s\tShould be able to delete a record with cascading references: %v.", failed, err)
+	}
+	if err := customers.Delete("4"); err != nil {
+		t.Fatalf("%s\tShould be able to delete a record with nullable references: %v.", failed, err)
+	}
+
+	invoices, err := store.Collection("invoices", Ref{}, sdstore.WithReference("RefID", customers, sdstore.Cascade))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to reopen a collection with references: %v.", failed, err)
+	}
+	if err := invoices.Get("i1", &ref); !errors.Is(err, sdstore.ErrNotFound) {
+		t.Fatalf("%s\tShould cascade the delete when the referencing collection isn't open: %v.", failed, err)
+	}
+	tickets, err = store.Collection("tickets", Ref{}, sdstore.WithReference("RefID", customers, sdstore.SetNull), sdstore.WithIndexedFields("RefID"))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to reopen a collection with references: %v.", failed, err)
+	}
+	if err := tickets.Get("t2", &ref); err != nil || ref.RefID != "" {
+		t.Fatalf("%s\tShould clear references when the referencing collection isn't open: got %+v, %v.", failed, ref, err)
+	}
+	if err := tickets.GetIndexed("RefID", "4", &ref); !errors.Is(err, sdstore.ErrNotFound) {
+		t.Fatalf("%s\tShould update the indexes of cleared references: %v.", failed, err)
+	}
+	t.Logf("%s\tShould apply the delete rules when the referencing collection isn't open.", success)
+}
+
+func TestReferenceCycle(t *testing.T) {
+	store, err := sdstore.New("defaults", t.TempDir())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+	}
+
+	c, err := store.Collection("c", Ref{})
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+	}
+	b, err := store.Collection("b", Ref{}, sdstore.WithReference("RefID", c, sdstore.Cascade))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a collection with references: %v.", failed, err)
+	}
+	a, err := store.Collection("a", Ref{}, sdstore.WithReference("RefID", b, sdstore.Cascade))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a collection with references: %v.", failed, err)
+	}
+	if _, err := store.Collection("c", Ref{}, sdstore.WithReference("RefID", c, sdstore.Cascade)); err != nil {
+		t.Fatalf("%s\tShould be able to reference the same collection: %v.", failed, err)
+	}
+	t.Logf("%s\tShould be able to reference the same collection.", success)
+
+	if _, err := store.Collection("c", Ref{}, sdstore.WithReference("RefID", a, sdstore.Restrict)); !errors.Is(err, sdstore.ErrReferenceCycle) {
+		t.Fatalf("%s\tShould not be able to open a collection with references forming a cycle: %v.", failed, err)
+	}
+	t.Logf("%s\tShould not be able to open a collection with references forming a cycle.", success)
+}
+
+func TestPartialDelete(t *testing.T) {
+	store, err := sdstore.New("defaults", t.TempDir())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+	}
+
+	customers, err := store.Collection("customers", Record{})
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+	}
+	if err := customers.Create("1", Record{ID: "1"}); err != nil {
+		t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+	}
+
+	errLocked := errors.New("invoice is locked")
+	tickets, err := store.Collection("tickets", Ref{}, sdstore.WithReference("RefID", customers, sdstore.SetNull))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a collection with references: %v.", failed, err)
+	}
+	invoices, err := store.Collection("invoices", Ref{},
+		sdstore.WithReference("RefID", customers, sdstore.Cascade),
+		sdstore.WithBeforeDelete(func(string, any) error { return errLocked }),
+	)
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a collection with references: %v.", failed, err)
+	}
+	if err := tickets.Create("t1", Ref{ID: "t1", RefID: "1"}); err != nil {
+		t.Fatalf("%s\tShould be able to create a record with a reference: %v.", failed, err)
+	}
+	if err := invoices.Create("i1", Ref{ID: "i1", RefID: "1"}); err != nil {
+		t.Fatalf("%s\tShould be able to create a record with a reference: %v.", failed, err)
+	}
+
+	// The reference of the ticket is cleared before the invoice fails to be
+	// deleted.
+	var partial *sdstore.PartialDeleteError
+	err = customers.Delete("1")
+	if !errors.As(err, &partial) || !errors.Is(err, errLocked) {
+		t.Fatalf("%s\tShould get a PartialDeleteError: %v.", failed, err)
+	}
+	exp := []sdstore.DeleteChange{{Collection: "tickets", ID: "t1", Field: "RefID"}}
+	if diff := cmp.Diff(partial.Applied, exp); diff != "" {
+		t.Fatalf("%s\tShould list the applied changes: %v.", failed, diff)
+	}
+	t.Logf("%s\tShould list the applied changes of a failed delete.", success)
+
+	var rec Record
+	if err := customers.Get("1", &rec); err != nil {
+		t.Fatalf("%s\tShould keep the record of a failed delete: %v.", failed, err)
+	}
+	t.Logf("%s\tShould keep the record of a failed delete.", success)
+}
@@ -3,16 +3,36 @@ package sdstore
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 )
 
 // SDStore is a key/value store
 type SDStore struct {
+	gate       sync.RWMutex
+	manifestMu sync.Mutex
+	readOnly   bool
+	encoding   string
+
+	recordedEncoding bool
+
+	// referrers holds the references between collections by the path of the
+	// referenced collection.
+	refMu     sync.Mutex
+	referrers map[string][]referrer
+
+	// opened holds the collections opened with the store by name, whose
+	// record types are used by Verify and Repair.
+	openMu sync.Mutex
+	opened map[string]*Collection
+
 	Path    string
 	Name    string
 	Encoder Encoder
 	Decoder Decoder
+	Backend Backend
 	Perms   os.FileMode
 }
 
@@ -28,53 +48,82 @@ func WithPerms(p os.FileMode) StoreOption {
 }
 
 // WithEncoding is an option to set store's encoder and decoder.
+//
+// The encoding has no name, so it isn't recorded in the store manifest.
+// Use RegisterCodec and WithCodec for custom encodings that should be recorded.
 func WithEncoding(e Encoder, d Decoder) StoreOption {
 	return func(s *SDStore) {
 		s.Encoder = e
 		s.Decoder = d
+		s.encoding = ""
+	}
+}
+
+// withNamedEncoding is an option to set store's encoder and decoder
+// for the encoding with the provided name.
+func withNamedEncoding(name string, e Encoder, d Decoder) StoreOption {
+	return func(s *SDStore) {
+		WithEncoding(e, d)(s)
+		s.encoding = name
 	}
 }
 
 // WithJSONEncoding is an option to set JSON encoding of records.
 func WithJSONEncoding() StoreOption {
-	return WithEncoding(EncodeFunc(json.Marshal), DecodeFunc(json.Unmarshal))
+	return withNamedEncoding("json", EncodeFunc(json.Marshal), DecodeFunc(json.Unmarshal))
 }
 
 // WithCborEncoding is an option to set CBOR encoding of records.
 func WithCborEncoding() StoreOption {
 	e := NewCborEncoder()
-	return WithEncoding(e, e)
+	return withNamedEncoding("cbor", e, e)
 }
 
 // WithMsgpackEncoding is an option to set Msgpack encoding of records.
 func WithMsgpackEncoding() StoreOption {
 	e := NewMsgpackEncoder()
-	return WithEncoding(e, e)
+	return withNamedEncoding("msgpack", e, e)
 }
 
 // WithBincEncoding is an option to set Binc encoding of records.
 func WithBincEncoding() StoreOption {
 	e := NewBincEncoder()
-	return WithEncoding(e, e)
+	return withNamedEncoding("binc", e, e)
 }
 
-// New returns an initialized store with json encoding as default.
+// New returns an initialized store with CBOR encoding as default.
 // name is the store's name, path is the directory path.
 // Additionally one or more options can be provided.
+//
+// The encodings of the store and its collections are recorded in the store
+// manifest. Opening a collection with a different encoding than the recorded
+// one fails with ErrEncodingMismatch, unless the WithRecordedEncoding option
+// is provided.
 func New(name string, path string, opts ...StoreOption) (*SDStore, error) {
 	store := SDStore{
-		Path:  path,
-		Name:  name,
-		Perms: defaultDirPerm,
+		Path:    path,
+		Name:    name,
+		Perms:   defaultDirPerm,
+		Backend: DirBackend{},
 	}
 
-	// Set Gob encoding as the default and loop over options.
+	// Set CBOR encoding as the default and loop over options.
 	WithCborEncoding()(&store)
 	for _, opt := range opts {
 		opt(&store)
 	}
 
-	os.MkdirAll(filepath.Join(path, name), store.Perms)
+	if store.Encoder == nil || store.Decoder == nil {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownCodec, store.encoding)
+	}
+
+	if err := store.Backend.MkdirAll(filepath.Join(path, name), store.Perms); err != nil {
+		return nil, fmt.Errorf("creating store directory: %w", err)
+	}
+
+	if err := store.initManifest(); err != nil {
+		return nil, err
+	}
 
 	return &store, nil
 }
@@ -84,8 +133,94 @@ func (s *SDStore) Collection(name string, record any, opts ...CollectionOption)
 	options := []CollectionOption{
 		withDirPerms(s.Perms),
 		withEncoding(s.Encoder, s.Decoder),
+		withEncodingName(s.encoding),
+		withGate(&s.gate),
+		withBackend(s.Backend),
+		withReadOnly(s.readOnly),
+		withStore(s),
 	}
 	options = append(options, opts...)
 
-	return newCollection(name, filepath.Join(s.Path, s.Name), record, options...).Init()
+	c := newCollection(name, filepath.Join(s.Path, s.Name), record, options...)
+
+	// Check the encoding before Init, which would treat undecodable indexes as corrupt.
+	if err := c.recoverConversion(); err != nil {
+		return nil, err
+	}
+	if err := s.applyRecordedEncoding(c); err != nil {
+		return nil, err
+	}
+	if err := s.checkRecordType(c); err != nil {
+		return nil, err
+	}
+
+	c, err := c.Init()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.register(c); err != nil {
+		return nil, err
+	}
+
+	s.openMu.Lock()
+	if s.opened == nil {
+		s.opened = make(map[string]*Collection)
+	}
+	s.opened[name] = c
+	s.openMu.Unlock()
+
+	return c, nil
+}
+
+// openedCollection returns the collection with the provided name last opened
+// with the store, nil if it hasn't been opened.
+func (s *SDStore) openedCollection(name string) *Collection {
+	s.openMu.Lock()
+	defer s.openMu.Unlock()
+
+	return s.opened[name]
+}
+
+// forget removes the collection with the provided name from the opened collections.
+func (s *SDStore) forget(name string) {
+	s.openMu.Lock()
+	defer s.openMu.Unlock()
+
+	delete(s.opened, name)
+}
+
+// tempFileSuffix is the suffix of temporary files created while writing.
+const tempFileSuffix = ".tmp"
+
+// writeFileAtomic writes data to a temporary file in the directory of filename
+// and renames it to filename.
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+"-*"+tempFileSuffix)
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, filename); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return nil
 }
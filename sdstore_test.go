@@ -1,9 +1,11 @@
 package sdstore_test
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
@@ -83,12 +85,15 @@ func TestStore(t *testing.T) {
 		{"cbor", []sdstore.StoreOption{sdstore.WithCborEncoding()}},
 		{"msgpack", []sdstore.StoreOption{sdstore.WithMsgpackEncoding()}},
 		{"binc", []sdstore.StoreOption{sdstore.WithBincEncoding()}},
+		{"gob", []sdstore.StoreOption{sdstore.WithCodec("gob")}},
+		{"json-mem", []sdstore.StoreOption{sdstore.WithJSONEncoding(), sdstore.WithBackend(sdstore.NewMemBackend())}},
+		{"cbor-mem", []sdstore.StoreOption{sdstore.WithCborEncoding(), sdstore.WithBackend(sdstore.NewMemBackend())}},
 	}
 	t.Cleanup(func() { os.RemoveAll("/tmp/test") })
 
 	for _, tc := range tt {
 		name := tc.Name
-		store, err := sdstore.New(name, "/tmp/test/"+name)
+		store, err := sdstore.New(name, "/tmp/test/"+name, tc.Options...)
 		if err != nil {
 			t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
 		}
@@ -99,7 +104,7 @@ func TestStore(t *testing.T) {
 
 	for _, tc := range tt {
 		name := tc.Name + "-indexed"
-		store, err := sdstore.New(name, "/tmp/test/"+name)
+		store, err := sdstore.New(name, "/tmp/test/"+name, tc.Options...)
 		if err != nil {
 			t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
 		}
@@ -110,7 +115,7 @@ func TestStore(t *testing.T) {
 
 	for _, tc := range tt {
 		name := tc.Name + "-paginated"
-		store, err := sdstore.New(name, "/tmp/test/"+name)
+		store, err := sdstore.New(name, "/tmp/test/"+name, tc.Options...)
 		if err != nil {
 			t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
 		}
@@ -360,3 +365,97 @@ func testPagination(t *testing.T, store *sdstore.SDStore) func(t *testing.T) {
 		t.Logf("%s\tShould get expected result.", success)
 	}
 }
+
+func TestSharedCollection(t *testing.T) {
+	// Two stores on the same path stand in for two processes.
+	path := t.TempDir()
+	var cs []*sdstore.Collection
+	for i := 0; i < 2; i++ {
+		store, err := sdstore.New("defaults", path)
+		if err != nil {
+			t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+		}
+		c, err := store.Collection("users", Record{}, sdstore.WithIndexedFields("Email"))
+		if err != nil {
+			t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+		}
+		cs = append(cs, c)
+	}
+
+	if err := cs[0].Create("1", Record{ID: "1", Email: "one@example.com"}); err != nil {
+		t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+	}
+	if err := cs[1].Create("2", Record{ID: "2", Email: "two@example.com"}); err != nil {
+		t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+	}
+
+	var niue *sdstore.IndexedValueNotUniqueError
+	if err := cs[0].Create("3", Record{ID: "3", Email: "two@example.com"}); !errors.As(err, &niue) {
+		t.Fatalf("%s\tShould not be able to create a record with a value indexed by another writer: %v.", failed, err)
+	}
+	for email, id := range map[string]string{"one@example.com": "1", "two@example.com": "2"} {
+		var got Record
+		if err := cs[0].GetIndexed("Email", email, &got); err != nil || got.ID != id {
+			t.Fatalf("%s\tShould keep the index changes of both writers: got %q, %v.", failed, got.ID, err)
+		}
+	}
+	t.Logf("%s\tShould keep the index changes of both writers.", success)
+}
+
+func TestConcurrentUpdates(t *testing.T) {
+	path := t.TempDir()
+	var cs []*sdstore.Collection
+	for i := 0; i < 2; i++ {
+		store, err := sdstore.New("defaults", path)
+		if err != nil {
+			t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+		}
+		c, err := store.Collection("users", Record{}, sdstore.WithIndexedFields("Email"))
+		if err != nil {
+			t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+		}
+		cs = append(cs, c)
+	}
+
+	if err := cs[0].Create("1", Record{ID: "1", Email: "0@example.com"}); err != nil {
+		t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := cs[i%2].Update("1", Record{ID: "1", Email: fmt.Sprintf("%d@example.com", i)}); err != nil {
+				t.Errorf("%s\tShould be able to update a record: %v.", failed, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	store, err := sdstore.New("defaults", path)
+	if err != nil {
+		t.Fatalf("%s\tShould be able to reopen the store: %v.", failed, err)
+	}
+	c, err := store.Collection("users", Record{}, sdstore.WithIndexedFields("Email"))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to reopen the collection: %v.", failed, err)
+	}
+
+	var got Record
+	if err := c.Get("1", &got); err != nil {
+		t.Fatalf("%s\tShould be able to get the record: %v.", failed, err)
+	}
+	for i := 0; i <= 20; i++ {
+		email := fmt.Sprintf("%d@example.com", i)
+		var rec Record
+		err := c.GetIndexed("Email", email, &rec)
+		if email == got.Email && err != nil {
+			t.Fatalf("%s\tShould index the stored value %q: %v.", failed, email, err)
+		}
+		if email != got.Email && !errors.Is(err, sdstore.ErrNotFound) {
+			t.Fatalf("%s\tShould not keep the replaced value %q indexed: %v.", failed, email, err)
+		}
+	}
+	t.Logf("%s\tShould only index the stored value after concurrent updates.", success)
+}
@@ -0,0 +1,227 @@
+package sdstore
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// layoutFile is the name of the file recording the number of shard levels of
+// a sharded collection. Flat collections don't have a layout file.
+const layoutFile = ".layout"
+
+// MaxShardLevels is the maximum number of shard levels of a collection.
+const MaxShardLevels = 4
+
+// ErrLayoutMismatch is an error returned when the sharding requested for a
+// collection differs from the layout of its existing records.
+var ErrLayoutMismatch = errors.New("collection layout doesn't match (use Reshard to migrate)")
+
+// WithSharding is an option to distribute the records of a collection over
+// levels of subdirectories, named after two hex characters each of a hash of
+// the record id. A level of 0 stores all records in the collection directory.
+//
+// The layout is recorded in the collection directory and used automatically
+// when the collection is opened again. Existing collections are migrated with Reshard.
+func WithSharding(levels int) CollectionOption {
+	return func(c *Collection) {
+		c.Sharding = levels
+		c.shardingSet = true
+	}
+}
+
+// shardPath returns the directory of the record id relative to the collection
+// directory for the provided number of levels.
+func shardPath(id string, levels int) string {
+	if levels <= 0 {
+		return ""
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	sum := fmt.Sprintf("%08x", h.Sum32())
+
+	dirs := make([]string, levels)
+	for i := range dirs {
+		dirs[i] = sum[i*2 : i*2+2]
+	}
+	return filepath.Join(dirs...)
+}
+
+// loadLayout returns the number of shard levels recorded for the collection
+// and false if there's no layout file.
+func (c *Collection) loadLayout() (int, bool, error) {
+	b, err := c.load(filepath.Join(c.fullpath(), layoutFile))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return 0, false, nil
+		}
+		return 0, false, fmt.Errorf("loading layout: %w", err)
+	}
+
+	levels, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil || levels < 0 || levels > MaxShardLevels {
+		return 0, false, fmt.Errorf("loading layout: invalid layout %q", b)
+	}
+
+	return levels, true, nil
+}
+
+// saveLayout records the number of shard levels of the collection.
+func (c *Collection) saveLayout(levels int) error {
+	name := filepath.Join(c.fullpath(), layoutFile)
+	if levels == 0 {
+		if err := c.Backend.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("saving layout: %w", err)
+		}
+		return nil
+	}
+
+	filePerm, _ := c.filePerms()
+	if err := c.Backend.WriteFile(name, []byte(strconv.Itoa(levels)), filePerm); err != nil {
+		return fmt.Errorf("saving layout: %w", err)
+	}
+	return nil
+}
+
+// reloadLayout loads the layout of the collection, which another writer may
+// have changed with Reshard.
+func (c *Collection) reloadLayout() error {
+	levels, _, err := c.loadLayout()
+	if err != nil {
+		return err
+	}
+	c.Sharding = levels
+	return nil
+}
+
+// initLayout determines the layout of the collection on Init.
+//
+// The recorded layout is used, unless a different layout has been requested
+// with WithSharding. A different layout can only be applied to an empty collection.
+func (c *Collection) initLayout() error {
+	if c.Sharding < 0 || c.Sharding > MaxShardLevels {
+		return fmt.Errorf("sharding levels should be between 0 and %d", MaxShardLevels)
+	}
+
+	levels, _, err := c.loadLayout()
+	if err != nil {
+		return err
+	}
+
+	if !c.shardingSet || c.Sharding == levels {
+		c.Sharding = levels
+		return nil
+	}
+
+	empty, err := c.empty()
+	if err != nil {
+		return err
+	}
+	if !empty {
+		return ErrLayoutMismatch
+	}
+
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	return c.saveLayout(c.Sharding)
+}
+
+// empty returns true if the collection has no records.
+func (c *Collection) empty() (bool, error) {
+	entries, err := c.Backend.ReadDir(c.fullpath())
+	if err != nil {
+		return false, err
+	}
+
+	for _, e := range entries {
+		if e.IsDir() || strings.HasSuffix(e.Name(), ".sds") {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Reshard moves the records of the Collection to the layout with the provided
+// number of shard levels. Writes to the Collection are blocked while the records
+// are moved. An interrupted Reshard can be resumed by running it again.
+// Other open handles of the collection use the new layout from their next write.
+func (c *Collection) Reshard(levels int) error {
+	if !c.initialized {
+		return ErrNotInitialized
+	}
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	if levels < 0 || levels > MaxShardLevels {
+		return fmt.Errorf("sharding levels should be between 0 and %d", MaxShardLevels)
+	}
+
+	c.gate.RLock()
+	defer c.gate.RUnlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Collect the records first, the walk must not see the moved files.
+	var paths []string
+	var dirs []string
+	if err := walkDir(c.Backend, c.fullpath(), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != c.fullpath() {
+				dirs = append(dirs, path)
+			}
+			return nil
+		}
+		if strings.HasSuffix(path, ".sds") {
+			paths = append(paths, path)
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("listing records: %w", err)
+	}
+
+	_, dirPerm := c.filePerms()
+	for _, path := range paths {
+		id := c.idFromPath(path)
+		dest := filepath.Join(c.fullpath(), shardPath(id, levels), encodeID(id)+".sds")
+		if dest == path {
+			continue
+		}
+
+		if err := c.Backend.MkdirAll(filepath.Dir(dest), dirPerm); err != nil {
+			return fmt.Errorf("moving record %q: %w", id, err)
+		}
+		if err := c.Backend.Rename(path, dest); err != nil {
+			return fmt.Errorf("moving record %q: %w", id, err)
+		}
+	}
+
+	if err := c.saveLayout(levels); err != nil {
+		return err
+	}
+	c.Sharding = levels
+
+	// Remove empty shard directories, deepest first.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if entries, err := c.Backend.ReadDir(dirs[i]); err == nil && len(entries) == 0 {
+			c.Backend.Remove(dirs[i])
+		}
+	}
+
+	return nil
+}
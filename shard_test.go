@@ -0,0 +1,193 @@
+package sdstore_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/toqns/sdstore"
+)
+
+func TestSharding(t *testing.T) {
+	path := t.TempDir()
+	store, err := sdstore.New("defaults", path, sdstore.WithJSONEncoding())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+	}
+
+	c, err := store.Collection("sharded", Record{}, sdstore.WithIndexedFields("Email"), sdstore.WithSharding(2))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a sharded collection: %v.", failed, err)
+	}
+	t.Logf("%s\tShould be able to create a sharded collection.", success)
+
+	for i := 0; i < 20; i++ {
+		rec := Record{ID: fmt.Sprint(i), Name: fmt.Sprint("Name ", i), Email: fmt.Sprintf("%d@example.com", i)}
+		if err := c.Create(rec.ID, rec); err != nil {
+			t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+		}
+	}
+	if err := c.Update("3", Record{ID: "3", Name: "Three", Email: "three@example.com"}); err != nil {
+		t.Fatalf("%s\tShould be able to update a record: %v.", failed, err)
+	}
+	if err := c.Delete("4"); err != nil {
+		t.Fatalf("%s\tShould be able to delete a record: %v.", failed, err)
+	}
+	t.Logf("%s\tShould be able to modify a sharded collection.", success)
+
+	files, err := filepath.Glob(filepath.Join(path, "defaults", "sharded", "*", "*", "*.sds"))
+	if err != nil || len(files) != 19 {
+		t.Fatalf("%s\tShould store records in shard directories: got %d files, %v.", failed, len(files), err)
+	}
+	t.Logf("%s\tShould store records in shard directories.", success)
+
+	// Reopen without the option to verify that the layout is recorded.
+	c, err = store.Collection("sharded", Record{}, sdstore.WithIndexedFields("Email"))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to reopen a sharded collection: %v.", failed, err)
+	}
+
+	var got Record
+	if err := c.GetIndexed("Email", "three@example.com", &got); err != nil || got.ID != "3" {
+		t.Fatalf("%s\tShould be able to get a record through the index: %v.", failed, err)
+	}
+	if err := c.Get("4", &got); !errors.Is(err, sdstore.ErrNotFound) {
+		t.Fatalf("%s\tShould not be able to get a deleted record: %v.", failed, err)
+	}
+	res, err := c.Query(func(any) bool { return true })
+	if err != nil || len(res) != 19 {
+		t.Fatalf("%s\tShould be able to query all records: got %d, %v.", failed, len(res), err)
+	}
+	t.Logf("%s\tShould be able to read a reopened sharded collection.", success)
+
+	if _, err := store.Collection("sharded", Record{}, sdstore.WithSharding(1)); !errors.Is(err, sdstore.ErrLayoutMismatch) {
+		t.Fatalf("%s\tShould not be able to open a collection with a different layout: %v.", failed, err)
+	}
+	t.Logf("%s\tShould not be able to open a collection with a different layout.", success)
+}
+
+func TestReshard(t *testing.T) {
+	path := t.TempDir()
+	store, err := sdstore.New("defaults", path, sdstore.WithJSONEncoding())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+	}
+
+	c, err := store.Collection("flat", Record{}, sdstore.WithIndexedFields("Email"))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a collection: %v.", failed, err)
+	}
+
+	var want []string
+	for i := 0; i < 10; i++ {
+		rec := Record{ID: fmt.Sprint(i), Email: fmt.Sprintf("%d@example.com", i)}
+		if err := c.Create(rec.ID, rec); err != nil {
+			t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+		}
+		want = append(want, rec.ID)
+	}
+
+	if err := c.Reshard(3); err != nil {
+		t.Fatalf("%s\tShould be able to reshard a collection: %v.", failed, err)
+	}
+	t.Logf("%s\tShould be able to reshard a collection.", success)
+
+	flat, _ := filepath.Glob(filepath.Join(path, "defaults", "flat", "*.sds"))
+	if len(flat) != 0 {
+		t.Fatalf("%s\tShould move all records to shard directories: %v.", failed, flat)
+	}
+
+	for _, levels := range []int{3, 0} {
+		c, err := store.Collection("flat", Record{}, sdstore.WithIndexedFields("Email"))
+		if err != nil {
+			t.Fatalf("%s\tShould be able to reopen a resharded collection: %v.", failed, err)
+		}
+		if c.Sharding != levels {
+			t.Fatalf("%s\tShould use the recorded layout: got %d, want %d.", failed, c.Sharding, levels)
+		}
+
+		res, err := c.Query(func(any) bool { return true })
+		if err != nil {
+			t.Fatalf("%s\tShould be able to query a resharded collection: %v.", failed, err)
+		}
+		var ids []string
+		for _, r := range res {
+			ids = append(ids, r.(*Record).ID)
+		}
+		sort.Strings(ids)
+		if len(ids) != len(want) {
+			t.Fatalf("%s\tShould find all records: got %v.", failed, ids)
+		}
+
+		var got Record
+		if err := c.GetIndexed("Email", "7@example.com", &got); err != nil || got.ID != "7" {
+			t.Fatalf("%s\tShould be able to get a record through the index: %v.", failed, err)
+		}
+
+		// Move the records back for the next iteration.
+		if err := c.Reshard(0); err != nil {
+			t.Fatalf("%s\tShould be able to reshard a collection: %v.", failed, err)
+		}
+	}
+	t.Logf("%s\tShould be able to use a resharded collection.", success)
+
+	entries, err := os.ReadDir(filepath.Join(path, "defaults", "flat"))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to read the collection directory: %v.", failed, err)
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			t.Fatalf("%s\tShould remove empty shard directories: found %s.", failed, e.Name())
+		}
+	}
+	t.Logf("%s\tShould remove empty shard directories.", success)
+}
+
+func TestReshardSharedCollection(t *testing.T) {
+	// Two stores on the same path stand in for two processes.
+	path := t.TempDir()
+	var cs []*sdstore.Collection
+	for i := 0; i < 2; i++ {
+		store, err := sdstore.New("defaults", path, sdstore.WithJSONEncoding())
+		if err != nil {
+			t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+		}
+		c, err := store.Collection("flat", Record{})
+		if err != nil {
+			t.Fatalf("%s\tShould be able to create a collection: %v.", failed, err)
+		}
+		cs = append(cs, c)
+	}
+
+	if err := cs[0].Create("1", Record{ID: "1", Name: "One"}); err != nil {
+		t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+	}
+	if err := cs[0].Reshard(2); err != nil {
+		t.Fatalf("%s\tShould be able to reshard a collection: %v.", failed, err)
+	}
+
+	if err := cs[1].Update("1", Record{ID: "1", Name: "Uno"}); err != nil {
+		t.Fatalf("%s\tShould be able to update a record with another handle: %v.", failed, err)
+	}
+	if err := cs[1].Create("2", Record{ID: "2", Name: "Two"}); err != nil {
+		t.Fatalf("%s\tShould be able to create a record with another handle: %v.", failed, err)
+	}
+	if cs[1].Sharding != 2 {
+		t.Fatalf("%s\tShould use the layout of the other handle: got %d.", failed, cs[1].Sharding)
+	}
+
+	flat, _ := filepath.Glob(filepath.Join(path, "defaults", "flat", "*.sds"))
+	if len(flat) != 0 {
+		t.Fatalf("%s\tShould write records to shard directories: %v.", failed, flat)
+	}
+	for id, name := range map[string]string{"1": "Uno", "2": "Two"} {
+		var got Record
+		if err := cs[0].Get(id, &got); err != nil || got.Name != name {
+			t.Fatalf("%s\tShould be able to get record %s: got %+v, %v.", failed, id, got, err)
+		}
+	}
+	t.Logf("%s\tShould write with the new layout from other handles after a reshard.", success)
+}
@@ -0,0 +1,197 @@
+package sdstore
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// errCorruptSnappy is returned when Snappy compressed data can't be decoded.
+var errCorruptSnappy = errors.New("corrupt snappy data")
+
+// Snappy element tags, stored in the lowest two bits of each tag byte.
+const (
+	snappyLiteral = 0x00
+	snappyCopy1   = 0x01
+	snappyCopy2   = 0x02
+	snappyCopy4   = 0x03
+)
+
+// snappyMaxOffset is the largest offset the encoder emits, which keeps every
+// copy within the range of a copy with a 2-byte offset.
+const snappyMaxOffset = 1<<16 - 1
+
+// snappyCompressor implements the Compressor interface using the Snappy block
+// format, which is compatible with the Encode and Decode functions of the
+// reference implementation.
+type snappyCompressor struct{}
+
+// Compress implements the Compressor interface for snappyCompressor.
+func (snappyCompressor) Compress(src []byte) ([]byte, error) {
+	dst := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(src)+len(src)/6+32)
+	dst = dst[:binary.PutUvarint(dst, uint64(len(src)))]
+
+	const (
+		tableBits = 14
+		minMatch  = 4
+	)
+	var table [1 << tableBits]int32
+	hash := func(u uint32) uint32 {
+		return (u * 0x1e35a7bd) >> (32 - tableBits)
+	}
+
+	lit := 0
+	for i := 0; i+minMatch <= len(src); {
+		u := binary.LittleEndian.Uint32(src[i:])
+		h := hash(u)
+		cand := int(table[h]) - 1
+		table[h] = int32(i + 1)
+
+		if cand < 0 || i-cand > snappyMaxOffset || binary.LittleEndian.Uint32(src[cand:]) != u {
+			i++
+			continue
+		}
+
+		n := minMatch
+		for i+n < len(src) && src[cand+n] == src[i+n] {
+			n++
+		}
+
+		dst = snappyEmitLiteral(dst, src[lit:i])
+		dst = snappyEmitCopy(dst, i-cand, n)
+		i += n
+		lit = i
+	}
+	dst = snappyEmitLiteral(dst, src[lit:])
+
+	return dst, nil
+}
+
+// snappyEmitLiteral appends a literal element for b to dst.
+func snappyEmitLiteral(dst, b []byte) []byte {
+	if len(b) == 0 {
+		return dst
+	}
+
+	n := uint32(len(b) - 1)
+	switch {
+	case n < 60:
+		dst = append(dst, byte(n)<<2|snappyLiteral)
+	case n < 1<<8:
+		dst = append(dst, 60<<2|snappyLiteral, byte(n))
+	case n < 1<<16:
+		dst = append(dst, 61<<2|snappyLiteral, byte(n), byte(n>>8))
+	case n < 1<<24:
+		dst = append(dst, 62<<2|snappyLiteral, byte(n), byte(n>>8), byte(n>>16))
+	default:
+		dst = append(dst, 63<<2|snappyLiteral, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	}
+
+	return append(dst, b...)
+}
+
+// snappyEmitCopy appends copy elements for a match of n bytes at offset to
+// dst.
+func snappyEmitCopy(dst []byte, offset, n int) []byte {
+	// A copy with a 2-byte offset holds up to 64 bytes. Stop at 60 so the
+	// remainder is never shorter than the 4 bytes of a match.
+	for n >= 68 {
+		dst = append(dst, 63<<2|snappyCopy2, byte(offset), byte(offset>>8))
+		n -= 64
+	}
+	if n > 64 {
+		dst = append(dst, 59<<2|snappyCopy2, byte(offset), byte(offset>>8))
+		n -= 60
+	}
+
+	if n < 12 && offset < 1<<11 {
+		return append(dst, byte(offset>>8)<<5|byte(n-4)<<2|snappyCopy1, byte(offset))
+	}
+	return append(dst, byte(n-1)<<2|snappyCopy2, byte(offset), byte(offset>>8))
+}
+
+// Decompress implements the Compressor interface for snappyCompressor.
+func (c snappyCompressor) Decompress(src []byte) ([]byte, error) {
+	return c.decompressLimit(src, maxDecompressedSize)
+}
+
+// decompressLimit implements the limitDecompressor interface for
+// snappyCompressor.
+func (snappyCompressor) decompressLimit(src []byte, limit uint64) ([]byte, error) {
+	size, n := binary.Uvarint(src)
+	if n <= 0 {
+		return nil, errCorruptSnappy
+	}
+	if size > limit {
+		return nil, fmt.Errorf("%w: %d bytes exceeds the limit of %d", errDecompressedSize, size, limit)
+	}
+
+	dst := make([]byte, 0, size)
+	for s := n; s < len(src); {
+		tag := src[s]
+		s++
+
+		var length, offset int
+		switch tag & 0x03 {
+		case snappyLiteral:
+			length = int(tag >> 2)
+			if length >= 60 {
+				w := length - 59
+				if s+w > len(src) {
+					return nil, errCorruptSnappy
+				}
+				length = 0
+				for i := w - 1; i >= 0; i-- {
+					length = length<<8 | int(src[s+i])
+				}
+				s += w
+			}
+			length++
+
+			if length > len(src)-s || uint64(len(dst)+length) > size {
+				return nil, errCorruptSnappy
+			}
+			dst = append(dst, src[s:s+length]...)
+			s += length
+			continue
+
+		case snappyCopy1:
+			if s+1 > len(src) {
+				return nil, errCorruptSnappy
+			}
+			length = 4 + int(tag>>2&0x07)
+			offset = int(tag&0xe0)<<3 | int(src[s])
+			s++
+
+		case snappyCopy2:
+			if s+2 > len(src) {
+				return nil, errCorruptSnappy
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src[s:]))
+			s += 2
+
+		case snappyCopy4:
+			if s+4 > len(src) {
+				return nil, errCorruptSnappy
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src[s:]))
+			s += 4
+		}
+
+		if offset <= 0 || offset > len(dst) || uint64(len(dst)+length) > size {
+			return nil, errCorruptSnappy
+		}
+
+		// Copies may overlap the bytes they produce, so copy byte by byte.
+		for i, start := 0, len(dst)-offset; i < length; i++ {
+			dst = append(dst, dst[start+i])
+		}
+	}
+
+	if uint64(len(dst)) != size {
+		return nil, errCorruptSnappy
+	}
+	return dst, nil
+}
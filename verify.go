@@ -0,0 +1,621 @@
+package sdstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// LostAndFound is the name of the directory in the store's directory to which
+// Repair moves files it can't use.
+const LostAndFound = "lost+found"
+
+// DuplicateValue describes an indexed field/value combination that is used by
+// more than one record.
+type DuplicateValue struct {
+	Field string
+	Value string
+	IDs   []string
+}
+
+// CollectionReport is the result of the verification of a single collection.
+type CollectionReport struct {
+	Name    string
+	Records int
+
+	// CorruptIndex is set when the index file can't be decoded.
+	CorruptIndex *CorruptRecordError
+
+	// Corrupt holds the records that can't be decoded.
+	Corrupt []*CorruptRecordError
+
+	// Dangling holds the index keys pointing at records that don't exist.
+	Dangling []string
+
+	// Stale holds the ids of records that don't exist, but are still held by
+	// the full-text, multi-key or reference indexes.
+	Stale []string
+
+	// Unindexed holds the ids of records that are missing from the index.
+	Unindexed []string
+
+	// Duplicates holds the indexed values that are used by more than one record.
+	Duplicates []DuplicateValue
+
+	// Orphans holds the paths of temporary files left behind by interrupted writes.
+	Orphans []string
+}
+
+// OK returns true if no problems were found in the collection.
+func (r CollectionReport) OK() bool {
+	return r.CorruptIndex == nil &&
+		len(r.Corrupt) == 0 &&
+		len(r.Dangling) == 0 &&
+		len(r.Stale) == 0 &&
+		len(r.Unindexed) == 0 &&
+		len(r.Duplicates) == 0 &&
+		len(r.Orphans) == 0
+}
+
+// Report is the result of the verification of a store.
+type Report struct {
+	Collections []CollectionReport
+}
+
+// OK returns true if no problems were found in the store.
+func (r *Report) OK() bool {
+	for _, c := range r.Collections {
+		if !c.OK() {
+			return false
+		}
+	}
+	return true
+}
+
+// RepairOptions are the options for a Repair.
+type RepairOptions struct {
+	// DryRun reports what would be repaired without changing anything.
+	DryRun bool
+
+	// KeepOrphans leaves orphan temporary files in place instead of removing them.
+	KeepOrphans bool
+}
+
+// Verify audits all collections of the store and reports the problems found.
+//
+// Records of collections opened with the store are decoded to their record type.
+// Records of other collections are decoded with the store's decoder without
+// knowledge of the record type, which means Verify can be run without opening
+// the collections.
+func (s *SDStore) Verify(ctx context.Context) (*Report, error) {
+	names, err := s.collectionNames()
+	if err != nil {
+		return nil, err
+	}
+
+	var report Report
+	for _, name := range names {
+		cr, _, err := s.verifyCollection(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("verifying %q: %w", name, err)
+		}
+		report.Collections = append(report.Collections, cr)
+	}
+
+	return &report, nil
+}
+
+// Repair verifies the store, moves corrupt records and indexes to the
+// lost+found directory, removes orphan temporary files and rebuilds the
+// indexes of collections that have problems. Writes to a collection are
+// blocked while it is repaired, in other processes as well.
+//
+// The indexes of collections opened with the store are rebuilt with their
+// settings. Collections that aren't opened only get their unique indexes
+// rebuilt, their full-text, multi-key and reference indexes are rebuilt when
+// they are opened.
+//
+// The returned report describes the state before the repair. Other handles
+// of a repaired collection reload its indexes on their next write.
+func (s *SDStore) Repair(ctx context.Context, opts RepairOptions) (*Report, error) {
+	if s.readOnly && !opts.DryRun {
+		return nil, ErrReadOnly
+	}
+
+	names, err := s.collectionNames()
+	if err != nil {
+		return nil, err
+	}
+
+	var report Report
+	for _, name := range names {
+		var cr CollectionReport
+		var err error
+		if opts.DryRun {
+			cr, _, err = s.verifyCollection(ctx, name)
+		} else {
+			cr, err = s.repairCollection(ctx, name, opts)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("repairing %q: %w", name, err)
+		}
+		report.Collections = append(report.Collections, cr)
+	}
+
+	return &report, nil
+}
+
+// collectionNames returns the names of the collections in the store's directory.
+func (s *SDStore) collectionNames() ([]string, error) {
+	entries, err := s.Backend.ReadDir(filepath.Join(s.Path, s.Name))
+	if err != nil {
+		return nil, fmt.Errorf("reading store: %w", err)
+	}
+
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() || e.Name() == LostAndFound || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		names = append(names, e.Name())
+	}
+
+	return names, nil
+}
+
+// genericCollection returns an initialized Collection for name which decodes
+// records without knowledge of the record type.
+//
+// It fails with ErrEncodingMismatch if the collection was recorded with another encoding,
+// to prevent its records from being reported as corrupt.
+func (s *SDStore) genericCollection(name string) (*Collection, error) {
+	c := newCollection(name, filepath.Join(s.Path, s.Name), nil,
+		withDirPerms(s.Perms),
+		withEncoding(s.Encoder, s.Decoder),
+		withEncodingName(s.encoding),
+		withGate(&s.gate),
+		withBackend(s.Backend),
+	)
+	if err := s.applyRecordedEncoding(c); err != nil {
+		return nil, err
+	}
+	c.initialized = true
+	c.Sharding, _, _ = c.loadLayout()
+
+	// Without the settings of the collection, files written before checksums
+	// were enabled can't be told from files that lost their checksum.
+	c.unsealedFiles = true
+	return c, nil
+}
+
+// verifyCollection verifies the collection with the provided name.
+func (s *SDStore) verifyCollection(ctx context.Context, name string) (CollectionReport, *Collection, error) {
+	cr := CollectionReport{Name: name}
+	c, err := s.genericCollection(name)
+	if err != nil {
+		return cr, nil, err
+	}
+
+	// Decode and index the records like the opened collection, if any.
+	if oc := s.openedCollection(name); oc != nil {
+		c.record = oc.record
+		c.partial = oc.partial
+		c.SchemaVersion = oc.SchemaVersion
+		c.migrations = oc.migrations
+		c.fullText = oc.fullText
+		c.multiKey = oc.multiKey
+		c.references = oc.references
+		c.unsealedFiles = oc.unsealedFiles
+	}
+	if _, ok := c.Decoder.(GobEncoder); ok && c.record == nil {
+		return cr, nil, fmt.Errorf("gob encoded records can't be decoded without their type")
+	}
+
+	// Load the index. A missing index is only a problem if records are indexed,
+	// which can't be determined without it.
+	indexPath := c.filepath(c.Name, true)
+	if b, err := c.load(indexPath); err == nil {
+		c.Checksums = bytes.HasPrefix(b, []byte(checksumMagic))
+	}
+	if err := c.loadIndexes(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		var corrupt *CorruptRecordError
+		if !errors.As(err, &corrupt) {
+			return cr, nil, err
+		}
+		cr.CorruptIndex = corrupt
+		c.Indexing.Fields = nil
+		c.Indexing.Indexes = make(map[string]string)
+	}
+
+	// Values are indexed with the normalizers recorded in the index.
+	if err := c.initNormalizers(c.Indexing.Normalizers); err != nil {
+		return cr, nil, err
+	}
+
+	owned := c.ownedKeys()
+	kinds := c.indexedKinds()
+	values := make(map[string][]string)
+	if err := walkDir(c.Backend, c.fullpath(), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		if strings.HasSuffix(path, tempFileSuffix) {
+			cr.Orphans = append(cr.Orphans, path)
+			return nil
+		}
+
+		if !strings.HasSuffix(path, ".sds") {
+			return nil
+		}
+		cr.Records++
+
+		rec, err := c.verifyRecord(path)
+		if err != nil {
+			var corrupt *CorruptRecordError
+			if !errors.As(err, &corrupt) {
+				return err
+			}
+			cr.Corrupt = append(cr.Corrupt, corrupt)
+			return nil
+		}
+
+		id := c.idFromPath(path)
+		keys, indexed := c.verifyKeys(id, rec, owned, kinds)
+		for _, k := range keys {
+			values[k] = append(values[k], id)
+			if c.Indexing.Indexes[k] != id {
+				indexed = false
+			}
+		}
+		if c.record != nil && !c.recordIndexed(id, rec) {
+			indexed = false
+		}
+		if !indexed {
+			cr.Unindexed = append(cr.Unindexed, id)
+		}
+
+		return nil
+	}); err != nil {
+		return cr, nil, err
+	}
+
+	// Find duplicate values and index entries pointing at missing records.
+	for k, ids := range values {
+		if len(ids) < 2 {
+			continue
+		}
+		fld, val := splitKey(k)
+		cr.Duplicates = append(cr.Duplicates, DuplicateValue{Field: fld, Value: val, IDs: ids})
+	}
+	for k, id := range c.Indexing.Indexes {
+		if !c.exists(id) {
+			cr.Dangling = append(cr.Dangling, k)
+		}
+	}
+	for _, id := range c.recordIndexIDs() {
+		if !c.exists(id) {
+			cr.Stale = append(cr.Stale, id)
+		}
+	}
+
+	sort.Slice(cr.Duplicates, func(i, j int) bool {
+		return cr.Duplicates[i].Field+":"+cr.Duplicates[i].Value < cr.Duplicates[j].Field+":"+cr.Duplicates[j].Value
+	})
+	sort.Strings(cr.Dangling)
+	sort.Strings(cr.Stale)
+	sort.Strings(cr.Unindexed)
+
+	return cr, c, nil
+}
+
+// repairCollection verifies the collection with the provided name and repairs
+// the problems found, holding the write locks of the collection.
+func (s *SDStore) repairCollection(ctx context.Context, name string, opts RepairOptions) (CollectionReport, error) {
+	s.gate.RLock()
+	defer s.gate.RUnlock()
+
+	oc := s.openedCollection(name)
+	if oc != nil {
+		oc.mu.Lock()
+		defer oc.mu.Unlock()
+	}
+
+	unlock, err := s.Backend.Lock(collectionLock(filepath.Join(s.Path, s.Name), name))
+	if err != nil {
+		return CollectionReport{Name: name}, fmt.Errorf("locking collection: %w", err)
+	}
+	defer unlock()
+
+	cr, c, err := s.verifyCollection(ctx, name)
+	if err != nil || cr.OK() {
+		return cr, err
+	}
+
+	// Move corrupt files out of the way.
+	for _, corrupt := range cr.Corrupt {
+		if err := s.quarantine(c.Name, corrupt.Path); err != nil {
+			return cr, err
+		}
+	}
+	if cr.CorruptIndex != nil {
+		if err := s.quarantine(c.Name, cr.CorruptIndex.Path); err != nil {
+			return cr, err
+		}
+	}
+
+	if !opts.KeepOrphans {
+		for _, path := range cr.Orphans {
+			if err := c.Backend.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return cr, fmt.Errorf("removing orphan: %w", err)
+			}
+		}
+	}
+
+	// The opened collection rebuilds all of its indexes.
+	if oc != nil {
+		if err := oc.recreateIndexes(); err != nil {
+			return cr, err
+		}
+		return cr, oc.saveIndexes()
+	}
+
+	return cr, c.repairIndexes(ctx)
+}
+
+// repairIndexes rebuilds the unique indexes of the generic collection c from
+// its records. The first record in lexical order wins for duplicate values.
+// Without the record type, entries of partial indexes are kept if their record
+// exists.
+//
+// The full-text, multi-key and reference indexes are cleared, as they can't
+// be rebuilt without the settings of the collection. They are rebuilt when the
+// collection is opened.
+func (c *Collection) repairIndexes(ctx context.Context) error {
+	owned := c.ownedKeys()
+	kinds := c.indexedKinds()
+	newIndexes := make(map[string]string)
+	for k, id := range c.Indexing.Indexes {
+		if field, _ := splitKey(k); c.record == nil && contains(c.Indexing.Partial, field) && c.exists(id) {
+			newIndexes[k] = id
+		}
+	}
+	if err := walkDir(c.Backend, c.fullpath(), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if d.IsDir() || !strings.HasSuffix(path, ".sds") {
+			return nil
+		}
+
+		rec, err := c.verifyRecord(path)
+		if err != nil {
+			return err
+		}
+
+		id := c.idFromPath(path)
+		keys, _ := c.verifyKeys(id, rec, owned, kinds)
+		for _, k := range keys {
+			if _, ok := newIndexes[k]; !ok {
+				newIndexes[k] = id
+			}
+		}
+
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	c.Indexing.Indexes = newIndexes
+	c.Indexing.KeyVersion = keyVersion
+	c.resetReferences()
+	c.resetFullText()
+	c.resetMultiKey()
+	return c.saveIndexes()
+}
+
+// recordIndexed returns true if the full-text, multi-key and reference
+// indexes hold the record id with the contents of rec.
+func (c *Collection) recordIndexed(id string, rec any) bool {
+	if len(c.fullText.fields) > 0 {
+		if _, ok := c.Indexing.FullText.Lengths[id]; !ok {
+			return false
+		}
+	}
+
+	keys, err := c.multiKeys(rec)
+	if err != nil {
+		return false
+	}
+	for _, k := range keys {
+		ids := c.Indexing.MultiKey.Keys[k]
+		if i := sort.SearchStrings(ids, id); i == len(ids) || ids[i] != id {
+			return false
+		}
+	}
+
+	for _, ref := range c.references {
+		if target := refID(rec, ref.field); target != "" && !contains(c.Indexing.References[ref.field][target], id) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// recordIndexIDs returns the ids of the records held by the full-text,
+// multi-key and reference indexes.
+func (c *Collection) recordIndexIDs() []string {
+	seen := make(map[string]bool)
+	for id := range c.Indexing.FullText.Lengths {
+		seen[id] = true
+	}
+	for _, ids := range c.Indexing.FullText.Terms {
+		for id := range ids {
+			seen[id] = true
+		}
+	}
+	for _, ids := range c.Indexing.MultiKey.Keys {
+		for _, id := range ids {
+			seen[id] = true
+		}
+	}
+	for _, targets := range c.Indexing.References {
+		for _, ids := range targets {
+			for _, id := range ids {
+				seen[id] = true
+			}
+		}
+	}
+
+	ids := make([]string, 0, len(seen))
+	for id := range seen {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// quarantine moves the file at path to the lost+found directory of the store.
+func (s *SDStore) quarantine(collection string, path string) error {
+	dir := filepath.Join(s.Path, s.Name, LostAndFound, collection)
+	if err := s.Backend.MkdirAll(dir, s.Perms); err != nil {
+		return fmt.Errorf("creating %s: %w", LostAndFound, err)
+	}
+
+	if err := s.Backend.Rename(path, filepath.Join(dir, filepath.Base(path))); err != nil {
+		return fmt.Errorf("moving %s to %s: %w", path, LostAndFound, err)
+	}
+
+	return nil
+}
+
+// verifyRecord loads and decodes the record at path to a pointer to the record
+// type of c, or without knowledge of the type if c has none.
+func (c *Collection) verifyRecord(path string) (any, error) {
+	if c.record != nil {
+		rec := reflect.New(c.record).Interface()
+		return rec, c.read(path, rec)
+	}
+
+	var rec any
+	return rec, c.read(path, &rec)
+}
+
+// ownedKeys returns the keys of the unique indexes by field and id.
+func (c *Collection) ownedKeys() map[string]string {
+	owned := make(map[string]string, len(c.Indexing.Indexes))
+	for k, id := range c.Indexing.Indexes {
+		field, _ := splitKey(k)
+		owned[field+"\x00"+id] = k
+	}
+	return owned
+}
+
+// indexedKinds returns the kind of the values of each field in the unique
+// indexes.
+func (c *Collection) indexedKinds() map[string]string {
+	kinds := make(map[string]string, len(c.Indexing.Fields))
+	for k := range c.Indexing.Indexes {
+		field, _ := splitKey(k)
+		kinds[field] = keyKind(k)
+	}
+	return kinds
+}
+
+// verifyKeys returns the keys of the unique indexes of record id like
+// indexKeys, and false if a key can't be determined.
+//
+// Records decoded without knowledge of their type lose the kind of some values,
+// such as byte slices and times, so their keys are taken from the entries of
+// the record in the index, owned, if there are any. Otherwise text is keyed by
+// the kind of the other values of the field in the index, kinds. The predicates
+// of partial indexes aren't known either, so their entries are only checked for
+// missing records.
+func (c *Collection) verifyKeys(id string, rec any, owned map[string]string, kinds map[string]string) (map[string]string, bool) {
+	if c.record != nil {
+		keys, err := c.indexKeys(rec)
+		return keys, err == nil
+	}
+
+	keys := make(map[string]string, len(c.Indexing.Fields))
+	ok := true
+	for _, fld := range c.Indexing.Fields {
+		if contains(c.Indexing.Partial, fld) {
+			continue
+		}
+		v, found := genericFieldValue(rec, fld)
+		if !found || c.sparseZero(fld, v) {
+			continue
+		}
+		if k, found := owned[fld+"\x00"+id]; found {
+			keys[fld] = k
+			continue
+		}
+
+		if s, isText := v.(string); isText {
+			switch kinds[fld] {
+			case keyTime:
+				t, err := time.Parse(time.RFC3339Nano, s)
+				if err != nil {
+					ok = false
+					continue
+				}
+				v = t
+			case keyMarshaler:
+				v = textValue(s)
+			}
+		}
+
+		k, err := c.indexKey(fld, v)
+		if err != nil {
+			ok = false
+			continue
+		}
+		keys[fld] = k
+	}
+	return keys, ok
+}
+
+// genericFieldValue returns the value of field, which may be a dotted path,
+// from a record that has been decoded without knowledge of its type. Null
+// values are reported as missing, as they aren't indexed.
+func genericFieldValue(rec any, field string) (any, bool) {
+	v := rec
+	for _, name := range strings.Split(field, ".") {
+		var ok bool
+		switch m := v.(type) {
+		case map[string]any:
+			v, ok = m[name]
+		case map[any]any:
+			v, ok = m[name]
+		}
+		if !ok || v == nil {
+			return nil, false
+		}
+	}
+
+	// Some codecs decode strings to bytes when the type is unknown.
+	if b, isBytes := v.([]byte); isBytes {
+		v = string(b)
+	}
+
+	return v, true
+}
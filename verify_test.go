@@ -0,0 +1,279 @@
+package sdstore_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/toqns/sdstore"
+)
+
+func TestVerifyRepair(t *testing.T) {
+	path := t.TempDir()
+	store, err := sdstore.New("verify", path, sdstore.WithJSONEncoding())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+	}
+	t.Logf("%s\tShould be able to create a new store.", success)
+
+	c, err := store.Collection("test", Record{}, sdstore.WithIndexedFields("Email"))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+	}
+	t.Logf("%s\tShould be able to create a new collection.", success)
+
+	for _, rec := range []Record{
+		{ID: "1", Name: "One", Email: "one@example.com"},
+		{ID: "2", Name: "Two", Email: "two@example.com"},
+		{ID: "3", Name: "Three", Email: "three@example.com"},
+	} {
+		if err := c.Create(rec.ID, rec); err != nil {
+			t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+		}
+	}
+	t.Logf("%s\tShould be able to create records.", success)
+
+	dir := filepath.Join(path, "verify", "test")
+	if err := os.WriteFile(filepath.Join(dir, "1.sds"), []byte("{not json"), 0600); err != nil {
+		t.Fatalf("%s\tShould be able to corrupt a record: %v.", failed, err)
+	}
+	if err := os.Remove(filepath.Join(dir, "2.sds")); err != nil {
+		t.Fatalf("%s\tShould be able to remove a record: %v.", failed, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".3.sds-123.tmp"), nil, 0600); err != nil {
+		t.Fatalf("%s\tShould be able to create an orphan file: %v.", failed, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "4.sds"), []byte(`{"ID":"4","Email":"three@example.com"}`), 0600); err != nil {
+		t.Fatalf("%s\tShould be able to create an unindexed record: %v.", failed, err)
+	}
+
+	report, err := store.Verify(context.Background())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to verify the store: %v.", failed, err)
+	}
+	t.Logf("%s\tShould be able to verify the store.", success)
+
+	if len(report.Collections) != 1 {
+		t.Fatalf("%s\tShould report 1 collection, got: %d.", failed, len(report.Collections))
+	}
+	cr := report.Collections[0]
+	if len(cr.Corrupt) != 1 || cr.Corrupt[0].ID != "1" {
+		t.Fatalf("%s\tShould report record 1 as corrupt: %+v.", failed, cr.Corrupt)
+	}
+	t.Logf("%s\tShould report record 1 as corrupt.", success)
+
+	if len(cr.Dangling) != 1 || cr.Dangling[0] != "Email:stwo@example.com" {
+		t.Fatalf("%s\tShould report 1 dangling index entry: %v.", failed, cr.Dangling)
+	}
+	t.Logf("%s\tShould report 1 dangling index entry.", success)
+
+	if len(cr.Unindexed) != 1 || cr.Unindexed[0] != "4" {
+		t.Fatalf("%s\tShould report record 4 as unindexed: %v.", failed, cr.Unindexed)
+	}
+	t.Logf("%s\tShould report record 4 as unindexed.", success)
+
+	if len(cr.Duplicates) != 1 || cr.Duplicates[0].Value != "three@example.com" {
+		t.Fatalf("%s\tShould report a duplicate value: %+v.", failed, cr.Duplicates)
+	}
+	t.Logf("%s\tShould report a duplicate value.", success)
+
+	if len(cr.Orphans) != 1 {
+		t.Fatalf("%s\tShould report 1 orphan file: %v.", failed, cr.Orphans)
+	}
+	t.Logf("%s\tShould report 1 orphan file.", success)
+
+	if _, err := store.Repair(context.Background(), sdstore.RepairOptions{}); err != nil {
+		t.Fatalf("%s\tShould be able to repair the store: %v.", failed, err)
+	}
+	t.Logf("%s\tShould be able to repair the store.", success)
+
+	if _, err := os.Stat(filepath.Join(path, "verify", sdstore.LostAndFound, "test", "1.sds")); err != nil {
+		t.Fatalf("%s\tShould have moved the corrupt record to lost+found: %v.", failed, err)
+	}
+	t.Logf("%s\tShould have moved the corrupt record to lost+found.", success)
+
+	report, err = store.Verify(context.Background())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to verify the repaired store: %v.", failed, err)
+	}
+
+	cr = report.Collections[0]
+	if cr.Records != 2 || len(cr.Corrupt) != 0 || len(cr.Dangling) != 0 || len(cr.Orphans) != 0 || len(cr.Unindexed) != 1 {
+		t.Fatalf("%s\tShould only report the remaining duplicate: %+v.", failed, cr)
+	}
+	t.Logf("%s\tShould only report the remaining duplicate.", success)
+}
+
+type Blob struct {
+	ID   string
+	Data []byte
+	At   time.Time
+}
+
+func TestVerifyRepairTypedKeys(t *testing.T) {
+	path := t.TempDir()
+	store, err := sdstore.New("verify", path, sdstore.WithJSONEncoding())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+	}
+
+	c, err := store.Collection("blobs", Blob{}, sdstore.WithIndexedFields("Data", "At"))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+	}
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+	for i, data := range []string{"hi", "there"} {
+		id := string(rune('1' + i))
+		if err := c.Create(id, Blob{ID: id, Data: []byte(data), At: at.Add(time.Duration(i) * time.Hour)}); err != nil {
+			t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+		}
+	}
+
+	// The opened store knows the record type, a store opened again doesn't.
+	generic, err := sdstore.New("verify", path, sdstore.WithJSONEncoding())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to open the store again: %v.", failed, err)
+	}
+
+	dir := filepath.Join(path, "verify", "blobs")
+	for name, s := range map[string]*sdstore.SDStore{"typed": store, "generic": generic} {
+		report, err := s.Verify(context.Background())
+		if err != nil || !report.OK() {
+			t.Fatalf("%s\tShould verify byte slice and time indexes of a %s store: %+v, %v.", failed, name, report, err)
+		}
+		t.Logf("%s\tShould verify byte slice and time indexes of a %s store.", success, name)
+
+		// An orphan file makes Repair rebuild the index. The time of record 2
+		// is missing from the index, so a generic store has to key it by the
+		// kind of the other times.
+		if err := os.WriteFile(filepath.Join(dir, ".1.sds-123.tmp"), nil, 0600); err != nil {
+			t.Fatalf("%s\tShould be able to create an orphan file: %v.", failed, err)
+		}
+		dropIndexEntry(t, filepath.Join(dir, "blobs.sdx"), "At", "2")
+		if _, err := s.Repair(context.Background(), sdstore.RepairOptions{}); err != nil {
+			t.Fatalf("%s\tShould be able to repair the store: %v.", failed, err)
+		}
+
+		c, err := s.Collection("blobs", Blob{}, sdstore.WithIndexedFields("Data", "At"))
+		if err != nil {
+			t.Fatalf("%s\tShould be able to reopen the collection: %v.", failed, err)
+		}
+		var got Blob
+		if err := c.GetIndexed("Data", []byte("hi"), &got); err != nil || got.ID != "1" {
+			t.Fatalf("%s\tShould find a record by bytes after a repair of a %s store: got %q, %v.", failed, name, got.ID, err)
+		}
+		if err := c.GetIndexed("At", at.Add(time.Hour), &got); err != nil || got.ID != "2" {
+			t.Fatalf("%s\tShould find a record by time after a repair of a %s store: got %q, %v.", failed, name, got.ID, err)
+		}
+		t.Logf("%s\tShould keep byte slice and time indexes on a repair of a %s store.", success, name)
+	}
+}
+
+func TestVerifyRepairRecordIndexes(t *testing.T) {
+	path := t.TempDir()
+	store, err := sdstore.New("verify", path, sdstore.WithJSONEncoding())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new store: %v.", failed, err)
+	}
+
+	c, err := store.Collection("test", Record{}, sdstore.WithFullTextIndex("Name"), sdstore.WithMultiKeyIndex("Email"))
+	if err != nil {
+		t.Fatalf("%s\tShould be able to create a new collection: %v.", failed, err)
+	}
+	for _, rec := range []Record{
+		{ID: "1", Name: "Alpha", Email: "one@example.com"},
+		{ID: "2", Name: "Beta", Email: "two@example.com"},
+	} {
+		if err := c.Create(rec.ID, rec); err != nil {
+			t.Fatalf("%s\tShould be able to create a record: %v.", failed, err)
+		}
+	}
+
+	dir := filepath.Join(path, "verify", "test")
+	if err := os.WriteFile(filepath.Join(dir, "1.sds"), []byte("{not json"), 0600); err != nil {
+		t.Fatalf("%s\tShould be able to corrupt a record: %v.", failed, err)
+	}
+	if err := os.Remove(filepath.Join(dir, "2.sds")); err != nil {
+		t.Fatalf("%s\tShould be able to remove a record: %v.", failed, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "3.sds"), []byte(`{"ID":"3","Name":"Gamma","Email":"three@example.com"}`), 0600); err != nil {
+		t.Fatalf("%s\tShould be able to create an unindexed record: %v.", failed, err)
+	}
+
+	report, err := store.Verify(context.Background())
+	if err != nil {
+		t.Fatalf("%s\tShould be able to verify the store: %v.", failed, err)
+	}
+	cr := report.Collections[0]
+	if len(cr.Stale) != 1 || cr.Stale[0] != "2" {
+		t.Fatalf("%s\tShould report the stale entries of record 2: %v.", failed, cr.Stale)
+	}
+	if len(cr.Unindexed) != 1 || cr.Unindexed[0] != "3" {
+		t.Fatalf("%s\tShould report record 3 as unindexed: %v.", failed, cr.Unindexed)
+	}
+	t.Logf("%s\tShould verify the full-text and multi-key indexes.", success)
+
+	if _, err := store.Repair(context.Background(), sdstore.RepairOptions{}); err != nil {
+		t.Fatalf("%s\tShould be able to repair the store: %v.", failed, err)
+	}
+
+	// The opened collection sees the rebuilt indexes.
+	for _, tc := range []struct {
+		term, email, id string
+	}{
+		{"alpha", "one@example.com", ""},
+		{"beta", "two@example.com", ""},
+		{"gamma", "three@example.com", "3"},
+	} {
+		res, err := c.Search(tc.term, 0)
+		if err != nil {
+			t.Fatalf("%s\tShould be able to search: %v.", failed, err)
+		}
+		recs, err := c.FindBy("Email", tc.email)
+		if err != nil {
+			t.Fatalf("%s\tShould be able to find records: %v.", failed, err)
+		}
+		if tc.id == "" && (len(res) != 0 || len(recs) != 0) {
+			t.Fatalf("%s\tShould not find the quarantined or removed record by %q: %+v, %v.", failed, tc.term, res, recs)
+		}
+		if tc.id != "" && (len(res) != 1 || res[0].ID != tc.id || len(recs) != 1) {
+			t.Fatalf("%s\tShould find record %s after the repair: %+v, %v.", failed, tc.id, res, recs)
+		}
+	}
+
+	report, err = store.Verify(context.Background())
+	if err != nil || !report.OK() {
+		t.Fatalf("%s\tShould verify the repaired store: %+v, %v.", failed, report, err)
+	}
+	t.Logf("%s\tShould rebuild the full-text and multi-key indexes on a repair.", success)
+}
+
+// dropIndexEntry removes the entry of record id for field from the JSON
+// encoded index file at path.
+func dropIndexEntry(t *testing.T, path string, field string, id string) {
+	t.Helper()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%s\tShould be able to read the index file: %v.", failed, err)
+	}
+	var idx map[string]any
+	if err := json.Unmarshal(b, &idx); err != nil {
+		t.Fatalf("%s\tShould be able to decode the index file: %v.", failed, err)
+	}
+	for k, v := range idx["Indexes"].(map[string]any) {
+		if strings.HasPrefix(k, field+":") && v == id {
+			delete(idx["Indexes"].(map[string]any), k)
+		}
+	}
+	if b, err = json.Marshal(idx); err != nil {
+		t.Fatalf("%s\tShould be able to encode the index file: %v.", failed, err)
+	}
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		t.Fatalf("%s\tShould be able to write the index file: %v.", failed, err)
+	}
+}